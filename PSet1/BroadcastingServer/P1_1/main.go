@@ -1,6 +1,9 @@
 package main
 
 import (
+	"distsys/common/sim"
+	"distsys/common/trace"
+	"flag"
 	"fmt"
 	"math/rand"
 	"strconv"
@@ -12,18 +15,21 @@ type ClientData struct {
 	SendingChannel   chan Message
 	ReceivingChannel chan Message
 	NumberOfClients  int
+	Tracer           *trace.Tracer // records what the client does, nil unless -trace is given
 }
 
 type ServerData struct {
 	ReceivingChannel     chan Message
 	ClientsData          []ClientData
 	BroadcastingChannels []chan Message
+	Tracer               *trace.Tracer
 }
 
 type Message struct {
 	Content []int
 	Sender  int
 	//Clock int
+	Trace trace.Context
 }
 
 type Event struct {
@@ -32,10 +38,15 @@ type Event struct {
 	Receiver int
 }
 
+// what the two kinds of message are called in a trace
+var CLIENT_MESSAGE = trace.Info{Type: "message", PageId: trace.NO_PAGE}
+var SERVER_BROADCAST = trace.Info{Type: "broadcast", PageId: trace.NO_PAGE}
+
 type ServerBroadcastInput struct {
 	Message      Message
 	Clients      []ClientData
 	Delay        time.Duration
+	Tracer       *trace.Tracer
 	EventChannel chan Event
 }
 
@@ -47,6 +58,7 @@ func client(data ClientData) {
 		select {
 		// message received from server
 		case msg := <-data.ReceivingChannel:
+			data.Tracer.Receive(msg.Trace, SERVER_BROADCAST)
 			fmt.Printf("%v received from server by client %d\n", msg.Content, data.Id)
 
 		// random timeout to signal a send message
@@ -54,7 +66,8 @@ func client(data ClientData) {
 			message[data.Id] = message[data.Id] + 1
 			sendCopy := make([]int, len(message))
 			copy(sendCopy, message)
-			data.SendingChannel <- Message{sendCopy, data.Id}
+			data.Tracer.Begin("new message", trace.NO_PAGE)
+			data.SendingChannel <- Message{sendCopy, data.Id, data.Tracer.Send(data.NumberOfClients, CLIENT_MESSAGE)}
 			fmt.Printf("Client %d has sent %v\n", data.Id, sendCopy)
 		}
 	}
@@ -65,11 +78,12 @@ func server(data ServerData) {
 	for {
 		select {
 		case messageReceived := <-data.ReceivingChannel:
+			data.Tracer.Receive(messageReceived.Trace, CLIENT_MESSAGE)
 			fmt.Printf("%v received from Client %v\n", messageReceived.Content, messageReceived.Sender)
 
 			//add delay for broadcast
 			broadcastDelay := time.Millisecond * (time.Duration(rand.Intn(9000) + 1000))
-			broadcastInput := ServerBroadcastInput{messageReceived, data.ClientsData, broadcastDelay, eventChannel}
+			broadcastInput := ServerBroadcastInput{messageReceived, data.ClientsData, broadcastDelay, data.Tracer, eventChannel}
 			go broadcast(broadcastInput)
 
 		case eventReceived := <-eventChannel:
@@ -82,17 +96,29 @@ func server(data ServerData) {
 func broadcast(input ServerBroadcastInput) {
 	<-time.After(input.Delay)
 	fmt.Print("Starting to broadcast message from Server\n")
+	input.Tracer.Resume("broadcast", trace.NO_PAGE, input.Message.Trace)
 	for i := 0; i < len(input.Clients); i++ {
 		if input.Clients[i].Id == input.Message.Sender {
 			continue
 		}
-		input.Clients[i].ReceivingChannel <- input.Message
+		forwarded := input.Message
+		forwarded.Trace = input.Tracer.Send(input.Clients[i].Id, SERVER_BROADCAST)
+		input.Clients[i].ReceivingChannel <- forwarded
 		//report to server completion of event
 		input.EventChannel <- Event{input.Message.Content, -1, input.Clients[i].Id}
 	}
 }
 
 func main() {
+	tracePath := flag.String("trace", "", "write a trace of every client's and the server's events to this file, see common/trace")
+	flag.Parse()
+	recorder, err := trace.Open(*tracePath)
+	if err != nil {
+		fmt.Printf("could not open trace: %v\n", err)
+		return
+	}
+	defer recorder.Close()
+
 	var processStarted = false
 	for {
 		if !processStarted {
@@ -119,6 +145,7 @@ func main() {
 					SendingChannel:   serverRecevingChannel,
 					ReceivingChannel: serverBroadcastingChannels[i],
 					NumberOfClients:  int(numberOfClients),
+					Tracer:           recorder.Tracer(i, fmt.Sprintf("client %v", i), sim.WallClock{}),
 				}
 				go client(clientData)
 				clientArray[i] = clientData
@@ -127,6 +154,7 @@ func main() {
 				ReceivingChannel:     serverRecevingChannel,
 				ClientsData:          clientArray,
 				BroadcastingChannels: serverBroadcastingChannels,
+				Tracer:               recorder.Tracer(int(numberOfClients), "server", sim.WallClock{}),
 			})
 		}
 	}
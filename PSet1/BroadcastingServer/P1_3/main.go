@@ -1,6 +1,9 @@
 package main
 
 import (
+	"distsys/common/sim"
+	"distsys/common/trace"
+	"flag"
 	"fmt"
 	"math"
 	"math/rand"
@@ -15,6 +18,7 @@ type ClientData struct {
 	SendingChannel   chan Message
 	ReceivingChannel chan Message
 	NumberOfClients  int
+	Tracer           *trace.Tracer // records what the client does, nil unless -trace is given
 	Terminate        chan *sync.WaitGroup
 	ReportMutex      *sync.Mutex
 }
@@ -23,18 +27,25 @@ type ServerData struct {
 	ReceivingChannel     chan Message
 	ClientsData          []ClientData
 	BroadcastingChannels []chan Message
+	Tracer               *trace.Tracer
 }
 
 type Message struct {
 	Content []int
 	Sender  int
 	Clock   []float64
+	Trace   trace.Context
 }
 
+// what the two kinds of message are called in a trace
+var CLIENT_MESSAGE = trace.Info{Type: "message", PageId: trace.NO_PAGE}
+var SERVER_BROADCAST = trace.Info{Type: "broadcast", PageId: trace.NO_PAGE}
+
 type ServerBroadcastInput struct {
 	Message      Message
 	Clients      []ClientData
 	Delay        time.Duration
+	Tracer       *trace.Tracer
 	EventChannel chan Message //test
 }
 
@@ -46,6 +57,7 @@ func client(data ClientData) {
 		select {
 		// message received from server
 		case msg := <-data.ReceivingChannel:
+			data.Tracer.Receive(msg.Trace, SERVER_BROADCAST)
 			fmt.Printf("\n%v received from server by client %d", msg.Content, data.Id)
 			messagesToBeRead = append(messagesToBeRead, msg)
 			var internalClockCopy = make([]float64, data.NumberOfClients+1)
@@ -65,7 +77,8 @@ func client(data ClientData) {
 			clock[data.Id] += 1
 			copy(tempClockCopy, clock)
 
-			data.SendingChannel <- Message{sendCopy, data.Id, tempClockCopy}
+			data.Tracer.Begin("new message", trace.NO_PAGE)
+			data.SendingChannel <- Message{sendCopy, data.Id, tempClockCopy, data.Tracer.Send(data.NumberOfClients, CLIENT_MESSAGE)}
 			fmt.Printf("\nClient %d has sent %v", data.Id, tempClockCopy)
 
 		//print the order of messages to be read every 15 + Id seconds
@@ -118,6 +131,7 @@ func server(data ServerData) {
 
 		select {
 		case messageReceived := <-data.ReceivingChannel:
+			data.Tracer.Receive(messageReceived.Trace, CLIENT_MESSAGE)
 			fmt.Printf("\n%v received from Client %v", messageReceived.Content, messageReceived.Sender)
 
 			var tempClockCopy = make([]float64, cap(data.ClientsData)+1)
@@ -128,7 +142,7 @@ func server(data ServerData) {
 
 			//add delay for broadcast
 			broadcastDelay := time.Millisecond * (time.Duration(rand.Intn(9000) + 1000))
-			broadcastInput := ServerBroadcastInput{messageReceived, data.ClientsData, broadcastDelay, eventChannel}
+			broadcastInput := ServerBroadcastInput{messageReceived, data.ClientsData, broadcastDelay, data.Tracer, eventChannel}
 			go broadcast(broadcastInput)
 
 		case eventMessage := <-eventChannel:
@@ -150,20 +164,31 @@ func vectorMax(v1, v2 []float64) []float64 {
 func broadcast(input ServerBroadcastInput) {
 	<-time.After(input.Delay)
 	fmt.Print("\nStarting to broadcast message from Server")
+	input.Tracer.Resume("broadcast", trace.NO_PAGE, input.Message.Trace)
 	for i := 0; i < len(input.Clients); i++ {
 		if input.Clients[i].Id == input.Message.Sender {
 			continue
 		}
-		input.Clients[i].ReceivingChannel <- input.Message
+		forwarded := input.Message
+		forwarded.Trace = input.Tracer.Send(input.Clients[i].Id, SERVER_BROADCAST)
+		input.Clients[i].ReceivingChannel <- forwarded
 		//report to server completion of event
 	}
 	input.EventChannel <- input.Message
 }
 
 func main() {
+	tracePath := flag.String("trace", "", "write a trace of every client's and the server's events to this file, see common/trace")
+	flag.Parse()
+	recorder, err := trace.Open(*tracePath)
+	if err != nil {
+		fmt.Printf("could not open trace: %v\n", err)
+		return
+	}
+	defer recorder.Close()
+
 	var processStarted = false
 	var numberOfClients int
-	var err error
 	var serverRecevingChannel chan Message
 	var serverBroadcastingChannels []chan Message
 	var clientTerminatingChannels []chan *sync.WaitGroup
@@ -206,6 +231,7 @@ func main() {
 					SendingChannel:   serverRecevingChannel,
 					ReceivingChannel: serverBroadcastingChannels[i],
 					NumberOfClients:  int(numberOfClients),
+					Tracer:           recorder.Tracer(i, fmt.Sprintf("client %v", i), sim.WallClock{}),
 					Terminate:        clientTerminatingChannels[i],
 					ReportMutex:      &reportMutex,
 				}
@@ -216,6 +242,7 @@ func main() {
 				ReceivingChannel:     serverRecevingChannel,
 				ClientsData:          clientArray,
 				BroadcastingChannels: serverBroadcastingChannels,
+				Tracer:               recorder.Tracer(int(numberOfClients), "server", sim.WallClock{}),
 			})
 		}
 	}
@@ -1,7 +1,13 @@
 package main
 
 import (
+	"distsys/common/faults"
+	"distsys/common/scenario"
+	"distsys/common/sim"
+	"distsys/common/trace"
+	"flag"
 	"fmt"
+	"os"
 	"time"
 )
 
@@ -15,35 +21,92 @@ const (
 	Acknowledge
 )
 
+var MESSAGE_TYPES = []string{"Rejection", "CoordinatorRequest", "NewCoordinator", "Hello", "Acknowledge"}
+
 type MachineData struct {
 	Id               int
-	Timeout          int            //Message Propagation Time + Message Handling time - timeout until initiating an election
-	Coordinator      int            //Current coordinator among other machines
-	IsDown           bool           //either Down or Up state
-	Channels         []chan Message //Receiving channels for the machines
-	IsSender         bool           //whether this machine will be be down for bully algorithm to start
-	IsInElection     bool           //Whether there is currently an election
-	NumberOfMachines int            //Number of machines to communicate with
+	Timeout          int                  //Message Propagation Time + Message Handling time - timeout until initiating an election
+	Coordinator      int                  //Current coordinator among other machines
+	IsDown           bool                 //either Down or Up state
+	Inbox            <-chan Message       //Receiving channel for the machine, nil in a simulation
+	Network          sim.Network[Message] //Sends to the other machines
+	Runtime          sim.Runtime          //Timers and time, real or simulated
+	Tracer           *trace.Tracer        //Records what the machine does, nil unless -trace is given
+	IsSender         bool                 //whether this machine will be be down for bully algorithm to start
+	IsInElection     bool                 //Whether there is currently an election
+	NumberOfMachines int                  //Number of machines to communicate with
 	Terminate        chan int
+	StillAlive       []bool //Machines that answered the last ping
 }
 
 type Message struct {
 	Sender int
 	Type   MessageType // 4 Types: (1) Hello, (2) RejectCoordinator (3) RequestToBeCoordinator (4) reject coordinator
+	Trace  trace.Context
+}
+
+func (m Message) TraceInfo() trace.Info {
+	return trace.Info{Type: MESSAGE_TYPES[m.Type], PageId: trace.NO_PAGE}
+}
+
+func (m Message) WithTrace(ctx trace.Context) Message {
+	m.Trace = ctx
+	return m
 }
 
 const numberOfMachines = 5
 
 func main() {
+	seed := flag.Int64("seed", 0, "run in a deterministic simulation with this seed instead of for real, the same seed gives the same run")
+	scenarioCase := flag.String("case", "w", "simulation only: best (b) or worst (w) case")
+	simTime := flag.Duration("simtime", 60*time.Second, "virtual time a simulation runs for")
+	faultsPath := flag.String("faults", "", "JSON file of faults to inject into the network, see common/faults")
+	scenarioPath := flag.String("scenario", "", "simulate the scenario in this file instead, see common/scenario. -seed overrides its seed")
+	tracePath := flag.String("trace", "", "write a trace of every machine's events to this file, see common/trace")
+	flag.Parse()
+
+	if *scenarioPath != "" {
+		sc, err := scenario.Load(*scenarioPath)
+		if err != nil {
+			fmt.Printf("could not load scenario: %v\n", err)
+			os.Exit(1)
+		}
+		if *seed != 0 {
+			sc.Seed = *seed
+		}
+		report := simulate(sc, *tracePath)
+		fmt.Println(report)
+		if !report.Passed() {
+			os.Exit(1)
+		}
+		return
+	}
+
+	if *seed != 0 {
+		sc, err := caseScenario(*seed, *scenarioCase, *simTime, *faultsPath)
+		if err != nil {
+			fmt.Printf("could not load faults: %v\n", err)
+			return
+		}
+		simulate(sc, *tracePath)
+		return
+	}
+
+	recorder, err := trace.Open(*tracePath)
+	if err != nil {
+		fmt.Printf("could not open trace: %v\n", err)
+		return
+	}
+	defer recorder.Close()
+
 	processStarted := false
 	for {
 		if !processStarted {
 			fmt.Printf("Hi Prof! Please best(b) or worst (w) case> ")
 		}
-		channels := make([]chan Message, numberOfMachines)
+		network := sim.NewChannelNetwork[Message](numberOfMachines, 10*numberOfMachines)
 		terminationChannels := make([]chan int, numberOfMachines)
 		for i := 0; i < numberOfMachines; i++ {
-			channels[i] = make(chan Message, 10*numberOfMachines)
 			terminationChannels[i] = make(chan int, numberOfMachines)
 		}
 
@@ -65,13 +128,24 @@ func main() {
 		}
 		processStarted = true
 
+		// partitions are timed from here
+		injector, err := faults.Open(*faultsPath, sim.WallClock{}, nil)
+		if err != nil {
+			fmt.Printf("could not load faults: %v\n", err)
+			return
+		}
+
 		for i := 0; i < numberOfMachines; i++ {
-			go machine(MachineData{
+			tracer := recorder.Tracer(i, fmt.Sprintf("machine %v", i), sim.WallClock{})
+			go machine(&MachineData{
 				Id:               i,
 				Timeout:          4,
 				Coordinator:      4,
 				IsDown:           i == numberOfMachines-1,
-				Channels:         channels,
+				Inbox:            network.Inbox(i),
+				Network:          trace.Wrap[Message](faults.Wrap[Message](network, i, injector), tracer),
+				Runtime:          sim.NewRealRuntime(),
+				Tracer:           tracer,
 				IsSender:         i == sender,
 				Terminate:        terminationChannels[i],
 				NumberOfMachines: numberOfMachines,
@@ -82,16 +156,113 @@ func main() {
 	fmt.Print("program has ended \n")
 }
 
-func machine(self MachineData) {
-	//check state => if Down, don't respond to messages
-	ticker := time.NewTicker(time.Duration(self.Id+self.NumberOfMachines) * time.Second) //used for regular ping checks
-	electionTimeoutChannel := make(chan int, 5)
-	pingTimeoutChannel := make(chan int, 2)
+// caseScenario is the best or worst case the prompt offers, as a scenario: the last machine is down from the start
+func caseScenario(seed int64, scenarioCase string, simTime time.Duration, faultsPath string) (*scenario.Scenario, error) {
+	sender := 0
+	if scenarioCase == "b" {
+		sender = numberOfMachines - 2
+	}
+	sc := &scenario.Scenario{
+		Seed:     seed,
+		Duration: faults.Duration{Duration: simTime},
+		Nodes:    numberOfMachines,
+		Workload: scenario.Workload{Senders: []int{sender}},
+		Crashes:  []scenario.Crash{{Node: numberOfMachines - 1}},
+	}
+	if faultsPath != "" {
+		config, err := faults.LoadConfig(faultsPath)
+		if err != nil {
+			return nil, err
+		}
+		sc.Faults = &config
+	}
+	return sc, nil
+}
 
-	machinesStillAlive := make([]bool, self.NumberOfMachines)
-	for i := 0; i < self.NumberOfMachines; i++ {
-		machinesStillAlive[i] = true
+func simulate(sc *scenario.Scenario, tracePath string) *scenario.Report {
+	/**
+	Same machines, but every message and timer is run by the simulator,
+	one at a time in an order picked by the seed. A machine that is down ignores its messages and timers
+	1. Fill in what the scenario leaves out with the defaults of a real run
+	2. Schedule the crashes and recoveries
+	3. Run, then check the coordinator every machine that is up ended with
+	*/
+	machines := sc.Nodes
+	if machines == 0 {
+		machines = numberOfMachines
+	}
+	timeout := int(sc.Timeout.Duration / time.Second)
+	if timeout == 0 {
+		timeout = 4
+	}
+	senders := sc.Workload.Senders
+	if senders == nil {
+		senders = []int{machines - 2}
+	}
+	simTime := sc.Duration.Duration
+	if simTime == 0 {
+		simTime = 60 * time.Second
 	}
+
+	s := sim.New(sc.Seed)
+	network := sim.NewSimNetwork[Message](s, machines)
+	injector := sc.Injector(s, s.Rand)
+	recorder, err := trace.Open(tracePath)
+	report := scenario.NewReport(sc)
+	if err != nil {
+		report.Expect(false, "could not open trace: %v", err)
+		return report
+	}
+	defer recorder.Close()
+	all := make([]*MachineData, machines)
+	for i := 0; i < machines; i++ {
+		tracer := recorder.Tracer(i, fmt.Sprintf("machine %v", i), s)
+		self := &MachineData{
+			Id:               i,
+			Timeout:          timeout,
+			Coordinator:      machines - 1,
+			Network:          trace.Wrap[Message](faults.Wrap[Message](network, i, injector), tracer),
+			Runtime:          s,
+			Tracer:           tracer,
+			NumberOfMachines: machines,
+		}
+		for _, sender := range senders {
+			self.IsSender = self.IsSender || sender == i
+		}
+		all[i] = self
+		self.Init()
+		network.Handle(i, self.HandleMessage)
+	}
+
+	for _, crash := range sc.Crashes {
+		self := all[crash.Node]
+		s.After(crash.At.Duration, self.Crash)
+		if crash.For.Duration > 0 {
+			s.After(crash.At.Duration+crash.For.Duration, self.Recover)
+		}
+	}
+
+	s.Run(simTime)
+	fmt.Printf("seed %v: simulation ended after %v of virtual time, %v events\n", sc.Seed, s.Elapsed(), s.Steps())
+	if injector != nil {
+		fmt.Println(injector)
+	}
+
+	if sc.Expect.Leader != nil {
+		leader := *sc.Expect.Leader
+		report.Expect(!all[leader].IsDown, "expected coordinator %v is down", leader)
+		for _, self := range all {
+			if !self.IsDown {
+				report.Expect(self.Coordinator == leader, "machine %v has coordinator %v, expected %v", self.Id, self.Coordinator, leader)
+			}
+		}
+	}
+	return report
+}
+
+func machine(self *MachineData) {
+	//check state => if Down, don't respond to messages
+	self.Init()
 	for {
 		if self.IsDown {
 			// don't respond to messages
@@ -100,110 +271,152 @@ func machine(self MachineData) {
 
 		select {
 		case <-self.Terminate:
-			ticker.Stop()
 			fmt.Printf("%v : Terminating now\n", self.Id)
 			return
-		case <-ticker.C:
-			if !self.IsInElection && self.IsSender {
-				fmt.Printf("%v : regular ping checks\n", self.Id)
-				if self.Id != self.Coordinator {
-					self.Channels[self.Coordinator] <- Message{Sender: self.Id, Type: Hello}
-					machinesStillAlive[self.Coordinator] = false
-				}
-
-				go start_timeout(pingTimeoutChannel, self.Timeout)
-			}
-		case <-pingTimeoutChannel: //regular ping - check node failure
-			if !self.IsInElection {
-				//check for machine failure
-				fmt.Printf("%v : Regular ping timeout. Checking for machine failure\n", self.Id)
-
-				machineFailureDetected := false
-				for i := 0; i < self.NumberOfMachines; i++ {
-					if !machinesStillAlive[i] {
-						machineFailureDetected = true
-						fmt.Printf("%v : machine %v failure detected\n", self.Id, i)
-						fmt.Printf("%v : machine %v is coordinator? %v \n", self.Id, i, self.Coordinator == i)
-					}
-				}
-				if machineFailureDetected && !machinesStillAlive[self.Coordinator] {
-					//start election
-					fmt.Printf("%v : starting election\n", self.Id)
-					self.IsInElection = true
-					for i := 0; i < self.NumberOfMachines; i++ {
-						if i <= self.Id {
-							continue //only ask machines of high id
-						}
-						self.Channels[i] <- Message{Sender: self.Id, Type: CoordinatorRequest}
-					}
-					self.Coordinator = self.Id //self elect, a reply would override this before timeout
-					go start_timeout(electionTimeoutChannel, self.Timeout)
-				}
-			}
-		case <-electionTimeoutChannel: //timeout handler
-			//election request time - check whether self.Coordinator is overriden
-			if self.IsInElection {
-				if self.Coordinator == self.Id {
-					// election succeeded - start broadcasting
-					fmt.Printf("%v : election succeeeded. Starting broadcast\n", self.Id)
-					for i := 0; i < self.NumberOfMachines; i++ {
-						if i == self.Id {
-							continue //no need to broadcast to self
-						}
-						self.Channels[i] <- Message{Sender: self.Id, Type: NewCoordinator}
-					}
-				}
-				if self.Coordinator != self.Id {
-					//Election failed do nothing
-					fmt.Printf("%v : Election failed. \n", self.Id)
-				}
-			}
+		case f := <-sim.EventsOf(self.Runtime): //ping ticks, ping timeouts and election timeouts
+			f()
+		case msg := <-self.Inbox: //message handler
+			self.HandleMessage(msg)
+		}
+	}
+}
 
-		case msg := <-self.Channels[self.Id]: //message handler
-			switch msg.Type {
-			case Hello:
-				fmt.Printf("%v : ping from %v received\n", self.Id, msg.Sender)
-				self.Channels[msg.Sender] <- Message{Sender: self.Id, Type: Acknowledge} //reply the ping message
-
-			case Acknowledge:
-				fmt.Printf("%v : ping acknowledgement from %v received\n", self.Id, msg.Sender)
-				machinesStillAlive[msg.Sender] = true //update that machine is still alive
-
-			case CoordinatorRequest:
-				fmt.Printf("%v : coordinator request from %v received\n", self.Id, msg.Sender)
-				if msg.Sender < self.Id { //reply no if Id is higher
-					fmt.Printf("%v : Rejecting coordinator request from %v received\n", self.Id, msg.Sender)
-					self.Channels[msg.Sender] <- Message{Sender: self.Id, Type: Rejection}
-
-					//start election
-					fmt.Printf("%v : starting election\n", self.Id)
-					self.IsInElection = true
-					for i := 0; i < self.NumberOfMachines; i++ {
-						if i <= self.Id {
-							continue //only ask machines of high id
-						}
-						self.Channels[i] <- Message{Sender: self.Id, Type: CoordinatorRequest}
-					}
-					self.Coordinator = self.Id //self elect, a reply would override this before timeout
-					go start_timeout(electionTimeoutChannel, self.Timeout)
-				}
-
-			case Rejection:
-				self.Coordinator = msg.Sender
-				fmt.Printf("%v : Rejection. new coordinator is temporarily set to %v\n", self.Id, self.Coordinator)
-
-			case NewCoordinator: // set coordinator to sender
-				self.Coordinator = msg.Sender
-				fmt.Printf("%v : new coordinator is  %v\n", self.Id, self.Coordinator)
-				self.IsInElection = false
-			}
+func (self *MachineData) Init() {
+	self.StillAlive = make([]bool, self.NumberOfMachines)
+	for i := 0; i < self.NumberOfMachines; i++ {
+		self.StillAlive[i] = true
+	}
+	self.Runtime.After(self.pingInterval(), self.HandlePingTick)
+}
+
+// used for regular ping checks
+func (self *MachineData) pingInterval() time.Duration {
+	return time.Duration(self.Id+self.NumberOfMachines) * time.Second
+}
 
+func (self *MachineData) timeout() time.Duration {
+	return time.Second * time.Duration(self.Timeout)
+}
+
+// Crash takes the machine down in a simulation, it ignores every message and timer until it recovers
+func (self *MachineData) Crash() {
+	fmt.Printf("%v : crashed\n", self.Id)
+	self.Tracer.Local("crashed", trace.NO_PAGE)
+	self.IsDown = true
+}
+
+// Recover brings a crashed machine back, which starts an election like any machine that comes back up
+func (self *MachineData) Recover() {
+	fmt.Printf("%v : recovered\n", self.Id)
+	self.Tracer.Begin("recovered", trace.NO_PAGE)
+	self.IsDown = false
+	self.IsInElection = false
+	for i := 0; i < self.NumberOfMachines; i++ {
+		self.StillAlive[i] = true
+	}
+	self.StartElection()
+}
+
+func (self *MachineData) HandlePingTick() {
+	self.Runtime.After(self.pingInterval(), self.HandlePingTick)
+	if !self.IsInElection && self.IsSender && !self.IsDown {
+		fmt.Printf("%v : regular ping checks\n", self.Id)
+		self.Tracer.Begin("ping", trace.NO_PAGE)
+		if self.Id != self.Coordinator {
+			self.Network.Send(self.Coordinator, Message{Sender: self.Id, Type: Hello})
+			self.StillAlive[self.Coordinator] = false
 		}
 
+		self.Runtime.After(self.timeout(), self.HandlePingTimeout)
 	}
 }
 
-func start_timeout(timeoutChannel chan int, duration int) {
-	time.Sleep(time.Second * time.Duration(duration))
-	timeoutChannel <- 0
+func (self *MachineData) HandlePingTimeout() { //regular ping - check node failure
+	if self.IsInElection || self.IsDown {
+		return
+	}
+	//check for machine failure
+	fmt.Printf("%v : Regular ping timeout. Checking for machine failure\n", self.Id)
+
+	machineFailureDetected := false
+	for i := 0; i < self.NumberOfMachines; i++ {
+		if !self.StillAlive[i] {
+			machineFailureDetected = true
+			fmt.Printf("%v : machine %v failure detected\n", self.Id, i)
+			fmt.Printf("%v : machine %v is coordinator? %v \n", self.Id, i, self.Coordinator == i)
+		}
+	}
+	if machineFailureDetected && !self.StillAlive[self.Coordinator] {
+		self.Tracer.Begin("coordinator failure", trace.NO_PAGE)
+		self.StartElection()
+	}
+}
+
+func (self *MachineData) StartElection() {
+	fmt.Printf("%v : starting election\n", self.Id)
+	self.Tracer.Local("election", trace.NO_PAGE)
+	self.IsInElection = true
+	for i := 0; i < self.NumberOfMachines; i++ {
+		if i <= self.Id {
+			continue //only ask machines of high id
+		}
+		self.Network.Send(i, Message{Sender: self.Id, Type: CoordinatorRequest})
+	}
+	self.Coordinator = self.Id //self elect, a reply would override this before timeout
+	self.Runtime.After(self.timeout(), self.HandleElectionTimeout)
+}
+
+func (self *MachineData) HandleElectionTimeout() {
+	//election request time - check whether self.Coordinator is overriden
+	if !self.IsInElection || self.IsDown {
+		return
+	}
+	if self.Coordinator == self.Id {
+		// election succeeded - start broadcasting
+		fmt.Printf("%v : election succeeeded. Starting broadcast\n", self.Id)
+		self.Tracer.Local("elected", trace.NO_PAGE)
+		for i := 0; i < self.NumberOfMachines; i++ {
+			if i == self.Id {
+				continue //no need to broadcast to self
+			}
+			self.Network.Send(i, Message{Sender: self.Id, Type: NewCoordinator})
+		}
+	}
+	if self.Coordinator != self.Id {
+		//Election failed do nothing
+		fmt.Printf("%v : Election failed. \n", self.Id)
+	}
+}
+
+func (self *MachineData) HandleMessage(msg Message) {
+	if self.IsDown {
+		return // only happens in a simulation, a machine that is down doesn't read its inbox
+	}
+	self.Tracer.Receive(msg.Trace, msg.TraceInfo())
+	switch msg.Type {
+	case Hello:
+		fmt.Printf("%v : ping from %v received\n", self.Id, msg.Sender)
+		self.Network.Send(msg.Sender, Message{Sender: self.Id, Type: Acknowledge}) //reply the ping message
+
+	case Acknowledge:
+		fmt.Printf("%v : ping acknowledgement from %v received\n", self.Id, msg.Sender)
+		self.StillAlive[msg.Sender] = true //update that machine is still alive
+
+	case CoordinatorRequest:
+		fmt.Printf("%v : coordinator request from %v received\n", self.Id, msg.Sender)
+		if msg.Sender < self.Id { //reply no if Id is higher
+			fmt.Printf("%v : Rejecting coordinator request from %v received\n", self.Id, msg.Sender)
+			self.Network.Send(msg.Sender, Message{Sender: self.Id, Type: Rejection})
+			self.StartElection()
+		}
+
+	case Rejection:
+		self.Coordinator = msg.Sender
+		fmt.Printf("%v : Rejection. new coordinator is temporarily set to %v\n", self.Id, self.Coordinator)
+
+	case NewCoordinator: // set coordinator to sender
+		self.Coordinator = msg.Sender
+		fmt.Printf("%v : new coordinator is  %v\n", self.Id, self.Coordinator)
+		self.IsInElection = false
+	}
 }
@@ -0,0 +1,451 @@
+// Command Benchmark measures the mutual exclusion algorithms of P1 to P7, and Maekawa, for every combination of node
+// count and request rate: how long a node waits for the lock (percentiles), how many requests are served per second
+// and how many messages each one takes. Every setup runs for -trials trials, each one a warm-up and then -duration of
+// measurement, in the deterministic simulator (a seed per trial) or for real with -real.
+//
+//	go run ./Benchmark -nodes 2,4,8,16 -rates 0,1,10 -trials 5 -o results.csv
+package main
+
+import (
+	"distsys/PSet2/mutex/lamport"
+	"distsys/PSet2/mutex/lockserver"
+	"distsys/PSet2/mutex/raymond"
+	"distsys/PSet2/mutex/ricartagrawala"
+	"distsys/PSet2/mutex/suzukikasami"
+	"distsys/PSet2/mutex/tokenring"
+	"distsys/PSet2/mutex/voting"
+	"distsys/common/bench"
+	"distsys/common/sim"
+	"distsys/common/trace"
+	"flag"
+	"fmt"
+	"math"
+	"math/rand"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// ALGORITHMS builds the nodes of every algorithm, each one named like its package
+var ALGORITHMS = map[string]func(c *cluster){
+	"ricartagrawala": newRicartAgrawala,
+	"voting":         newVoting,
+	"lockserver":     newLockServer,
+	"maekawa":        newMaekawa,
+	"tokenring":      newTokenRing,
+	"suzukikasami":   newSuzukiKasami,
+	"raymond":        newRaymond,
+	"lamport":        newLamport,
+}
+
+var ALGORITHM_ORDER = []string{"ricartagrawala", "voting", "lockserver", "maekawa", "tokenring", "suzukikasami", "raymond", "lamport"}
+
+// THINK_TIME is how long a node asked to -rates 0 waits after being served before asking again. A node that can
+// enter again without any message, like an idle token holder, would otherwise stop the simulator's clock
+const THINK_TIME = time.Microsecond
+
+// the table of combined trials printed while the results go to a file
+const TABLE_HEADER = "%-16v %6v %6v %10v %10v %9v %8v %9v %9v %9v %9v %10v\n"
+const TABLE_ROW = "%-16v %6v %6v %10v %10v %9.1f %8.1f %9.2f %9.2f %9.2f %9.2f %10v\n"
+
+func main() {
+	algorithms := flag.String("algorithms", strings.Join(ALGORITHM_ORDER, ","), "algorithms to measure, separated by commas")
+	nodeCounts := flag.String("nodes", "2,4,8,16", "numbers of nodes to measure, separated by commas")
+	rates := flag.String("rates", "0,1,10", "lock requests per second made by every node, separated by commas. 0 asks again as soon as the last request is served")
+	trials := flag.Int("trials", 3, "trials of every setup")
+	warmup := flag.Duration("warmup", time.Second, "time before anything is measured in every trial")
+	duration := flag.Duration("duration", 10*time.Second, "time measured in every trial, after the warm-up")
+	seed := flag.Int64("seed", 1, "seed of the first trial of every setup, the next trials count up from it")
+	runReal := flag.Bool("real", false, "run every node on its own goroutine on the wall clock instead of in the simulator")
+	outputPath := flag.String("o", "", "file to write every trial and the combined trials of every setup to, standard output if empty")
+	format := flag.String("format", "", "csv | json, picked from the extension of -o if empty, csv for standard output")
+	topology := flag.String("topology", raymond.BINARY, "spanning tree of raymond's nodes: "+strings.Join(raymond.TOPOLOGIES, " | ")+
+		", random trees are picked with the trial's seed")
+	flag.Parse()
+
+	names := strings.Split(*algorithms, ",")
+	for _, name := range names {
+		if _, ok := ALGORITHMS[name]; !ok {
+			fmt.Printf("unknown algorithm %q, pick from %v\n", name, strings.Join(ALGORITHM_ORDER, ", "))
+			os.Exit(2)
+		}
+	}
+	sizes, err := parseList(*nodeCounts, strconv.Atoi)
+	if err != nil {
+		fmt.Printf("bad -nodes: %v\n", err)
+		os.Exit(2)
+	}
+	requestRates, err := parseList(*rates, func(s string) (float64, error) { return strconv.ParseFloat(s, 64) })
+	if err != nil {
+		fmt.Printf("bad -rates: %v\n", err)
+		os.Exit(2)
+	}
+	if *format == "" {
+		*format = bench.CSV
+		if strings.HasSuffix(*outputPath, ".json") {
+			*format = bench.JSON
+		}
+	}
+
+	/**
+	1. Run every trial of every setup, printing the combined trials of each setup as a table if the results go to a file.
+	A setup that deadlocked in any trial is reported on standard error, its numbers only measure the requests served before
+	2. Write every trial and every combination
+	*/
+	if *outputPath != "" {
+		fmt.Printf(TABLE_HEADER, "algorithm", "nodes", "rate", "completed", "unfinished", "msgs/req", "req/s", "p50 ms", "p90 ms", "p99 ms", "max ms", "deadlocked")
+	}
+	results := []bench.Result{}
+	for _, name := range names {
+		for _, size := range sizes {
+			for _, rate := range requestRates {
+				setup := []bench.Result{}
+				for trial := 1; trial <= *trials; trial++ {
+					c := &cluster{Size: size, Rate: rate, Warmup: *warmup, Duration: *duration, Topology: *topology}
+					if !*runReal {
+						c.Simulator = sim.New(*seed + int64(trial-1))
+					}
+					result := c.run(ALGORITHMS[name])
+					result.Algorithm, result.Nodes, result.Rate, result.Trial = name, size, rate, trial
+					if c.Simulator != nil {
+						result.Seed = c.Simulator.Seed
+					}
+					setup = append(setup, result)
+				}
+				combined := bench.Combine(setup)
+				results = append(results, setup...)
+				results = append(results, combined)
+				if *outputPath != "" {
+					fmt.Printf(TABLE_ROW, name, size, rate, combined.Completed, combined.Unfinished, combined.MessagesPerRequest,
+						combined.Throughput, combined.P50, combined.P90, combined.P99, combined.Max, fmt.Sprintf("%v/%v", combined.Deadlocked, *trials))
+				}
+				if combined.Deadlocked > 0 {
+					fmt.Fprintf(os.Stderr, "%v with %v nodes at rate %v deadlocked in %v of %v trials: requests stopped being served\n",
+						name, size, rate, combined.Deadlocked, *trials)
+				}
+			}
+		}
+	}
+
+	output := os.Stdout
+	if *outputPath != "" {
+		if output, err = os.Create(*outputPath); err != nil {
+			fmt.Printf("could not create %v: %v\n", *outputPath, err)
+			os.Exit(1)
+		}
+		defer output.Close()
+	}
+	if err := bench.Write(output, *format, results); err != nil {
+		fmt.Printf("could not write results: %v\n", err)
+		os.Exit(1)
+	}
+}
+
+func parseList[T any](list string, parse func(string) (T, error)) ([]T, error) {
+	values := []T{}
+	for _, s := range strings.Split(list, ",") {
+		value, err := parse(strings.TrimSpace(s))
+		if err != nil {
+			return nil, err
+		}
+		values = append(values, value)
+	}
+	return values, nil
+}
+
+// cluster is one trial: Size nodes of an algorithm, each with a client making its lock requests
+type cluster struct {
+	Size      int
+	Rate      float64
+	Warmup    time.Duration
+	Duration  time.Duration
+	Simulator *sim.Simulator // nil when running for real
+	Topology  string         // raymond's tree
+
+	trial    *bench.Trial
+	clients  []*client
+	runtimes []sim.Runtime // every node's, the simulator or a RealRuntime of its own
+	stop     chan struct{} // closed to stop the nodes' goroutines when running for real
+}
+
+func (c *cluster) run(build func(c *cluster)) bench.Result {
+	/**
+	1. Give every node a runtime and a client, then let the algorithm build its nodes on them
+	2. Start every client's requests and run for the warm-up and the measurement
+	*/
+	c.stop = make(chan struct{})
+	for i := 0; i < c.Size; i++ {
+		var runtime sim.Runtime = c.Simulator
+		if c.Simulator == nil {
+			runtime = sim.NewRealRuntime()
+		}
+		c.runtimes = append(c.runtimes, runtime)
+		c.clients = append(c.clients, &client{Runtime: runtime, Rate: c.Rate})
+	}
+	c.trial = bench.NewTrial(c.runtimes[0].Now(), c.Warmup, c.Duration)
+	for _, cl := range c.clients {
+		cl.Trial = c.trial
+	}
+	build(c)
+
+	for _, cl := range c.clients {
+		cl.Runtime.After(cl.nextArrival(), cl.arrive)
+	}
+	if c.Simulator != nil {
+		c.Simulator.Run(c.Warmup + c.Duration)
+	} else {
+		time.Sleep(c.Warmup + c.Duration)
+		close(c.stop)
+	}
+	return c.trial.Result()
+}
+
+// countingNetwork counts every message for the trial before sending it
+type countingNetwork[M any] struct {
+	sim.Network[M]
+	trial *bench.Trial
+	clock trace.Clock
+}
+
+func (n countingNetwork[M]) Send(to int, m M) {
+	n.trial.Sent(n.clock.Now())
+	n.Network.Send(to, m)
+}
+
+// newNetwork returns a network for messages of type M, and serve, which delivers node id's messages to handle:
+// from the simulator, or from a goroutine that also runs the node's timers when running for real
+func newNetwork[M any](c *cluster) (sim.Network[M], func(id int, handle func(M))) {
+	if c.Simulator != nil {
+		network := sim.NewSimNetwork[M](c.Simulator, c.Size)
+		return countingNetwork[M]{network, c.trial, c.Simulator}, network.Handle
+	}
+	network := sim.NewChannelNetwork[M](c.Size, c.Size*100)
+	serve := func(id int, handle func(M)) {
+		go func() {
+			for {
+				select {
+				case m := <-network.Inbox(id):
+					handle(m)
+				case f := <-sim.EventsOf(c.runtimes[id]):
+					f()
+				case <-c.stop:
+					return
+				}
+			}
+		}()
+	}
+	return countingNetwork[M]{network, c.trial, sim.WallClock{}}, serve
+}
+
+// client makes one node's lock requests, queueing them while the node is still waiting for the last one
+type client struct {
+	Runtime sim.Runtime
+	Rate    float64
+	Trial   *bench.Trial
+	Request func() // asks the node for the lock, set by the algorithm
+
+	pending    []time.Time // when every request not served yet was made, oldest first
+	requesting bool
+}
+
+// nextArrival is an exponentially distributed wait, so every node makes a Poisson stream of Rate requests per second
+func (cl *client) nextArrival() time.Duration {
+	if cl.Rate == 0 {
+		return 0
+	}
+	uniform := float64(cl.Runtime.Int63n(1<<53)+1) / (1 << 53)
+	return time.Duration(-math.Log(uniform) / cl.Rate * float64(time.Second))
+}
+
+func (cl *client) arrive() {
+	now := cl.Runtime.Now()
+	cl.Trial.Requested(now)
+	cl.pending = append(cl.pending, now)
+	cl.next()
+	if cl.Rate > 0 {
+		cl.Runtime.After(cl.nextArrival(), cl.arrive)
+	}
+}
+
+func (cl *client) next() {
+	if cl.requesting || len(cl.pending) == 0 {
+		return
+	}
+	cl.requesting = true
+	cl.Request()
+}
+
+// Served is called by the node in its critical section. The next request waits until the node has released the lock
+func (cl *client) Served() {
+	cl.Trial.Served(cl.pending[0], cl.Runtime.Now())
+	cl.pending = cl.pending[1:]
+	cl.Runtime.After(0, func() {
+		cl.requesting = false
+		if cl.Rate == 0 {
+			cl.Runtime.After(THINK_TIME, cl.arrive)
+			return
+		}
+		cl.next()
+	})
+}
+
+func newRicartAgrawala(c *cluster) {
+	network, serve := newNetwork[ricartagrawala.Message](c)
+	num := 0
+	for i := 0; i < c.Size; i++ {
+		node := &ricartagrawala.Node{
+			Id:      i,
+			State:   ricartagrawala.Idle,
+			Network: network,
+			Runtime: c.runtimes[i],
+			Num:     &num,
+			Done:    c.clients[i].Served,
+			Quiet:   true,
+		}
+		serve(i, node.HandleRequest)
+		c.clients[i].Request = node.RandomLockRequest
+	}
+}
+
+func newVoting(c *cluster) {
+	startVoting(c, nil)
+}
+
+// newMaekawa runs voting on grid quorums
+func newMaekawa(c *cluster) {
+	startVoting(c, voting.GridQuorums(c.Size))
+}
+
+func startVoting(c *cluster, quorums [][]int) {
+	network, serve := newNetwork[voting.Message](c)
+	num := 0
+	for i := 0; i < c.Size; i++ {
+		node := &voting.Node{
+			Id:      i,
+			State:   voting.Idle,
+			HasVote: true,
+			Network: network,
+			Runtime: c.runtimes[i],
+			Num:     &num,
+			Done:    c.clients[i].Served,
+			Quiet:   true,
+		}
+		if quorums != nil {
+			node.Quorum = quorums[i]
+		}
+		serve(i, node.HandleRequest)
+		c.clients[i].Request = node.RandomLockRequest
+	}
+}
+
+func newLockServer(c *cluster) {
+	network, serve := newNetwork[lockserver.Message](c)
+	num := 0
+	for i := 0; i < c.Size; i++ {
+		node := &lockserver.Node{
+			Id:            i,
+			Network:       network,
+			Runtime:       c.runtimes[i],
+			HasLock:       i == 0,
+			Server:        0,
+			Num:           &num,
+			ShouldRequest: true,
+			Done:          c.clients[i].Served,
+		}
+		serve(i, node.HandleMessage)
+		c.clients[i].Request = node.Request
+	}
+}
+
+func newTokenRing(c *cluster) {
+	network, serve := newNetwork[tokenring.Message](c)
+	num := 0
+	for i := 0; i < c.Size; i++ {
+		node := &tokenring.Node{
+			Id:       i,
+			State:    tokenring.Idle,
+			HasToken: i == 0,
+			Network:  network,
+			Runtime:  c.runtimes[i],
+			Num:      &num,
+			Done:     c.clients[i].Served,
+			Quiet:    true,
+		}
+		if c.Simulator == nil {
+			node.Pause = tokenring.PAUSE
+		}
+		node.Init()
+		serve(i, node.HandleRequest)
+		c.clients[i].Request = node.RandomLockRequest
+	}
+}
+
+func newSuzukiKasami(c *cluster) {
+	network, serve := newNetwork[suzukikasami.Message](c)
+	num := 0
+	for i := 0; i < c.Size; i++ {
+		node := &suzukikasami.Node{
+			Id:      i,
+			State:   suzukikasami.Idle,
+			Network: network,
+			Runtime: c.runtimes[i],
+			Num:     &num,
+			RN:      make([]int, c.Size),
+			Done:    c.clients[i].Served,
+			Quiet:   true,
+		}
+		if i == 0 {
+			node.Token = suzukikasami.NewToken(c.Size)
+		}
+		serve(i, node.HandleRequest)
+		c.clients[i].Request = node.RandomLockRequest
+	}
+}
+
+func newRaymond(c *cluster) {
+	r := rand.New(rand.NewSource(time.Now().UnixNano()))
+	if c.Simulator != nil {
+		r = c.Simulator.Rand
+	}
+	parents, err := raymond.Tree(c.Topology, c.Size, r)
+	if err != nil {
+		fmt.Println(err)
+		os.Exit(2)
+	}
+	network, serve := newNetwork[raymond.Message](c)
+	num := 0
+	for i := 0; i < c.Size; i++ {
+		node := &raymond.Node{
+			Id:      i,
+			State:   raymond.Idle,
+			Holder:  parents[i],
+			Network: network,
+			Runtime: c.runtimes[i],
+			Num:     &num,
+			Done:    c.clients[i].Served,
+			Quiet:   true,
+		}
+		serve(i, node.HandleRequest)
+		c.clients[i].Request = node.RandomLockRequest
+	}
+}
+
+func newLamport(c *cluster) {
+	network, serve := newNetwork[lamport.Message](c)
+	num := 0
+	for i := 0; i < c.Size; i++ {
+		node := &lamport.Node{
+			Id:       i,
+			State:    lamport.Idle,
+			LastSeen: make([]int, c.Size),
+			Network:  network,
+			Runtime:  c.runtimes[i],
+			Num:      &num,
+			Done:     c.clients[i].Served,
+			Quiet:    true,
+		}
+		serve(i, node.HandleRequest)
+		c.clients[i].Request = node.RandomLockRequest
+	}
+}
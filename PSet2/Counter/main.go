@@ -0,0 +1,91 @@
+// Command Counter is application code on a mutex.DistributedMutex: every node adds to one shared counter
+// -increments times, reading it, holding the lock for -hold and then writing it back. Without mutual exclusion
+// increments get lost, so the final count shows whether the algorithm picked with -algorithm kept nodes apart.
+package main
+
+import (
+	"context"
+	"distsys/PSet2/mutex"
+	"distsys/PSet2/mutex/lockserver"
+	"distsys/PSet2/mutex/raymond"
+	"distsys/common/trace"
+	"flag"
+	"fmt"
+	"os"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+func main() {
+	algorithm := flag.String("algorithm", mutex.RICART_AGRAWALA, strings.Join(mutex.ALGORITHMS, " | "))
+	nodes := flag.Int("nodes", 5, "number of nodes")
+	increments := flag.Int("increments", 20, "increments made by every node")
+	hold := flag.Duration("hold", time.Millisecond, "time every node holds the lock for")
+	timeout := flag.Duration("timeout", 10*time.Second, "time a node waits for the lock before giving up on an increment")
+	tracePath := flag.String("trace", "", "write a trace of every node's events to this file, see common/trace")
+	verbose := flag.Bool("verbose", false, "let the nodes print what they do")
+	lease := flag.Duration("lease", 0, "lockserver only: time the lock stays with a node that stops renewing it, 0 forever")
+	topology := flag.String("topology", raymond.BINARY, "raymond only: spanning tree of the nodes, "+strings.Join(raymond.TOPOLOGIES, " | "))
+	flag.Parse()
+
+	recorder, err := trace.Open(*tracePath)
+	if err != nil {
+		fmt.Printf("could not open trace: %v\n", err)
+		os.Exit(1)
+	}
+	defer recorder.Close()
+	cluster, err := mutex.NewCluster(mutex.Config{Algorithm: *algorithm, Nodes: *nodes, Trace: recorder, Verbose: *verbose, Lease: *lease,
+		Topology: *topology})
+	if err != nil {
+		fmt.Println(err)
+		os.Exit(2)
+	}
+	defer cluster.Close()
+
+	counter := 0
+	inside := int32(0)           // nodes in the critical section right now
+	overlaps := int32(0)         // times a node found another one already inside
+	timeouts := int32(0)         // increments given up on
+	fence := &lockserver.Fence{} // with a lease: turns away the write of a node whose lease ran out while it held the lock
+	var wg sync.WaitGroup
+	start := time.Now()
+	for i := 0; i < cluster.Size(); i++ {
+		wg.Add(1)
+		go func(lock mutex.DistributedMutex) {
+			defer wg.Done()
+			for j := 0; j < *increments; j++ {
+				ctx, cancel := context.WithTimeout(context.Background(), *timeout)
+				err := lock.Lock(ctx)
+				cancel()
+				if err != nil {
+					atomic.AddInt32(&timeouts, 1)
+					continue
+				}
+				if atomic.AddInt32(&inside, 1) > 1 {
+					atomic.AddInt32(&overlaps, 1)
+				}
+				value := counter
+				time.Sleep(*hold)
+				if leased, ok := lock.(mutex.LeasedMutex); !ok || fence.Admit(leased.Token()) == nil {
+					counter = value + 1
+				}
+				atomic.AddInt32(&inside, -1)
+				lock.Unlock()
+			}
+		}(cluster.Mutex(i))
+	}
+	wg.Wait()
+
+	// an increment given up on is a failure too: a deadlocked algorithm times out every one of them
+	expected := *nodes * *increments
+	fmt.Printf("%v: counter %v of %v expected in %v, %v overlapping critical sections, %v timeouts\n",
+		*algorithm, counter, expected, time.Since(start).Round(time.Millisecond), overlaps, timeouts)
+	if *lease > 0 {
+		fmt.Printf("%v writes fenced off after a lease ran out\n", fence.Rejected)
+	}
+	if counter != expected || overlaps > 0 || timeouts > 0 {
+		os.Exit(1)
+	}
+}
@@ -1,199 +1,132 @@
 package main
 
 import (
+	"distsys/PSet2/mutex/ricartagrawala"
+	"distsys/common/faults"
+	"distsys/common/sim"
+	"distsys/common/trace"
+	"flag"
 	"fmt"
-	"sort"
 	"time"
 )
 
-type Node struct {
-	Id               int
-	Queue            []int
-	State            StateType
-	ReceivingChannel chan Message
-	AllChannels      []chan Message
-	Num              *int
-	PriorityQueue    []TimeStamp
-	WaitingArray     []int
-	Request          TimeStamp
-}
-
-type Message struct {
-	Sender    int
-	Type      MessageType
-	TimeStamp TimeStamp
-}
-
-type TimeStamp struct {
-	Id   int
-	Time int64
-}
-
-type MessageType int
-type StateType int
-
-const (
-	Acquire MessageType = iota
-	Reply
-)
-
-const (
-	HasLock StateType = iota
-	WaitingForReplies
-	Idle
-)
-
 const (
 	NUM_OF_NODES = 11
 )
 
 func main() {
-	allChannels := make([]chan Message, NUM_OF_NODES)
+	seed := flag.Int64("seed", 0, "run in a deterministic simulation with this seed instead of for real, the same seed gives the same run")
+	simTime := flag.Duration("simtime", 10*time.Second, "virtual time a simulation runs for")
+	faultsPath := flag.String("faults", "", "JSON file of faults to inject into the network, see common/faults")
+	tracePath := flag.String("trace", "", "write a trace of every node's events to this file, see common/trace")
+	heartbeat := flag.Duration("heartbeat", 0, "how often every node says it is alive, so a crashed node is taken out of the view. 0 waits for every node")
+	crash := flag.Int("crash", -1, "simulation only: node to crash at -crashat")
+	crashAt := flag.Duration("crashat", time.Second, "virtual time node -crash crashes at")
+	downFor := flag.Duration("downfor", 0, "time node -crash stays down for, 0 for good")
+	flag.Parse()
+
+	if *seed != 0 {
+		simulate(*seed, *simTime, *faultsPath, *tracePath, *heartbeat, crashSchedule{Node: *crash, At: *crashAt, For: *downFor})
+		return
+	}
 
-	for i := 0; i < NUM_OF_NODES; i++ {
-		allChannels[i] = make(chan Message, NUM_OF_NODES*10)
+	network := sim.NewChannelNetwork[ricartagrawala.Message](NUM_OF_NODES, NUM_OF_NODES*10)
+	injector, err := faults.Open(*faultsPath, sim.WallClock{}, nil)
+	if err != nil {
+		fmt.Printf("could not load faults: %v\n", err)
+		return
 	}
+	recorder, err := trace.Open(*tracePath)
+	if err != nil {
+		fmt.Printf("could not open trace: %v\n", err)
+		return
+	}
+	defer recorder.Close()
 
 	valueToAdd := 0
 
 	for i := 0; i < NUM_OF_NODES; i++ {
-		node := Node{
+		tracer := recorder.Tracer(i, fmt.Sprintf("node %v", i), sim.WallClock{})
+		node := ricartagrawala.Node{
 			Id:               i,
 			Queue:            make([]int, 0),
-			State:            Idle,
-			ReceivingChannel: allChannels[i],
-			AllChannels:      allChannels,
+			State:            ricartagrawala.Idle,
+			ReceivingChannel: network.Inbox(i),
+			Network:          trace.Wrap[ricartagrawala.Message](faults.Wrap[ricartagrawala.Message](network, i, injector), tracer),
+			Runtime:          sim.NewRealRuntime(),
+			Tracer:           tracer,
 			Num:              &valueToAdd,
-			PriorityQueue:    make([]TimeStamp, 0),
+			PriorityQueue:    make([]ricartagrawala.TimeStamp, 0),
 			WaitingArray:     make([]int, 0),
+			Heartbeat:        *heartbeat,
 		}
+		node.Init()
 
-		go node.start()
+		go node.Start()
 	}
 
 	var input string
 	fmt.Scanln(&input)
 }
 
-func (n *Node) start() {
-	for {
-		select {
-		case m := <-n.ReceivingChannel:
-			n.HandleRequest(m)
-		default:
-			if n.State == Idle {
-				n.RandomLockRequest()
-			}
-		}
-	}
-}
-
-func (n *Node) ExecuteCriticalSection(num *int) {
-	fmt.Printf(`-----------------------------------------------------------------------------------
-----------%v : Has lock, executing critical section <Number to Add: %v>------------
------------------------------------------------------------------------------------
-`, n.Id, *num)
-	*num += 1
-	fmt.Printf(`-----------------------------------------------------------------------------------
---------%v : Executed critical section <Number to Add: %v> Releasing lock----------
------------------------------------------------------------------------------------
-`, n.Id, *num)
+// crashSchedule takes Node down At a time for For, or for good if For is 0. No node crashes if Node is -1
+type crashSchedule struct {
+	Node int
+	At   time.Duration
+	For  time.Duration
 }
 
-func (n *Node) RandomLockRequest() {
-	// time.Sleep(time.Second * time.Duration(rand.Intn(3)))
-	n.State = WaitingForReplies
-	fmt.Printf("%v : requesting lock, waiting for replies\n", n.Id)
-	requestTimeStamp := TimeStamp{n.Id, time.Now().UnixNano()}
-	n.Request = requestTimeStamp
-	m := Message{
-		Sender:    n.Id,
-		Type:      Acquire,
-		TimeStamp: requestTimeStamp,
+func simulate(seed int64, simTime time.Duration, faultsPath string, tracePath string, heartbeat time.Duration, crash crashSchedule) {
+	/**
+	Same nodes, but every message is delivered by the simulator, one at a time in an order picked by the seed.
+	A node asks for the lock again as soon as it is idle, like the default case of Node.Start
+	*/
+	s := sim.New(seed)
+	network := sim.NewSimNetwork[ricartagrawala.Message](s, NUM_OF_NODES)
+	injector, err := faults.Open(faultsPath, s, s.Rand)
+	if err != nil {
+		fmt.Printf("could not load faults: %v\n", err)
+		return
 	}
-
-	for i := 0; i < cap(n.AllChannels); i++ {
-		n.AllChannels[i] <- m
+	recorder, err := trace.Open(tracePath)
+	if err != nil {
+		fmt.Printf("could not open trace: %v\n", err)
+		return
 	}
-}
-
-func (n *Node) HandleRequest(m Message) {
-	switch m.Type {
-	case Acquire:
-		//if waiting for reply, compare to own request time
-		if n.State == HasLock || n.State == WaitingForReplies && n.Request.IsSmaller(m.TimeStamp) {
-			n.PriorityQueue = append(n.PriorityQueue, m.TimeStamp)
-			n.PriorityQueue = SortQueue(n.PriorityQueue)
-			break
-		}
-
-		//else reply
-		n.AllChannels[m.Sender] <- Message{Sender: n.Id, Type: Reply}
+	defer recorder.Close()
+	valueToAdd := 0
 
-	case Reply:
-		//If I receive a reply I will check whether I have a reply from every one
-		if ArrayContains(n.WaitingArray, m.Sender) {
-			fmt.Printf("%v : duplicate of %v found\n", n.Id, m.Sender)
+	for i := 0; i < NUM_OF_NODES; i++ {
+		tracer := recorder.Tracer(i, fmt.Sprintf("node %v", i), s)
+		node := &ricartagrawala.Node{
+			Id:            i,
+			Queue:         make([]int, 0),
+			State:         ricartagrawala.Idle,
+			Network:       trace.Wrap[ricartagrawala.Message](faults.Wrap[ricartagrawala.Message](network, i, injector), tracer),
+			Runtime:       s,
+			Tracer:        tracer,
+			Num:           &valueToAdd,
+			PriorityQueue: make([]ricartagrawala.TimeStamp, 0),
+			WaitingArray:  make([]int, 0),
+			Heartbeat:     heartbeat,
 		}
-		n.WaitingArray = append(n.WaitingArray, m.Sender)
-		repliesRequired := cap(n.AllChannels)
-		fmt.Printf("%v : Reply received from %v. %v replies remaining. %v \n", n.Id, m.Sender, repliesRequired-len(n.WaitingArray), n.WaitingArray)
-		if len(n.WaitingArray) == repliesRequired {
-			n.State = HasLock
-			n.ExecuteCriticalSection(n.Num)
-			//reply to everyone else
-			for i := 0; i < len(n.PriorityQueue); i++ {
-				n.AllChannels[n.PriorityQueue[i].Id] <- Message{Sender: n.Id, Type: Reply}
+		node.Init()
+		if i == crash.Node {
+			s.After(crash.At, node.Crash)
+			if crash.For > 0 {
+				s.After(crash.At+crash.For, node.Recover)
 			}
-			n.PriorityQueue = nil
-			n.WaitingArray = nil
-			n.State = Idle
-		}
-
-	}
-}
-
-func (t *TimeStamp) IsEarliest(t_array []TimeStamp) bool {
-	for i := 0; i < len(t_array); i++ {
-		if t_array[i].Time < t.Time {
-			return false
-		}
-		if t_array[i].Time == t.Time && t_array[i].Id < t.Id {
-			return false
 		}
+		network.Handle(i, func(m ricartagrawala.Message) {
+			node.HandleRequest(m)
+			node.RequestIfIdle()
+		})
+		s.After(0, node.RequestIfIdle)
 	}
-	return true
-}
-
-func SortQueue(q []TimeStamp) []TimeStamp {
-	sort.Slice(q, func(i, j int) bool {
-		return q[j].Time > q[i].Time
-	})
-	return q
-}
-
-func (t *TimeStamp) IsSmaller(t2 TimeStamp) bool {
-	if t.Time == t2.Time {
-		fmt.Printf("Here, %v\n", t.Id < t2.Id)
-		return t.Id < t2.Id
-	}
-	return t.Time < t2.Time
-}
 
-func QueueContains(arr []TimeStamp, t TimeStamp) bool {
-	for i := 0; i < len(arr); i++ {
-		if arr[i] == t {
-			return true
-		}
-	}
-	return false
-}
-
-func ArrayContains(arr []int, t int) bool {
-	for i := 0; i < len(arr); i++ {
-		if arr[i] == t {
-			return true
-		}
+	s.Run(simTime)
+	fmt.Printf("seed %v: %v critical sections in %v of virtual time, %v events\n", seed, valueToAdd, s.Elapsed(), s.Steps())
+	if injector != nil {
+		fmt.Println(injector)
 	}
-	return false
 }
@@ -1,260 +1,129 @@
 package main
 
 import (
+	"distsys/PSet2/mutex/voting"
+	"distsys/common/faults"
+	"distsys/common/sim"
+	"distsys/common/trace"
+	"flag"
 	"fmt"
-	"math"
-	"math/rand"
-	"sort"
+	"strings"
 	"time"
 )
 
-//=============================== STRUCTS AND HELPERS =========================================//
-//=============================================================================================//
-
-type Node struct {
-	Id               int
-	Queue            []int
-	State            StateType
-	HasVote          bool
-	VotedTo          TimeStamp
-	ReceivingChannel chan Message
-	AllChannels      []chan Message
-	Num              *int
-	PriorityQueue    []TimeStamp
-	WaitingArray     []int
-}
-
-type Message struct {
-	Sender    int
-	Type      MessageType
-	TimeStamp TimeStamp
-}
-
-type TimeStamp struct {
-	Id   int
-	Time int
-}
-
-type MessageType int
-type StateType int
-
-const (
-	Acquire MessageType = iota
-	Vote
-	ReleaseVote
-	RescindVote
-)
-
-const (
-	HasLock StateType = iota
-	WaitingForReplies
-	Idle
-)
-
 const (
 	NUM_OF_NODES = 11
 )
 
-//Checks whether current timestamp is earlier than all other timestamps in the array
-func (t *TimeStamp) IsEarliest(tArray []TimeStamp) bool {
-	for i := 0; i < len(tArray); i++ {
-		if t.Time > tArray[i].Time {
-			return false
-		}
-
-		//tie breaker using Id
-		if t.Time == tArray[i].Time && t.Id > tArray[i].Id {
-			return false
-		}
+func main() {
+	seed := flag.Int64("seed", 0, "run in a deterministic simulation with this seed instead of for real, the same seed gives the same run")
+	simTime := flag.Duration("simtime", 60*time.Second, "virtual time a simulation runs for")
+	faultsPath := flag.String("faults", "", "JSON file of faults to inject into the network, see common/faults")
+	tracePath := flag.String("trace", "", "write a trace of every node's events to this file, see common/trace")
+	quorum := flag.String("quorum", voting.MAJORITY, "nodes every node asks for votes: "+strings.Join(voting.QUORUM_KINDS, " | ")+
+		". grid and plane run Maekawa's algorithm, plane needs 7, 13, 31, ... -nodes")
+	numOfNodes := flag.Int("nodes", NUM_OF_NODES, "number of nodes")
+	flag.Parse()
+
+	quorums, err := voting.Quorums(*quorum, *numOfNodes)
+	if err != nil {
+		fmt.Println(err)
+		return
 	}
-	return true
-}
-
-//Removes machine <id>'s timestamp from a timestamp array
-func RemoveTimeStamp(tArray []TimeStamp, id int) []TimeStamp {
-	for i := 0; i < len(tArray); i++ {
-		if tArray[i].Id == id {
-			return append(tArray[:i], tArray[i+1:]...)
-		}
+	if *seed != 0 {
+		simulate(*seed, *simTime, *faultsPath, *tracePath, quorums, *numOfNodes)
+		return
 	}
-	return tArray
-}
-
-func (n *Node) ExecuteCriticalSection(num *int) {
-	fmt.Printf(`-----------------------------------------------------------------------------------
-----------%v : Has lock, executing critical section <Number to Add: %v>------------
------------------------------------------------------------------------------------
-`, n.Id, *num)
-	*num += 1
-	fmt.Printf(`-----------------------------------------------------------------------------------
---------%v : Executed critical section <Number to Add: %v> Releasing lock----------
------------------------------------------------------------------------------------
-`, n.Id, *num)
-}
-
-func SortQueue(q []TimeStamp) []TimeStamp {
-	sort.Slice(q, func(i, j int) bool {
-		return q[i].Time < q[j].Time
-	})
-	return q
-}
-
-//========================= END OF STRUCTS AND HELPERS ========================================//
-//=============================================================================================//
-
-func main() {
-	allChannels := make([]chan Message, NUM_OF_NODES)
 
-	for i := 0; i < NUM_OF_NODES; i++ {
-		allChannels[i] = make(chan Message, NUM_OF_NODES*10)
+	network := sim.NewChannelNetwork[voting.Message](*numOfNodes, *numOfNodes*10)
+	injector, err := faults.Open(*faultsPath, sim.WallClock{}, nil)
+	if err != nil {
+		fmt.Printf("could not load faults: %v\n", err)
+		return
+	}
+	recorder, err := trace.Open(*tracePath)
+	if err != nil {
+		fmt.Printf("could not open trace: %v\n", err)
+		return
 	}
+	defer recorder.Close()
 
 	valueToAdd := 0
 
-	for i := 0; i < NUM_OF_NODES; i++ {
-		node := Node{
+	for i := 0; i < *numOfNodes; i++ {
+		tracer := recorder.Tracer(i, fmt.Sprintf("node %v", i), sim.WallClock{})
+		node := voting.Node{
 			Id:               i,
 			Queue:            make([]int, 0),
-			State:            Idle,
-			ReceivingChannel: allChannels[i],
+			State:            voting.Idle,
+			ReceivingChannel: network.Inbox(i),
 			HasVote:          true,
-			AllChannels:      allChannels,
+			Network:          trace.Wrap[voting.Message](faults.Wrap[voting.Message](network, i, injector), tracer),
+			Runtime:          sim.NewRealRuntime(),
+			Tracer:           tracer,
 			Num:              &valueToAdd,
-			PriorityQueue:    make([]TimeStamp, 0),
+			PriorityQueue:    make([]voting.TimeStamp, 0),
 			WaitingArray:     make([]int, 0),
 		}
+		if quorums != nil {
+			node.Quorum = quorums[i]
+		}
 
-		go node.start()
+		go node.Start()
 	}
 	var input string
 	fmt.Print("Press Enter to Stop")
 	fmt.Scanln(&input)
 }
 
-func (n *Node) start() {
-	ticker := time.NewTicker(5 * time.Second)
-	for {
-		select {
-		case m := <-n.ReceivingChannel:
-			n.HandleRequest(m)
-
-		case <-ticker.C:
-			temp := []int{}
-			for i := 0; i < len(n.PriorityQueue); i++ {
-				temp = append(temp, n.PriorityQueue[i].Id)
-			}
-			fmt.Printf("%v : vote with : %v\n time stamp: %v\n state: %v\n wait queue: %v\n", n.Id, n.VotedTo.Id, n.VotedTo.Time, n.State, n.WaitingArray)
-		default:
-			if n.State == Idle {
-				n.RandomLockRequest()
-			}
-		}
+func simulate(seed int64, simTime time.Duration, faultsPath string, tracePath string, quorums [][]int, numOfNodes int) {
+	/**
+	Same nodes, but every message and timer is run by the simulator, one at a time in an order picked by the seed.
+	A node rests as soon as it is idle, like the default case of Node.Start
+	*/
+	s := sim.New(seed)
+	network := sim.NewSimNetwork[voting.Message](s, numOfNodes)
+	injector, err := faults.Open(faultsPath, s, s.Rand)
+	if err != nil {
+		fmt.Printf("could not load faults: %v\n", err)
+		return
 	}
-}
-
-func (n *Node) HandleRequest(m Message) {
-	switch m.Type {
-	case Acquire:
-		n.PriorityQueue = append(n.PriorityQueue, m.TimeStamp)
-		//check whether request is earliest in the priority queue:
-		// AND whether current node has a vote
-		if m.TimeStamp.IsEarliest(n.PriorityQueue) {
-			if n.HasVote {
-				//vote for machine
-				fmt.Printf("%v : request received, voting to %v\n", n.Id, m.Sender)
-				n.AllChannels[m.Sender] <- Message{Sender: n.Id, Type: Vote}
-
-				n.PriorityQueue = RemoveTimeStamp(n.PriorityQueue, m.Sender)
-				n.PriorityQueue = SortQueue(n.PriorityQueue)
-
-				n.HasVote = false
-				n.VotedTo = m.TimeStamp
-				break
-			}
-			// if no vote and timestamp is earliest, rescind vote
-			fmt.Printf("%v : acquire time stamp: %v, but my vote's timestamp is %v\n", n.Id, m.TimeStamp, n.VotedTo)
-
-			fmt.Printf("%v : request received, rescinding vote from%v\n", n.Id, n.VotedTo.Id)
-			n.AllChannels[m.Sender] <- Message{Sender: n.VotedTo.Id, Type: RescindVote}
-
-			//request should be added back into the priority queue
-			n.PriorityQueue = append(n.PriorityQueue, n.VotedTo)
-			n.PriorityQueue = SortQueue(n.PriorityQueue)
-		}
-	case Vote:
-		fmt.Printf("%v : vote received from %v \n", n.Id, m.Sender)
-
-		if n.State != WaitingForReplies {
-			//release vote
-			n.AllChannels[m.Sender] <- Message{Sender: n.Id, Type: ReleaseVote}
-			break
-		}
-
-		//compute value of majority
-		majorityValue := int(math.Floor(float64(cap(n.AllChannels))/2) + 1)
-		n.WaitingArray = append(n.WaitingArray, m.Sender)
-		sort.Ints(n.WaitingArray)
-
-		//check whether has majority
-		if len(n.WaitingArray) == majorityValue {
-			n.State = HasLock
-			n.ExecuteCriticalSection(n.Num)
-			n.ReleaseAndReply()
-			n.State = Idle
-		} else {
-			fmt.Printf("%v : waiting for %v more replies\n", n.Id, majorityValue-len(n.WaitingArray))
-			fmt.Printf("%v : waiting array %v \n", n.Id, n.WaitingArray)
-		}
-	case ReleaseVote:
-		n.HasVote = true
-		//vote if there is a request waiting in the queue
-		if len(n.PriorityQueue) > 0 {
-			stamp := n.PriorityQueue[0]
-
-			// cast vote
-			fmt.Printf("%v : released, voting to %v \n", n.Id, stamp.Id)
-			n.AllChannels[stamp.Id] <- Message{Sender: n.Id, Type: Vote}
+	recorder, err := trace.Open(tracePath)
+	if err != nil {
+		fmt.Printf("could not open trace: %v\n", err)
+		return
+	}
+	defer recorder.Close()
+	valueToAdd := 0
 
-			n.VotedTo = stamp
-			n.HasVote = false
-			n.PriorityQueue = RemoveTimeStamp(n.PriorityQueue, stamp.Id)
-			n.PriorityQueue = SortQueue(n.PriorityQueue)
+	for i := 0; i < numOfNodes; i++ {
+		tracer := recorder.Tracer(i, fmt.Sprintf("node %v", i), s)
+		node := &voting.Node{
+			Id:            i,
+			Queue:         make([]int, 0),
+			State:         voting.Idle,
+			HasVote:       true,
+			Network:       trace.Wrap[voting.Message](faults.Wrap[voting.Message](network, i, injector), tracer),
+			Runtime:       s,
+			Tracer:        tracer,
+			Num:           &valueToAdd,
+			PriorityQueue: make([]voting.TimeStamp, 0),
+			WaitingArray:  make([]int, 0),
 		}
-	case RescindVote:
-		//remove vote and release
-		for i := 0; i < len(n.WaitingArray); i++ {
-			if i == m.Sender {
-				n.WaitingArray = append(n.WaitingArray[:i], n.WaitingArray[i+1:]...)
-				break
-			}
+		if quorums != nil {
+			node.Quorum = quorums[i]
 		}
-
-		//release vote
-		n.AllChannels[m.Sender] <- Message{Sender: n.Id, Type: ReleaseVote}
+		network.Handle(i, func(m voting.Message) {
+			node.HandleRequest(m)
+			node.RestIfIdle()
+		})
+		s.After(0, node.RestIfIdle)
+		s.After(voting.STATUS_INTERVAL, node.PrintStatus)
 	}
-}
-
-func (n *Node) ReleaseAndReply() {
-	//pop self's timestamp off the priority queue
-	n.PriorityQueue = RemoveTimeStamp(n.PriorityQueue, n.Id)
-
-	//release vote to those who voted for me
-	for i := 0; i < len(n.WaitingArray); i++ {
-		n.AllChannels[n.WaitingArray[i]] <- Message{Sender: n.Id, Type: ReleaseVote}
-	}
-
-	// clear waiting array and set state to idle
-	n.WaitingArray = nil
-}
-func (n *Node) RandomLockRequest() {
-	time.Sleep(time.Second * time.Duration(rand.Intn(3)))
-	n.State = WaitingForReplies
-	fmt.Printf("%v : requesting lock, waiting for replies\n", n.Id)
-	requestTimeStamp := TimeStamp{n.Id, time.Now().Nanosecond()}
-
-	m := Message{Sender: n.Id, Type: Acquire, TimeStamp: requestTimeStamp}
 
-	for i := 0; i < cap(n.AllChannels); i++ {
-		n.AllChannels[i] <- m
+	s.Run(simTime)
+	fmt.Printf("seed %v: %v critical sections in %v of virtual time, %v events\n", seed, valueToAdd, s.Elapsed(), s.Steps())
+	if injector != nil {
+		fmt.Println(injector)
 	}
 }
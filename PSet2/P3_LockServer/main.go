@@ -1,67 +1,95 @@
 package main
 
 import (
+	"distsys/PSet2/mutex/lockserver"
+	"distsys/common/faults"
+	"distsys/common/sim"
+	"distsys/common/trace"
+	"flag"
 	"fmt"
 	"sync"
 	"time"
 )
 
-type Node struct {
-	Id            int
-	AllChannels   []chan Message
-	HasLock       bool
-	Server        int
-	PriorityQueue []Message
-	Num           *int
-	Requesting    bool
-	Start         chan struct{}
-	WaitGroup     *sync.WaitGroup
-	ShouldRequest bool
-}
+const (
+	NUM_OF_NODES = 11
+)
 
-type Message struct {
-	Sender int
-	Type   MessageType
+func nodeName(id int) string {
+	if id == 0 {
+		return "node 0 (server)"
+	}
+	return fmt.Sprintf("node %v", id)
 }
 
-type MessageType int
-
-const (
-	Acquire MessageType = iota
-	Release
-	LockGranted
-	Kill
-)
+// leaseConfig is how long grants last and which node stalls in the lock for how long, to show a lock taken back
+// from a holder that stopped responding and the fencing token turning away its write once it comes back
+type leaseConfig struct {
+	Lease   time.Duration
+	Stall   time.Duration
+	Stalled int
+}
 
-const (
-	NUM_OF_NODES = 11
-)
+func (c leaseConfig) stall(id int) time.Duration {
+	if id == c.Stalled {
+		return c.Stall
+	}
+	return 0
+}
 
 func main() {
+	seed := flag.Int64("seed", 0, "run in a deterministic simulation with this seed instead of for real, the same seed gives the same run")
+	faultsPath := flag.String("faults", "", "JSON file of faults to inject into the network, see common/faults")
+	tracePath := flag.String("trace", "", "write a trace of every node's events in every round to this file, see common/trace")
+	lease := flag.Duration("lease", 0, "time a grant lasts unless the holder renews it, 0 never expires")
+	stall := flag.Duration("stall", 0, "time node -stalled freezes for once it is granted the lock, before its critical section")
+	stalled := flag.Int("stalled", 1, "node that stalls with -stall")
+	flag.Parse()
+
+	config := leaseConfig{Lease: *lease, Stall: *stall, Stalled: *stalled}
+	if *seed != 0 {
+		simulate(*seed, *faultsPath, *tracePath, config)
+		return
+	}
 
+	recorder, err := trace.Open(*tracePath)
+	if err != nil {
+		fmt.Printf("could not open trace: %v\n", err)
+		return
+	}
+	defer recorder.Close()
 	num := 0
 	for j := 1; j < NUM_OF_NODES+1; j++ {
+		injector, err := faults.Open(*faultsPath, sim.WallClock{}, nil)
+		if err != nil {
+			fmt.Printf("could not load faults: %v\n", err)
+			return
+		}
+		fence := &lockserver.Fence{}
 		var wg sync.WaitGroup
 		var start = make(chan struct{}, 0)
 		wg.Add(j)
-		all_channels := make([]chan Message, NUM_OF_NODES)
+		network := sim.NewChannelNetwork[lockserver.Message](NUM_OF_NODES, NUM_OF_NODES)
 		for i := 0; i < NUM_OF_NODES; i++ {
-			all_channels[i] = make(chan Message, NUM_OF_NODES)
-		}
-		for i := 0; i < NUM_OF_NODES; i++ {
-
-			node := Node{
+			tracer := recorder.Tracer(i, nodeName(i), sim.WallClock{})
+			node := lockserver.Node{
 				Id:            i,
-				AllChannels:   all_channels,
+				Inbox:         network.Inbox(i),
+				Network:       trace.Wrap[lockserver.Message](faults.Wrap[lockserver.Message](network, i, injector), tracer),
+				Runtime:       sim.NewRealRuntime(),
+				Tracer:        tracer,
 				HasLock:       i == 0,
 				Server:        0,
 				Num:           &num,
 				Requesting:    false,
+				Lease:         config.Lease,
+				Fence:         fence,
+				Stall:         config.stall(i),
 				ShouldRequest: i < j,
-				Start:         start,
-				WaitGroup:     &wg,
+				StartRequests: start,
+				Done:          wg.Done,
 			}
-			go node.start()
+			go node.Start()
 		}
 		close(start)
 		t1 := time.Now().UnixMicro()
@@ -69,73 +97,67 @@ func main() {
 		fmt.Printf("%v out of %v concurrent requests done\n", j, NUM_OF_NODES)
 		t2 := time.Now().UnixMicro()
 		for i := 0; i < NUM_OF_NODES; i++ {
-			all_channels[i] <- Message{Type: Kill}
+			network.Send(i, lockserver.Message{Type: lockserver.Kill})
 		}
 		fmt.Printf("Number of Nodes: %v,    Time taken: %v\n", j, t2-t1)
+		if fence.Rejected > 0 {
+			fmt.Printf("%v stale writes rejected by the fencing token\n", fence.Rejected)
+		}
 		// time.Sleep(2 * time.Second)
 	}
 	var input string
 	fmt.Scanln(&input)
 }
 
-func (n *Node) start() {
-	for {
-		select {
-		case m := <-n.AllChannels[n.Id]:
-			if m.Type == Kill {
-				return
-			}
-			n.HandleMessage(m)
-		default:
-			if !n.Requesting && n.ShouldRequest {
-				<-n.Start
-				// fmt.Printf("%v : requesting\n", n.Id)
-				n.AllChannels[n.Server] <- Message{Sender: n.Id, Type: Acquire}
-				n.Requesting = true
+func simulate(seed int64, faultsPath string, tracePath string, config leaseConfig) {
+	/**
+	The same rounds of 1 to NUM_OF_NODES concurrent requests, but every message is delivered by the simulator
+	one at a time in an order picked by the seed. Times are virtual, so they only depend on the number of messages.
+	Every round starts again at virtual time 0, so the rounds overlap in the trace
+	*/
+	recorder, err := trace.Open(tracePath)
+	if err != nil {
+		fmt.Printf("could not open trace: %v\n", err)
+		return
+	}
+	defer recorder.Close()
+	num := 0
+	for j := 1; j < NUM_OF_NODES+1; j++ {
+		s := sim.New(seed)
+		network := sim.NewSimNetwork[lockserver.Message](s, NUM_OF_NODES)
+		injector, err := faults.Open(faultsPath, s, s.Rand)
+		if err != nil {
+			fmt.Printf("could not load faults: %v\n", err)
+			return
+		}
+		fence := &lockserver.Fence{}
+		done := 0
+		for i := 0; i < NUM_OF_NODES; i++ {
+			tracer := recorder.Tracer(i, nodeName(i), s)
+			node := &lockserver.Node{
+				Id:            i,
+				Network:       trace.Wrap[lockserver.Message](faults.Wrap[lockserver.Message](network, i, injector), tracer),
+				Runtime:       s,
+				Tracer:        tracer,
+				HasLock:       i == 0,
+				Server:        0,
+				Num:           &num,
+				ShouldRequest: i < j,
+				Lease:         config.Lease,
+				Fence:         fence,
+				Stall:         config.stall(i),
+				Done:          func() { done++ },
 			}
+			network.Handle(i, node.HandleMessage)
+			s.After(0, node.Request)
 		}
-	}
-}
-
-func (n *Node) HandleMessage(m Message) {
-	switch m.Type {
-	case Acquire:
-		if n.HasLock {
-			n.HasLock = false
-			n.AllChannels[m.Sender] <- Message{Sender: n.Id, Type: LockGranted}
-			break
+		s.RunUntil(func() bool { return done == j }, time.Hour)
+		fmt.Printf("Number of Nodes: %v,    Virtual time taken: %v, events: %v\n", j, s.Elapsed().Microseconds(), s.Steps())
+		if fence.Rejected > 0 {
+			fmt.Printf("%v stale writes rejected by the fencing token\n", fence.Rejected)
 		}
-		n.PriorityQueue = append(n.PriorityQueue, m)
-	case LockGranted:
-		n.ExecuteCriticalSection(n.Num)
-		n.WaitGroup.Done()
-
-		// n.Requesting = false
-		n.AllChannels[n.Server] <- Message{Sender: n.Id, Type: Release}
-	case Release:
-		n.HasLock = true
-		if len(n.PriorityQueue) == 0 {
-			break
+		if injector != nil {
+			fmt.Println(injector)
 		}
-		queuedMessage := n.PriorityQueue[0]
-		n.PriorityQueue = n.PriorityQueue[1:]
-		n.HasLock = false
-		n.AllChannels[queuedMessage.Sender] <- Message{Sender: n.Id, Type: LockGranted}
 	}
 }
-
-func (n *Node) Acquire() {
-
-}
-
-func (n *Node) ExecuteCriticalSection(num *int) {
-	// 	fmt.Printf(`-----------------------------------------------------------------------------------
-	// ----------%v : Has lock, executing critical section <Number to Add: %v>-------------
-	// -----------------------------------------------------------------------------------
-	// `, n.Id, *num)
-	*num += 1
-	// 	fmt.Printf(`-----------------------------------------------------------------------------------
-	// --------%v : Executed critical section <Number to Add: %v> Releasing lock-----------
-	// -----------------------------------------------------------------------------------
-	// `, n.Id, *num)
-}
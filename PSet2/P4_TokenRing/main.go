@@ -0,0 +1,26 @@
+package main
+
+import (
+	"distsys/PSet2/mutex/program"
+	"distsys/PSet2/mutex/tokenring"
+)
+
+func main() {
+	flags := program.ParseFlags()
+	program.Run(flags, func(env program.Env[tokenring.Message]) program.Node[tokenring.Message] {
+		node := &tokenring.Node{
+			Id:       env.Id,
+			State:    tokenring.Idle,
+			HasToken: env.Id == 0,
+			Network:  env.Network,
+			Runtime:  env.Runtime,
+			Tracer:   env.Tracer,
+			Num:      env.Num,
+			Pause:    tokenring.PAUSE,
+			Done:     env.Done,
+			HoldLock: true,
+		}
+		node.Init()
+		return node
+	})
+}
@@ -0,0 +1,27 @@
+package main
+
+import (
+	"distsys/PSet2/mutex/program"
+	"distsys/PSet2/mutex/suzukikasami"
+)
+
+func main() {
+	flags := program.ParseFlags()
+	program.Run(flags, func(env program.Env[suzukikasami.Message]) program.Node[suzukikasami.Message] {
+		node := &suzukikasami.Node{
+			Id:       env.Id,
+			State:    suzukikasami.Idle,
+			Network:  env.Network,
+			Runtime:  env.Runtime,
+			Tracer:   env.Tracer,
+			Num:      env.Num,
+			RN:       make([]int, env.Nodes),
+			Done:     env.Done,
+			HoldLock: true,
+		}
+		if env.Id == 0 {
+			node.Token = suzukikasami.NewToken(env.Nodes)
+		}
+		return node
+	})
+}
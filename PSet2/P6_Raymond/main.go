@@ -0,0 +1,35 @@
+package main
+
+import (
+	"distsys/PSet2/mutex/program"
+	"distsys/PSet2/mutex/raymond"
+	"flag"
+	"fmt"
+	"os"
+	"strings"
+)
+
+func main() {
+	topology := flag.String("topology", raymond.BINARY, "spanning tree of the nodes: "+strings.Join(raymond.TOPOLOGIES, " | "))
+	flags := program.ParseFlags()
+
+	parents, err := raymond.Tree(*topology, flags.Nodes, flags.Rand())
+	if err != nil {
+		fmt.Println(err)
+		os.Exit(2)
+	}
+	fmt.Printf("tree: %v\n", parents)
+	program.Run(flags, func(env program.Env[raymond.Message]) program.Node[raymond.Message] {
+		return &raymond.Node{
+			Id:       env.Id,
+			State:    raymond.Idle,
+			Holder:   parents[env.Id],
+			Network:  env.Network,
+			Runtime:  env.Runtime,
+			Tracer:   env.Tracer,
+			Num:      env.Num,
+			Done:     env.Done,
+			HoldLock: true,
+		}
+	})
+}
@@ -0,0 +1,23 @@
+package main
+
+import (
+	"distsys/PSet2/mutex/lamport"
+	"distsys/PSet2/mutex/program"
+)
+
+func main() {
+	flags := program.ParseFlags()
+	program.Run(flags, func(env program.Env[lamport.Message]) program.Node[lamport.Message] {
+		return &lamport.Node{
+			Id:       env.Id,
+			State:    lamport.Idle,
+			LastSeen: make([]int, env.Nodes),
+			Network:  env.Network,
+			Runtime:  env.Runtime,
+			Tracer:   env.Tracer,
+			Num:      env.Num,
+			Done:     env.Done,
+			HoldLock: true,
+		}
+	})
+}
@@ -0,0 +1,166 @@
+// Package lamport is the mutual exclusion of Lamport's "Time, Clocks, and the Ordering of Events in a Distributed
+// System" (1978). Every node keeps a Lamport clock and a copy of one request queue ordered by it: a request goes to
+// every node, which queues it and acknowledges it, and a Release takes it back off every queue. A node enters once
+// its own request is first in its queue and it has heard something stamped later than its request from every other
+// node, so no earlier request can still be on the way. The network has to deliver every node's messages in order.
+package lamport
+
+import (
+	"distsys/PSet2/mutex/section"
+	"distsys/common/sim"
+	"distsys/common/trace"
+	"sort"
+)
+
+type Node struct {
+	Id       int
+	State    StateType
+	Clock    int         // Lamport clock
+	Queue    []TimeStamp // every request this node knows of, earliest first
+	LastSeen []int       // the clock of the latest message from every node
+	Request  TimeStamp   // this node's request while it wants the lock
+	Network  sim.Network[Message]
+	Runtime  sim.Runtime
+	Tracer   *trace.Tracer
+	Num      *int
+	Done     func() // called in every critical section, nil if nobody is counting them
+	Quiet    bool   // prints nothing, for benchmarks
+	HoldLock bool   // keeps the lock after the critical section until ReleaseLock, for mutex.Cluster and mutex/program
+}
+
+type Message struct {
+	Sender    int
+	Type      MessageType
+	TimeStamp TimeStamp // the sender's clock when it sent the message, for a Request also the request's place in the queue
+	Trace     trace.Context
+}
+
+type TimeStamp struct {
+	Id   int
+	Time int
+}
+
+type MessageType int
+type StateType int
+
+const (
+	Request MessageType = iota
+	Ack
+	Release
+)
+
+var MESSAGE_TYPES = []string{"Request", "Ack", "Release"}
+
+const (
+	HasLock StateType = iota
+	WaitingForReplies
+	Idle
+)
+
+func (m Message) TraceInfo() trace.Info {
+	return trace.Info{Type: MESSAGE_TYPES[m.Type], PageId: trace.NO_PAGE}
+}
+
+func (m Message) WithTrace(ctx trace.Context) Message {
+	m.Trace = ctx
+	return m
+}
+
+// IsSmaller orders timestamps by clock, then by node id
+func (t TimeStamp) IsSmaller(t2 TimeStamp) bool {
+	if t.Time == t2.Time {
+		return t.Id < t2.Id
+	}
+	return t.Time < t2.Time
+}
+
+func (n *Node) RequestIfIdle() {
+	if n.State == Idle {
+		n.RandomLockRequest()
+	}
+}
+
+func (n *Node) RandomLockRequest() {
+	n.State = WaitingForReplies
+	n.Tracer.Begin("lock request", trace.NO_PAGE)
+	n.Clock++
+	n.Request = TimeStamp{n.Id, n.Clock}
+	section.Printf(n.Quiet, "%v : requesting lock at %v\n", n.Id, n.Clock)
+	n.enqueue(n.Request)
+	n.broadcast(Request, n.Request)
+	n.enterIfFirst()
+}
+
+func (n *Node) HandleRequest(m Message) {
+	n.Tracer.Receive(m.Trace, m.TraceInfo())
+	/**
+	1. Move the clock past the message's and remember that nothing from its sender can come stamped earlier
+	2. Queue a request and acknowledge it, or take a released request off the queue
+	3. Enter if this node's request is now first and every other node has been heard from since it was made
+	*/
+	if m.TimeStamp.Time > n.Clock {
+		n.Clock = m.TimeStamp.Time
+	}
+	n.Clock++
+	if m.TimeStamp.Time > n.LastSeen[m.Sender] {
+		n.LastSeen[m.Sender] = m.TimeStamp.Time
+	}
+
+	switch m.Type {
+	case Request:
+		n.enqueue(m.TimeStamp)
+		n.Clock++
+		n.Network.Send(m.Sender, Message{Sender: n.Id, Type: Ack, TimeStamp: TimeStamp{n.Id, n.Clock}})
+	case Release:
+		for i, t := range n.Queue {
+			if t.Id == m.Sender {
+				n.Queue = append(n.Queue[:i], n.Queue[i+1:]...)
+				break
+			}
+		}
+	case Ack:
+		//only counts as a later message from its sender, which LastSeen already has
+	}
+	n.enterIfFirst()
+}
+
+func (n *Node) enterIfFirst() {
+	if n.State != WaitingForReplies || len(n.Queue) == 0 || n.Queue[0] != n.Request {
+		return
+	}
+	for i := 0; i < n.Network.Size(); i++ {
+		if i != n.Id && !n.Request.IsSmaller(TimeStamp{i, n.LastSeen[i]}) {
+			section.Printf(n.Quiet, "%v : first in the queue, waiting to hear from %v\n", n.Id, i)
+			return
+		}
+	}
+	n.State = HasLock
+	n.Tracer.Local("critical section", trace.NO_PAGE)
+	section.Execute(n.Id, n.Num, n.Quiet, n.Done)
+	if !n.HoldLock {
+		n.ReleaseLock()
+	}
+}
+
+// ReleaseLock leaves the critical section, taking this node's request off every queue
+func (n *Node) ReleaseLock() {
+	n.Queue = n.Queue[1:]
+	n.State = Idle
+	n.Clock++
+	n.broadcast(Release, TimeStamp{n.Id, n.Clock})
+}
+
+func (n *Node) enqueue(t TimeStamp) {
+	n.Queue = append(n.Queue, t)
+	sort.Slice(n.Queue, func(i, j int) bool {
+		return n.Queue[i].IsSmaller(n.Queue[j])
+	})
+}
+
+func (n *Node) broadcast(messageType MessageType, t TimeStamp) {
+	for i := 0; i < n.Network.Size(); i++ {
+		if i != n.Id {
+			n.Network.Send(i, Message{Sender: n.Id, Type: messageType, TimeStamp: t})
+		}
+	}
+}
@@ -0,0 +1,305 @@
+// Package lockserver is the mutual exclusion of P3_LockServer: node Server holds the lock and grants it to one
+// node at a time, queueing the other requests until the holder releases it.
+//
+// With a Lease, a grant only lasts that long unless the holder renews it, so a holder that crashed or stalled can't
+// keep the lock forever: the server takes it back and grants it to the next node in its queue. Every grant carries a
+// fencing token, one higher than the last, and a Fence on whatever the lock protects rejects the writes of a holder
+// whose token is older than one it has already seen.
+package lockserver
+
+import (
+	"distsys/common/sim"
+	"distsys/common/trace"
+	"errors"
+	"sync"
+	"time"
+)
+
+type Node struct {
+	Id            int
+	Inbox         <-chan Message // nil in a simulation, the simulator calls HandleMessage instead
+	Network       sim.Network[Message]
+	Runtime       sim.Runtime // runs the lease timers, only needed with a Lease or a Stall
+	Tracer        *trace.Tracer
+	HasLock       bool
+	Server        int
+	PriorityQueue []Message
+	Num           *int
+	Requesting    bool
+	StartRequests chan struct{} // closed once every node of the round is running
+	Done          func()        // called in every critical section, nil if nobody is counting them
+	ShouldRequest bool
+	HoldLock      bool // keeps the lock after the critical section until ReleaseLock, for mutex.Cluster
+
+	// the server's side of leases
+	Lease     time.Duration // how long a grant lasts without a renewal, 0 never expires
+	Holder    int           // the node granted the lock last
+	LastToken int64         // fencing token of the last grant
+	Expires   time.Time     // when the holder's lease runs out unless it renews it
+
+	// the holder's side
+	Holding   bool
+	Token     int64         // fencing token of this node's grant
+	LeaseEnds time.Time     // when this node's lease runs out as far as it knows, zero if it never does
+	Fence     *Fence        // checks Token in the critical section, nil if nothing is fenced
+	LeaseLost func()        // called when the server took back a lock this node still held, nil if nobody is told
+	Stall     time.Duration // freezes the node for this long the next time it is granted the lock, like a long GC pause
+
+	lease   time.Duration // of this node's grant
+	stalled bool
+	backlog []Message // received while stalled
+}
+
+type Message struct {
+	Sender int
+	Type   MessageType
+	Token  int64         // fencing token of the grant the message is about
+	Lease  time.Duration // LockGranted only
+	Trace  trace.Context
+}
+
+type MessageType int
+
+const (
+	Acquire MessageType = iota
+	Release
+	LockGranted
+	Kill
+	Renew
+	Renewed
+	Expired
+)
+
+var MESSAGE_TYPES = []string{"Acquire", "Release", "LockGranted", "Kill", "Renew", "Renewed", "Expired"}
+
+// RENEWALS_PER_LEASE is how often a holder renews its lease, so a few lost renewals don't cost it the lock
+const RENEWALS_PER_LEASE = 3
+
+var ErrStaleToken = errors.New("lockserver: stale fencing token")
+
+// Fence guards what the lock protects: it remembers the highest fencing token it has admitted and rejects older
+// ones. It is safe to use from every node's goroutine
+type Fence struct {
+	mu       sync.Mutex
+	highest  int64
+	Rejected int // writes turned away so far
+}
+
+// Admit lets a write with token through, unless a write with a later token already went through
+func (f *Fence) Admit(token int64) error {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	if token < f.highest {
+		f.Rejected++
+		return ErrStaleToken
+	}
+	f.highest = token
+	return nil
+}
+
+func (m Message) TraceInfo() trace.Info {
+	return trace.Info{Type: MESSAGE_TYPES[m.Type], PageId: trace.NO_PAGE}
+}
+
+func (m Message) WithTrace(ctx trace.Context) Message {
+	m.Trace = ctx
+	return m
+}
+
+// Start runs the node for real: it handles messages until it gets Kill and, if ShouldRequest, asks for the lock
+// once StartRequests is closed
+func (n *Node) Start() {
+	requested := false
+	for {
+		select {
+		case m := <-n.Inbox:
+			if m.Type == Kill {
+				return
+			}
+			n.HandleMessage(m)
+		case f := <-sim.EventsOf(n.Runtime):
+			f()
+		default:
+			if !requested && n.ShouldRequest {
+				<-n.StartRequests
+				n.Request()
+				requested = true
+			}
+		}
+	}
+}
+
+func (n *Node) Request() {
+	if n.Requesting || !n.ShouldRequest {
+		return
+	}
+	// fmt.Printf("%v : requesting\n", n.Id)
+	n.Tracer.Begin("lock request", trace.NO_PAGE)
+	n.Network.Send(n.Server, Message{Sender: n.Id, Type: Acquire})
+	n.Requesting = true
+}
+
+func (n *Node) HandleMessage(m Message) {
+	if n.stalled {
+		n.backlog = append(n.backlog, m)
+		return
+	}
+	n.Tracer.Receive(m.Trace, m.TraceInfo())
+	switch m.Type {
+	case Acquire:
+		if n.HasLock {
+			n.grant(m.Sender)
+			break
+		}
+		n.PriorityQueue = append(n.PriorityQueue, m)
+	case LockGranted:
+		if n.Holding && m.Token == n.Token {
+			// a duplicate of the grant this node is already in
+			break
+		}
+		n.Holding, n.Token, n.lease = true, m.Token, m.Lease
+		n.LeaseEnds = time.Time{}
+		if m.Lease > 0 {
+			n.LeaseEnds = n.Runtime.Now().Add(m.Lease)
+			n.Runtime.After(m.Lease/RENEWALS_PER_LEASE, func() { n.renew(m.Token) })
+		}
+		if n.Stall > 0 {
+			/**
+			Stop handling anything until the stall is over and then carry on into the critical section as if nothing
+			happened. The lease may have run out meanwhile and the lock gone to another node, only the Fence can tell
+			*/
+			n.stalled = true
+			n.Runtime.After(n.Stall, n.endStall)
+			n.Stall = 0
+			break
+		}
+		n.enter()
+	case Release:
+		if n.HasLock || m.Token != n.LastToken {
+			// the lease of the node releasing ran out, and the lock has moved on
+			break
+		}
+		n.grantNext()
+	case Renew:
+		if n.HasLock || m.Token != n.LastToken {
+			n.Network.Send(m.Sender, Message{Sender: n.Id, Type: Expired, Token: m.Token})
+			break
+		}
+		n.Expires = n.Runtime.Now().Add(n.Lease)
+		n.Network.Send(m.Sender, Message{Sender: n.Id, Type: Renewed, Token: m.Token})
+	case Renewed:
+		if n.Holding && m.Token == n.Token {
+			n.LeaseEnds = n.Runtime.Now().Add(n.lease)
+		}
+	case Expired:
+		if !n.Holding || m.Token != n.Token {
+			break
+		}
+		n.Tracer.Local("lease lost", trace.NO_PAGE)
+		n.Holding, n.Requesting = false, false
+		if n.LeaseLost != nil {
+			n.LeaseLost()
+		}
+	}
+}
+
+func (n *Node) enter() {
+	n.Tracer.Local("critical section", trace.NO_PAGE)
+	n.ExecuteCriticalSection(n.Num)
+	if n.Done != nil {
+		n.Done()
+	}
+	if !n.HoldLock {
+		n.ReleaseLock()
+	}
+}
+
+func (n *Node) endStall() {
+	n.stalled = false
+	n.enter()
+	backlog := n.backlog
+	n.backlog = nil
+	for _, m := range backlog {
+		n.HandleMessage(m)
+	}
+}
+
+// grant hands the lock to node to with the next fencing token, taking it back if the lease runs out
+func (n *Node) grant(to int) {
+	n.HasLock = false
+	n.Holder = to
+	n.LastToken++
+	token := n.LastToken
+	if n.Lease > 0 {
+		n.Expires = n.Runtime.Now().Add(n.Lease)
+		n.Runtime.After(n.Lease, func() { n.expire(token) })
+	}
+	n.Network.Send(to, Message{Sender: n.Id, Type: LockGranted, Token: token, Lease: n.Lease})
+}
+
+func (n *Node) grantNext() {
+	n.HasLock = true
+	if len(n.PriorityQueue) == 0 {
+		return
+	}
+	queuedMessage := n.PriorityQueue[0]
+	n.PriorityQueue = n.PriorityQueue[1:]
+	n.Tracer.Resume("grant", trace.NO_PAGE, queuedMessage.Trace)
+	n.grant(queuedMessage.Sender)
+}
+
+// expire takes the lock back from the holder of token unless it was released or renewed meanwhile
+func (n *Node) expire(token int64) {
+	if n.HasLock || token != n.LastToken {
+		return
+	}
+	if now := n.Runtime.Now(); now.Before(n.Expires) {
+		n.Runtime.After(n.Expires.Sub(now), func() { n.expire(token) })
+		return
+	}
+	n.Tracer.Local("lease expired", trace.NO_PAGE)
+	n.Network.Send(n.Holder, Message{Sender: n.Id, Type: Expired, Token: token})
+	n.grantNext()
+}
+
+func (n *Node) renew(token int64) {
+	if !n.Holding || token != n.Token {
+		return
+	}
+	if !n.stalled {
+		n.Network.Send(n.Server, Message{Sender: n.Id, Type: Renew, Token: token})
+	}
+	n.Runtime.After(n.lease/RENEWALS_PER_LEASE, func() { n.renew(token) })
+}
+
+// HoldsLease says whether this node holds the lock and, as far as it knows, its lease hasn't run out. The server
+// may still have taken it back already, which is what the fencing token is for
+func (n *Node) HoldsLease() bool {
+	return n.Holding && (n.LeaseEnds.IsZero() || n.Runtime.Now().Before(n.LeaseEnds))
+}
+
+// ReleaseLock leaves the critical section, giving the lock back to the server
+func (n *Node) ReleaseLock() {
+	n.Requesting = false
+	if !n.Holding {
+		// the lease ran out and the server already took the lock back
+		return
+	}
+	n.Holding = false
+	n.Network.Send(n.Server, Message{Sender: n.Id, Type: Release, Token: n.Token})
+}
+
+func (n *Node) ExecuteCriticalSection(num *int) {
+	// 	fmt.Printf(`-----------------------------------------------------------------------------------
+	// ----------%v : Has lock, executing critical section <Number to Add: %v>-------------
+	// -----------------------------------------------------------------------------------
+	// `, n.Id, *num)
+	if n.Fence != nil && n.Fence.Admit(n.Token) != nil {
+		return
+	}
+	*num += 1
+	// 	fmt.Printf(`-----------------------------------------------------------------------------------
+	// --------%v : Executed critical section <Number to Add: %v> Releasing lock-----------
+	// -----------------------------------------------------------------------------------
+	// `, n.Id, *num)
+}
@@ -0,0 +1,406 @@
+// Package mutex gives application code a distributed lock without it knowing which protocol runs underneath.
+// A Cluster runs every node of one of the algorithms in the packages below, each node on its own goroutine with
+// the nodes talking over channels, and hands out a DistributedMutex per node:
+//
+//	cluster, err := mutex.NewCluster(mutex.Config{Algorithm: mutex.RICART_AGRAWALA, Nodes: 5})
+//	defer cluster.Close()
+//	lock := cluster.Mutex(3)
+//	if err := lock.Lock(ctx); err != nil {
+//		return err
+//	}
+//	defer lock.Unlock()
+//
+// A lock server cluster with a Lease hands out a LeasedMutex instead, whose grant carries a fencing token and can be
+// taken back from a holder that stopped renewing it.
+package mutex
+
+import (
+	"context"
+	"distsys/PSet2/mutex/lamport"
+	"distsys/PSet2/mutex/lockserver"
+	"distsys/PSet2/mutex/raymond"
+	"distsys/PSet2/mutex/ricartagrawala"
+	"distsys/PSet2/mutex/suzukikasami"
+	"distsys/PSet2/mutex/tokenring"
+	"distsys/PSet2/mutex/voting"
+	"distsys/common/sim"
+	"distsys/common/trace"
+	"fmt"
+	"math/rand"
+	"strings"
+	"time"
+)
+
+// DistributedMutex is one node's handle on a lock shared by every node of a cluster
+type DistributedMutex interface {
+	// Lock blocks until this node holds the lock, or returns ctx's error once ctx is done without it
+	Lock(ctx context.Context) error
+	// Unlock lets the next node in. Like sync.Mutex it panics if the node doesn't hold the lock
+	Unlock()
+}
+
+// LeasedMutex is a DistributedMutex whose lock only lasts as long as the node keeps renewing its lease. The server
+// takes the lock back from a node that stops, e.g. while it is stalled, and grants it to the next one: whatever the
+// lock protects should check Token, e.g. with a lockserver.Fence, before every write
+type LeasedMutex interface {
+	DistributedMutex
+	// Token is the fencing token of the grant the last Lock got, one higher than every grant before it
+	Token() int64
+	// Lost is closed once the server has taken back the grant the last Lock got. Unlock is still needed after
+	Lost() <-chan struct{}
+}
+
+const RICART_AGRAWALA = "ricartagrawala"
+const VOTING = "voting"
+const LOCK_SERVER = "lockserver"
+const MAEKAWA = "maekawa" // voting on grid quorums
+const TOKEN_RING = "tokenring"
+const SUZUKI_KASAMI = "suzukikasami"
+const RAYMOND = "raymond"
+const LAMPORT = "lamport"
+
+var ALGORITHMS = []string{RICART_AGRAWALA, VOTING, LOCK_SERVER, MAEKAWA, TOKEN_RING, SUZUKI_KASAMI, RAYMOND, LAMPORT}
+
+const INBOX_SIZE = 100 // messages waiting for every node, per node in the cluster
+
+type Config struct {
+	Algorithm string // one of ALGORITHMS
+	Nodes     int
+	Trace     *trace.Recorder // records every node's messages and critical sections, nil if nothing is traced
+	Verbose   bool            // lets the nodes print what they do, like P1 to P3
+	Lease     time.Duration   // lock server only: how long the lock stays with a node that stops renewing it, 0 forever. Mutex then gives LeasedMutexes
+	Topology  string          // raymond only: one of raymond.TOPOLOGIES, raymond.BINARY if empty
+}
+
+// Cluster is every node of one algorithm
+type Cluster struct {
+	mutexes []*Mutex
+	leased  bool
+	stop    chan struct{}
+}
+
+func NewCluster(config Config) (*Cluster, error) {
+	if config.Lease != 0 && config.Algorithm != LOCK_SERVER {
+		return nil, fmt.Errorf("only %v takes a lease, not %v", LOCK_SERVER, config.Algorithm)
+	}
+	if config.Lease < 0 {
+		return nil, fmt.Errorf("negative lease %v", config.Lease)
+	}
+	c := &Cluster{leased: config.Lease > 0, stop: make(chan struct{})}
+	for i := 0; i < config.Nodes; i++ {
+		c.mutexes = append(c.mutexes, &Mutex{runtime: sim.NewRealRuntime(), held: make(chan struct{}, 1), granted: make(chan struct{}, 1)})
+	}
+	switch config.Algorithm {
+	case RICART_AGRAWALA:
+		c.startRicartAgrawala(config)
+	case VOTING:
+		c.startVoting(config, nil)
+	case MAEKAWA:
+		c.startVoting(config, voting.GridQuorums(config.Nodes))
+	case LOCK_SERVER:
+		c.startLockServer(config)
+	case TOKEN_RING:
+		c.startTokenRing(config)
+	case SUZUKI_KASAMI:
+		c.startSuzukiKasami(config)
+	case RAYMOND:
+		if err := c.startRaymond(config); err != nil {
+			return nil, err
+		}
+	case LAMPORT:
+		c.startLamport(config)
+	default:
+		return nil, fmt.Errorf("unknown algorithm %q, pick from %v", config.Algorithm, strings.Join(ALGORITHMS, ", "))
+	}
+	return c, nil
+}
+
+// Mutex returns node id's handle on the lock, a LeasedMutex if the cluster has a Lease
+func (c *Cluster) Mutex(id int) DistributedMutex {
+	if c.leased {
+		return leasedMutex{c.mutexes[id]}
+	}
+	return c.mutexes[id]
+}
+
+func (c *Cluster) Size() int {
+	return len(c.mutexes)
+}
+
+// Close stops every node. A Lock still waiting never returns unless its ctx is done
+func (c *Cluster) Close() {
+	close(c.stop)
+}
+
+// Mutex is a DistributedMutex on one node of any algorithm. The node is only touched from its own goroutine:
+// Lock and Unlock hand it functions to run through its runtime's Events
+type Mutex struct {
+	runtime *sim.RealRuntime
+	request func() // asks the node for the lock, set by the algorithm
+	release func() // leaves the critical section
+
+	held      chan struct{} // full while a Lock on this node hasn't been undone, so only one goroutine asks at a time
+	granted   chan struct{} // the node entered the critical section
+	abandoned bool          // Lock gave up waiting, the lock goes straight back when it comes. Node goroutine only
+
+	// lock server with a lease only, set on the node's goroutine before granted
+	token int64
+	lost  chan struct{}
+}
+
+type leasedMutex struct {
+	*Mutex
+}
+
+func (m leasedMutex) Token() int64 {
+	return m.token
+}
+
+func (m leasedMutex) Lost() <-chan struct{} {
+	return m.lost
+}
+
+func (m *Mutex) Lock(ctx context.Context) error {
+	select {
+	case m.held <- struct{}{}:
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+	m.runtime.Events <- m.request
+
+	select {
+	case <-m.granted:
+		return nil
+	case <-ctx.Done():
+		/**
+		None of the algorithms can take back a request, so the node keeps waiting for the lock and hands it back
+		as soon as it gets it. Until then no other Lock on this node may ask again
+		*/
+		m.runtime.Events <- func() {
+			select {
+			case <-m.granted:
+				m.release()
+				<-m.held
+			default:
+				m.abandoned = true
+			}
+		}
+		return ctx.Err()
+	}
+}
+
+func (m *Mutex) Unlock() {
+	if len(m.held) == 0 {
+		panic("mutex: Unlock of unlocked DistributedMutex")
+	}
+	m.runtime.Events <- m.release
+	<-m.held
+}
+
+// entered is the node's Done, it runs on the node's goroutine in the critical section
+func (m *Mutex) entered() {
+	if m.abandoned {
+		m.abandoned = false
+		// the node is still handling the message that let it in, leave once it is done
+		m.runtime.After(0, func() {
+			m.release()
+			<-m.held
+		})
+		return
+	}
+	m.granted <- struct{}{}
+}
+
+// serve runs node id's goroutine: it handles its messages and the functions its Mutex and timers give it
+func serve[M any](c *Cluster, network *sim.ChannelNetwork[M], id int, handle func(M)) {
+	go func() {
+		for {
+			select {
+			case m := <-network.Inbox(id):
+				handle(m)
+			case f := <-c.mutexes[id].runtime.Events:
+				f()
+			case <-c.stop:
+				return
+			}
+		}
+	}()
+}
+
+func nodeName(config Config, id int) string {
+	if config.Algorithm == LOCK_SERVER && id == 0 {
+		return "node 0 (server)"
+	}
+	return fmt.Sprintf("node %v", id)
+}
+
+func (c *Cluster) startRicartAgrawala(config Config) {
+	network := sim.NewChannelNetwork[ricartagrawala.Message](config.Nodes, config.Nodes*INBOX_SIZE)
+	for i, m := range c.mutexes {
+		tracer := config.Trace.Tracer(i, nodeName(config, i), sim.WallClock{})
+		node := &ricartagrawala.Node{
+			Id:       i,
+			State:    ricartagrawala.Idle,
+			Network:  trace.Wrap[ricartagrawala.Message](network, tracer),
+			Runtime:  m.runtime,
+			Tracer:   tracer,
+			Num:      new(int),
+			Done:     m.entered,
+			Quiet:    !config.Verbose,
+			HoldLock: true,
+		}
+		m.request, m.release = node.RandomLockRequest, node.ReleaseLock
+		serve(c, network, i, node.HandleRequest)
+	}
+}
+
+// startVoting runs the majority vote if quorums is nil, Maekawa's algorithm on them otherwise
+func (c *Cluster) startVoting(config Config, quorums [][]int) {
+	network := sim.NewChannelNetwork[voting.Message](config.Nodes, config.Nodes*INBOX_SIZE)
+	for i, m := range c.mutexes {
+		tracer := config.Trace.Tracer(i, nodeName(config, i), sim.WallClock{})
+		node := &voting.Node{
+			Id:       i,
+			State:    voting.Idle,
+			HasVote:  true,
+			Network:  trace.Wrap[voting.Message](network, tracer),
+			Runtime:  m.runtime,
+			Tracer:   tracer,
+			Num:      new(int),
+			Done:     m.entered,
+			Quiet:    !config.Verbose,
+			HoldLock: true,
+		}
+		if quorums != nil {
+			node.Quorum = quorums[i]
+		}
+		m.request, m.release = node.RandomLockRequest, node.ReleaseLock
+		serve(c, network, i, node.HandleRequest)
+	}
+}
+
+func (c *Cluster) startLockServer(config Config) {
+	network := sim.NewChannelNetwork[lockserver.Message](config.Nodes, config.Nodes*INBOX_SIZE)
+	for i, m := range c.mutexes {
+		tracer := config.Trace.Tracer(i, nodeName(config, i), sim.WallClock{})
+		node := &lockserver.Node{
+			Id:            i,
+			Network:       trace.Wrap[lockserver.Message](network, tracer),
+			Runtime:       m.runtime,
+			Tracer:        tracer,
+			HasLock:       i == 0,
+			Server:        0,
+			Num:           new(int),
+			ShouldRequest: true,
+			HoldLock:      true,
+			Lease:         config.Lease,
+		}
+		m := m
+		node.Done = func() {
+			m.token, m.lost = node.Token, make(chan struct{})
+			m.entered()
+		}
+		node.LeaseLost = func() {
+			close(m.lost)
+		}
+		m.request, m.release = node.Request, node.ReleaseLock
+		serve(c, network, i, node.HandleMessage)
+	}
+}
+
+func (c *Cluster) startTokenRing(config Config) {
+	network := sim.NewChannelNetwork[tokenring.Message](config.Nodes, config.Nodes*INBOX_SIZE)
+	for i, m := range c.mutexes {
+		tracer := config.Trace.Tracer(i, nodeName(config, i), sim.WallClock{})
+		node := &tokenring.Node{
+			Id:       i,
+			State:    tokenring.Idle,
+			HasToken: i == 0,
+			Network:  trace.Wrap[tokenring.Message](network, tracer),
+			Runtime:  m.runtime,
+			Tracer:   tracer,
+			Num:      new(int),
+			Pause:    tokenring.PAUSE,
+			Done:     m.entered,
+			Quiet:    !config.Verbose,
+			HoldLock: true,
+		}
+		node.Init()
+		m.request, m.release = node.RandomLockRequest, node.ReleaseLock
+		serve(c, network, i, node.HandleRequest)
+	}
+}
+
+func (c *Cluster) startSuzukiKasami(config Config) {
+	network := sim.NewChannelNetwork[suzukikasami.Message](config.Nodes, config.Nodes*INBOX_SIZE)
+	for i, m := range c.mutexes {
+		tracer := config.Trace.Tracer(i, nodeName(config, i), sim.WallClock{})
+		node := &suzukikasami.Node{
+			Id:       i,
+			State:    suzukikasami.Idle,
+			Network:  trace.Wrap[suzukikasami.Message](network, tracer),
+			Runtime:  m.runtime,
+			Tracer:   tracer,
+			Num:      new(int),
+			RN:       make([]int, config.Nodes),
+			Done:     m.entered,
+			Quiet:    !config.Verbose,
+			HoldLock: true,
+		}
+		if i == 0 {
+			node.Token = suzukikasami.NewToken(config.Nodes)
+		}
+		m.request, m.release = node.RandomLockRequest, node.ReleaseLock
+		serve(c, network, i, node.HandleRequest)
+	}
+}
+
+func (c *Cluster) startRaymond(config Config) error {
+	if config.Topology == "" {
+		config.Topology = raymond.BINARY
+	}
+	parents, err := raymond.Tree(config.Topology, config.Nodes, rand.New(rand.NewSource(time.Now().UnixNano())))
+	if err != nil {
+		return err
+	}
+	network := sim.NewChannelNetwork[raymond.Message](config.Nodes, config.Nodes*INBOX_SIZE)
+	for i, m := range c.mutexes {
+		tracer := config.Trace.Tracer(i, nodeName(config, i), sim.WallClock{})
+		node := &raymond.Node{
+			Id:       i,
+			State:    raymond.Idle,
+			Holder:   parents[i],
+			Network:  trace.Wrap[raymond.Message](network, tracer),
+			Runtime:  m.runtime,
+			Tracer:   tracer,
+			Num:      new(int),
+			Done:     m.entered,
+			Quiet:    !config.Verbose,
+			HoldLock: true,
+		}
+		m.request, m.release = node.RandomLockRequest, node.ReleaseLock
+		serve(c, network, i, node.HandleRequest)
+	}
+	return nil
+}
+
+func (c *Cluster) startLamport(config Config) {
+	network := sim.NewChannelNetwork[lamport.Message](config.Nodes, config.Nodes*INBOX_SIZE)
+	for i, m := range c.mutexes {
+		tracer := config.Trace.Tracer(i, nodeName(config, i), sim.WallClock{})
+		node := &lamport.Node{
+			Id:       i,
+			State:    lamport.Idle,
+			LastSeen: make([]int, config.Nodes),
+			Network:  trace.Wrap[lamport.Message](network, tracer),
+			Runtime:  m.runtime,
+			Tracer:   tracer,
+			Num:      new(int),
+			Done:     m.entered,
+			Quiet:    !config.Verbose,
+			HoldLock: true,
+		}
+		m.request, m.release = node.RandomLockRequest, node.ReleaseLock
+		serve(c, network, i, node.HandleRequest)
+	}
+}
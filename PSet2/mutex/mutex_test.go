@@ -0,0 +1,173 @@
+package mutex
+
+import (
+	"context"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+const TEST_NODES = 5
+const TEST_INCREMENTS = 20
+const TEST_TIMEOUT = 10 * time.Second
+
+// runCounter is Counter on a cluster: every node adds to one counter inside the lock. It returns the count,
+// the times a node found another one already inside and the Locks that timed out
+func runCounter(t *testing.T, config Config, increments int) (int, int32, int32) {
+	t.Helper()
+	cluster, err := NewCluster(config)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer cluster.Close()
+
+	counter := 0
+	inside := int32(0)
+	overlaps := int32(0)
+	timeouts := int32(0)
+	var wg sync.WaitGroup
+	for i := 0; i < cluster.Size(); i++ {
+		wg.Add(1)
+		go func(lock DistributedMutex) {
+			defer wg.Done()
+			for j := 0; j < increments; j++ {
+				ctx, cancel := context.WithTimeout(context.Background(), TEST_TIMEOUT)
+				err := lock.Lock(ctx)
+				cancel()
+				if err != nil {
+					atomic.AddInt32(&timeouts, 1)
+					continue
+				}
+				if atomic.AddInt32(&inside, 1) > 1 {
+					atomic.AddInt32(&overlaps, 1)
+				}
+				value := counter
+				time.Sleep(100 * time.Microsecond)
+				counter = value + 1
+				atomic.AddInt32(&inside, -1)
+				lock.Unlock()
+			}
+		}(cluster.Mutex(i))
+	}
+	wg.Wait()
+	return counter, overlaps, timeouts
+}
+
+func TestClusterCounter(t *testing.T) {
+	for _, algorithm := range ALGORITHMS {
+		algorithm := algorithm
+		t.Run(algorithm, func(t *testing.T) {
+			t.Parallel()
+			counter, overlaps, timeouts := runCounter(t, Config{Algorithm: algorithm, Nodes: TEST_NODES}, TEST_INCREMENTS)
+			if timeouts > 0 {
+				t.Errorf("%v Locks timed out", timeouts)
+			}
+			if overlaps > 0 {
+				t.Errorf("%v overlapping critical sections", overlaps)
+			}
+			if want := TEST_NODES * TEST_INCREMENTS; counter != want {
+				t.Errorf("counter %v, want %v", counter, want)
+			}
+		})
+	}
+}
+
+// TestVotingManyNodes has every node ask at once, which used to deadlock majority voting from 3 nodes up
+func TestVotingManyNodes(t *testing.T) {
+	for _, nodes := range []int{3, 4, 7, 9} {
+		counter, overlaps, timeouts := runCounter(t, Config{Algorithm: VOTING, Nodes: nodes}, 10)
+		if timeouts > 0 || overlaps > 0 || counter != nodes*10 {
+			t.Errorf("%v nodes: counter %v of %v, %v overlaps, %v timeouts", nodes, counter, nodes*10, overlaps, timeouts)
+		}
+	}
+}
+
+func TestLockTimesOut(t *testing.T) {
+	cluster, err := NewCluster(Config{Algorithm: RICART_AGRAWALA, Nodes: 2})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer cluster.Close()
+	if err := cluster.Mutex(0).Lock(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+	defer cancel()
+	if err := cluster.Mutex(1).Lock(ctx); err != context.DeadlineExceeded {
+		t.Fatalf("Lock while node 0 holds the lock returned %v, want %v", err, context.DeadlineExceeded)
+	}
+	cluster.Mutex(0).Unlock()
+	// the abandoned request hands the lock straight back, so node 0 gets it again
+	ctx, cancel = context.WithTimeout(context.Background(), TEST_TIMEOUT)
+	defer cancel()
+	if err := cluster.Mutex(0).Lock(ctx); err != nil {
+		t.Fatalf("Lock after an abandoned request: %v", err)
+	}
+	cluster.Mutex(0).Unlock()
+}
+
+func TestLeaseOnlyForLockServer(t *testing.T) {
+	if _, err := NewCluster(Config{Algorithm: VOTING, Nodes: 3, Lease: time.Second}); err == nil {
+		t.Errorf("voting took a lease")
+	}
+	if _, err := NewCluster(Config{Algorithm: LOCK_SERVER, Nodes: 3, Lease: -time.Second}); err == nil {
+		t.Errorf("lockserver took a negative lease")
+	}
+	cluster, err := NewCluster(Config{Algorithm: LOCK_SERVER, Nodes: 3})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer cluster.Close()
+	if _, ok := cluster.Mutex(1).(LeasedMutex); ok {
+		t.Errorf("lockserver without a lease gave a LeasedMutex")
+	}
+}
+
+func TestLeasedMutex(t *testing.T) {
+	cluster, err := NewCluster(Config{Algorithm: LOCK_SERVER, Nodes: 3, Lease: 50 * time.Millisecond})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer cluster.Close()
+	lock := func(id int) LeasedMutex {
+		t.Helper()
+		m := cluster.Mutex(id).(LeasedMutex)
+		ctx, cancel := context.WithTimeout(context.Background(), TEST_TIMEOUT)
+		defer cancel()
+		if err := m.Lock(ctx); err != nil {
+			t.Fatalf("node %v: %v", id, err)
+		}
+		return m
+	}
+
+	first := lock(1)
+	// held over several leases, the renewals keep it
+	time.Sleep(200 * time.Millisecond)
+	select {
+	case <-first.Lost():
+		t.Fatalf("node 1 lost a lease it kept renewing")
+	default:
+	}
+	token := first.Token()
+	first.Unlock()
+
+	second := lock(2)
+	if second.Token() <= token {
+		t.Errorf("node 2 got token %v after node 1's %v", second.Token(), token)
+	}
+	// node 2 stalls, like a long GC pause, so its renewals stop and the server takes the lock back
+	cluster.mutexes[2].runtime.Events <- func() { time.Sleep(200 * time.Millisecond) }
+	select {
+	case <-second.Lost():
+	case <-time.After(TEST_TIMEOUT):
+		t.Fatalf("node 2 never lost the lease it stopped renewing")
+	}
+	token = second.Token()
+	third := lock(1)
+	if third.Token() <= token {
+		t.Errorf("node 1 got token %v after node 2's %v", third.Token(), token)
+	}
+	second.Unlock()
+	third.Unlock()
+}
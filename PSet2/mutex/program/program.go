@@ -0,0 +1,232 @@
+// Package program is the main of P4 to P7, which only differ in the nodes they make. Every node asks for the lock
+// again as soon as it is idle and stays in the critical section for -hold. The nodes either run for real, each on its
+// own goroutine talking over channels until enter is pressed, or with -seed in the deterministic simulator, every
+// message delivered one at a time in an order picked by the seed. Every entry checks that no other node is inside
+// and is counted, so the run shows every node's entries and exits 1 on an overlap
+package program
+
+import (
+	"distsys/common/faults"
+	"distsys/common/sim"
+	"distsys/common/trace"
+	"flag"
+	"fmt"
+	"math/rand"
+	"os"
+	"sync"
+	"time"
+)
+
+const (
+	NUM_OF_NODES = 11
+)
+
+// Node is a node of any of the algorithms, with its Message type M
+type Node[M any] interface {
+	HandleRequest(m M)
+	RequestIfIdle()
+	ReleaseLock()
+}
+
+// Env is what a program makes node Id with. The node has to keep the lock after its critical section (HoldLock)
+// and call Done in it, the program releases the lock after -hold
+type Env[M any] struct {
+	Id      int
+	Nodes   int
+	Network sim.Network[M] // with the faults injected and every message traced
+	Runtime sim.Runtime
+	Tracer  *trace.Tracer
+	Num     *int // the number every node adds to in its critical section
+	Done    func()
+}
+
+// Flags are the flags every program takes
+type Flags struct {
+	Seed       int64
+	SimTime    time.Duration
+	FaultsPath string
+	TracePath  string
+	Nodes      int
+	Hold       time.Duration
+}
+
+// ParseFlags declares every program's flags, next to any the program declared itself, and parses them
+func ParseFlags() Flags {
+	seed := flag.Int64("seed", 0, "run in a deterministic simulation with this seed instead of for real, the same seed gives the same run")
+	simTime := flag.Duration("simtime", 10*time.Second, "virtual time a simulation runs for")
+	faultsPath := flag.String("faults", "", "JSON file of faults to inject into the network, see common/faults")
+	tracePath := flag.String("trace", "", "write a trace of every node's events to this file, see common/trace")
+	numOfNodes := flag.Int("nodes", NUM_OF_NODES, "number of nodes")
+	hold := flag.Duration("hold", time.Millisecond, "time every node stays in the critical section, so overlapping ones can be caught")
+	flag.Parse()
+
+	if *numOfNodes < 1 {
+		fmt.Println("-nodes has to be at least 1")
+		os.Exit(2)
+	}
+	if *hold <= 0 {
+		// the token holder would enter again and again without the virtual clock moving
+		fmt.Println("-hold has to be above 0")
+		os.Exit(2)
+	}
+	return Flags{Seed: *seed, SimTime: *simTime, FaultsPath: *faultsPath, TracePath: *tracePath, Nodes: *numOfNodes, Hold: *hold}
+}
+
+// Rand is the randomness a program makes its nodes with, seeded with -seed in a simulation so the run stays the same
+func (f Flags) Rand() *rand.Rand {
+	if f.Seed != 0 {
+		return rand.New(rand.NewSource(f.Seed))
+	}
+	return rand.New(rand.NewSource(time.Now().UnixNano()))
+}
+
+// Run runs the nodes newNode makes, and exits 1 if two of them were ever in the critical section at once
+func Run[M trace.Traceable[M]](flags Flags, newNode func(env Env[M]) Node[M]) {
+	var ok bool
+	var err error
+	if flags.Seed != 0 {
+		ok, err = simulate(flags, newNode)
+	} else {
+		ok, err = run(flags, newNode)
+	}
+	if err != nil {
+		fmt.Println(err)
+		os.Exit(1)
+	}
+	if !ok {
+		os.Exit(1)
+	}
+}
+
+// entries checks and counts the critical sections. Nodes running for real enter on their own goroutines
+type entries struct {
+	mu       sync.Mutex
+	inside   int // nodes in the critical section right now
+	overlaps int // entries while another node was inside
+	count    []int
+}
+
+func (e *entries) enter(id int) {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	if e.inside++; e.inside > 1 {
+		fmt.Printf("%v : entered the critical section with another node inside\n", id)
+		e.overlaps++
+	}
+	e.count[id]++
+}
+
+func (e *entries) leave() {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	e.inside--
+}
+
+// report prints every node's entries and whether none of them overlapped
+func (e *entries) report() bool {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	fmt.Printf("critical sections of every node: %v, %v overlapping\n", e.count, e.overlaps)
+	return e.overlaps == 0
+}
+
+// start makes node id and has it leave the critical section after hold, asking for the lock again straight away
+func start[M trace.Traceable[M]](flags Flags, e *entries, env Env[M], newNode func(env Env[M]) Node[M]) Node[M] {
+	var node Node[M]
+	env.Done = func() {
+		e.enter(env.Id)
+		env.Runtime.After(flags.Hold, func() {
+			e.leave()
+			node.ReleaseLock()
+			node.RequestIfIdle()
+		})
+	}
+	node = newNode(env)
+	return node
+}
+
+func run[M trace.Traceable[M]](flags Flags, newNode func(env Env[M]) Node[M]) (bool, error) {
+	network := sim.NewChannelNetwork[M](flags.Nodes, flags.Nodes*10)
+	injector, err := faults.Open(flags.FaultsPath, sim.WallClock{}, nil)
+	if err != nil {
+		return false, fmt.Errorf("could not load faults: %v", err)
+	}
+	recorder, err := trace.Open(flags.TracePath)
+	if err != nil {
+		return false, fmt.Errorf("could not open trace: %v", err)
+	}
+	defer recorder.Close()
+
+	valueToAdd := 0
+	e := &entries{count: make([]int, flags.Nodes)}
+	for i := 0; i < flags.Nodes; i++ {
+		tracer := recorder.Tracer(i, fmt.Sprintf("node %v", i), sim.WallClock{})
+		runtime := sim.NewRealRuntime()
+		node := start(flags, e, Env[M]{
+			Id:      i,
+			Nodes:   flags.Nodes,
+			Network: trace.Wrap[M](faults.Wrap[M](network, i, injector), tracer),
+			Runtime: runtime,
+			Tracer:  tracer,
+			Num:     &valueToAdd,
+		}, newNode)
+
+		go func(inbox <-chan M) {
+			for {
+				select {
+				case m := <-inbox:
+					node.HandleRequest(m)
+				case f := <-runtime.Events:
+					f()
+				default:
+					node.RequestIfIdle()
+				}
+			}
+		}(network.Inbox(i))
+	}
+
+	var input string
+	fmt.Scanln(&input)
+	return e.report(), nil
+}
+
+func simulate[M trace.Traceable[M]](flags Flags, newNode func(env Env[M]) Node[M]) (bool, error) {
+	s := sim.New(flags.Seed)
+	network := sim.NewSimNetwork[M](s, flags.Nodes)
+	injector, err := faults.Open(flags.FaultsPath, s, s.Rand)
+	if err != nil {
+		return false, fmt.Errorf("could not load faults: %v", err)
+	}
+	recorder, err := trace.Open(flags.TracePath)
+	if err != nil {
+		return false, fmt.Errorf("could not open trace: %v", err)
+	}
+	defer recorder.Close()
+
+	valueToAdd := 0
+	e := &entries{count: make([]int, flags.Nodes)}
+	for i := 0; i < flags.Nodes; i++ {
+		tracer := recorder.Tracer(i, fmt.Sprintf("node %v", i), s)
+		node := start(flags, e, Env[M]{
+			Id:      i,
+			Nodes:   flags.Nodes,
+			Network: trace.Wrap[M](faults.Wrap[M](network, i, injector), tracer),
+			Runtime: s,
+			Tracer:  tracer,
+			Num:     &valueToAdd,
+		}, newNode)
+		network.Handle(i, func(m M) {
+			node.HandleRequest(m)
+			node.RequestIfIdle()
+		})
+		s.After(0, node.RequestIfIdle)
+	}
+
+	s.Run(flags.SimTime)
+	fmt.Printf("seed %v: %v critical sections in %v of virtual time, %v events\n", flags.Seed, valueToAdd, s.Elapsed(), s.Steps())
+	ok := e.report()
+	if injector != nil {
+		fmt.Println(injector)
+	}
+	return ok, nil
+}
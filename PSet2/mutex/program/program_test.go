@@ -0,0 +1,105 @@
+package program
+
+import (
+	"distsys/PSet2/mutex/lamport"
+	"distsys/PSet2/mutex/raymond"
+	"distsys/PSet2/mutex/section"
+	"distsys/PSet2/mutex/suzukikasami"
+	"distsys/PSet2/mutex/tokenring"
+	"distsys/common/trace"
+	"fmt"
+	"testing"
+	"time"
+)
+
+const TEST_NODES = 5
+
+// noLock enters whenever it is asked to, without asking anyone
+type noLock struct {
+	env  Env[tokenring.Message]
+	busy bool
+}
+
+func (n *noLock) HandleRequest(m tokenring.Message) {}
+
+func (n *noLock) RequestIfIdle() {
+	if !n.busy {
+		n.busy = true
+		section.Execute(n.env.Id, n.env.Num, true, n.env.Done)
+	}
+}
+
+func (n *noLock) ReleaseLock() {
+	n.busy = false
+}
+
+func testFlags(seed int64) Flags {
+	return Flags{Seed: seed, SimTime: time.Second, Nodes: TEST_NODES, Hold: time.Millisecond}
+}
+
+func simulateQuietly[M trace.Traceable[M]](t *testing.T, flags Flags, newNode func(env Env[M]) Node[M]) bool {
+	t.Helper()
+	ok, err := simulate(flags, newNode)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return ok
+}
+
+func TestNoOverlaps(t *testing.T) {
+	for seed := int64(1); seed <= 5; seed++ {
+		flags := testFlags(seed)
+		runs := map[string]func(t *testing.T) bool{
+			"tokenring": func(t *testing.T) bool {
+				return simulateQuietly(t, flags, func(env Env[tokenring.Message]) Node[tokenring.Message] {
+					node := &tokenring.Node{Id: env.Id, State: tokenring.Idle, HasToken: env.Id == 0, Network: env.Network,
+						Runtime: env.Runtime, Num: env.Num, Pause: tokenring.PAUSE, Done: env.Done, Quiet: true, HoldLock: true}
+					node.Init()
+					return node
+				})
+			},
+			"suzukikasami": func(t *testing.T) bool {
+				return simulateQuietly(t, flags, func(env Env[suzukikasami.Message]) Node[suzukikasami.Message] {
+					node := &suzukikasami.Node{Id: env.Id, State: suzukikasami.Idle, Network: env.Network, Runtime: env.Runtime,
+						Num: env.Num, RN: make([]int, env.Nodes), Done: env.Done, Quiet: true, HoldLock: true}
+					if env.Id == 0 {
+						node.Token = suzukikasami.NewToken(env.Nodes)
+					}
+					return node
+				})
+			},
+			"raymond": func(t *testing.T) bool {
+				parents, err := raymond.Tree(raymond.RANDOM, flags.Nodes, flags.Rand())
+				if err != nil {
+					t.Fatal(err)
+				}
+				return simulateQuietly(t, flags, func(env Env[raymond.Message]) Node[raymond.Message] {
+					return &raymond.Node{Id: env.Id, State: raymond.Idle, Holder: parents[env.Id], Network: env.Network,
+						Runtime: env.Runtime, Num: env.Num, Done: env.Done, Quiet: true, HoldLock: true}
+				})
+			},
+			"lamport": func(t *testing.T) bool {
+				return simulateQuietly(t, flags, func(env Env[lamport.Message]) Node[lamport.Message] {
+					return &lamport.Node{Id: env.Id, State: lamport.Idle, LastSeen: make([]int, env.Nodes), Network: env.Network,
+						Runtime: env.Runtime, Num: env.Num, Done: env.Done, Quiet: true, HoldLock: true}
+				})
+			},
+		}
+		for algorithm, run := range runs {
+			t.Run(fmt.Sprintf("%v seed %v", algorithm, seed), func(t *testing.T) {
+				if !run(t) {
+					t.Error("two nodes were in the critical section at once")
+				}
+			})
+		}
+	}
+}
+
+func TestOverlapIsCaught(t *testing.T) {
+	ok := simulateQuietly(t, testFlags(1), func(env Env[tokenring.Message]) Node[tokenring.Message] {
+		return &noLock{env: env}
+	})
+	if ok {
+		t.Error("nodes that never ask for the lock weren't caught overlapping")
+	}
+}
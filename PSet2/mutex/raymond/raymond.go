@@ -0,0 +1,121 @@
+// Package raymond is Raymond's tree algorithm: the nodes form a spanning tree and every node's Holder points at the
+// neighbour on the way to the token, or at itself if it holds it. A request goes up the Holder pointers one hop at a
+// time and the token comes back down them, each node turning its pointer round as the token passes, so an entry
+// takes about the tree's diameter in messages instead of one for every node.
+package raymond
+
+import (
+	"distsys/PSet2/mutex/section"
+	"distsys/common/sim"
+	"distsys/common/trace"
+)
+
+type Node struct {
+	Id       int
+	State    StateType
+	Holder   int   // the neighbour towards the token, Id if this node holds it
+	Queue    []int // neighbours, and this node, whose requests wait for the token, first come first served
+	Asked    bool  // sent Holder a request for the head of Queue
+	Network  sim.Network[Message]
+	Runtime  sim.Runtime
+	Tracer   *trace.Tracer
+	Num      *int
+	Done     func() // called in every critical section, nil if nobody is counting them
+	Quiet    bool   // prints nothing, for benchmarks
+	HoldLock bool   // keeps the lock after the critical section until ReleaseLock, for mutex.Cluster and mutex/program
+}
+
+type Message struct {
+	Sender int
+	Type   MessageType
+	Trace  trace.Context
+}
+
+type MessageType int
+type StateType int
+
+const (
+	Request MessageType = iota
+	Privilege
+)
+
+var MESSAGE_TYPES = []string{"Request", "Privilege"}
+
+const (
+	HasLock StateType = iota
+	WaitingForToken
+	Idle
+)
+
+func (m Message) TraceInfo() trace.Info {
+	return trace.Info{Type: MESSAGE_TYPES[m.Type], PageId: trace.NO_PAGE}
+}
+
+func (m Message) WithTrace(ctx trace.Context) Message {
+	m.Trace = ctx
+	return m
+}
+
+func (n *Node) RequestIfIdle() {
+	if n.State == Idle {
+		n.RandomLockRequest()
+	}
+}
+
+func (n *Node) RandomLockRequest() {
+	n.State = WaitingForToken
+	section.Printf(n.Quiet, "%v : requesting lock\n", n.Id)
+	n.Tracer.Begin("lock request", trace.NO_PAGE)
+	n.Queue = append(n.Queue, n.Id)
+	n.assignPrivilege()
+	n.makeRequest()
+}
+
+func (n *Node) HandleRequest(m Message) {
+	n.Tracer.Receive(m.Trace, m.TraceInfo())
+	switch m.Type {
+	case Request:
+		n.Queue = append(n.Queue, m.Sender)
+	case Privilege:
+		n.Holder = n.Id
+	}
+	n.assignPrivilege()
+	n.makeRequest()
+}
+
+// ReleaseLock leaves the critical section, the token goes to whoever asked for it first
+func (n *Node) ReleaseLock() {
+	n.State = Idle
+	n.assignPrivilege()
+	n.makeRequest()
+}
+
+// assignPrivilege hands the token, if this node holds it and isn't using it, to the first request in the queue:
+// this node's own, which enters the critical section, or a neighbour's
+func (n *Node) assignPrivilege() {
+	if n.Holder != n.Id || n.State == HasLock || len(n.Queue) == 0 {
+		return
+	}
+	n.Holder = n.Queue[0]
+	n.Queue = n.Queue[1:]
+	n.Asked = false
+	if n.Holder != n.Id {
+		n.Network.Send(n.Holder, Message{Sender: n.Id, Type: Privilege})
+		return
+	}
+	n.State = HasLock
+	n.Tracer.Local("critical section", trace.NO_PAGE)
+	section.Execute(n.Id, n.Num, n.Quiet, n.Done)
+	if !n.HoldLock {
+		n.ReleaseLock()
+	}
+}
+
+// makeRequest asks Holder for the token once for the queue, if it is waiting for it
+func (n *Node) makeRequest() {
+	if n.Holder == n.Id || len(n.Queue) == 0 || n.Asked {
+		return
+	}
+	n.Network.Send(n.Holder, Message{Sender: n.Id, Type: Request})
+	n.Asked = true
+}
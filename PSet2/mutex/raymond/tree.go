@@ -0,0 +1,37 @@
+package raymond
+
+import (
+	"fmt"
+	"math/rand"
+)
+
+const LINE = "line"
+const STAR = "star"
+const BINARY = "binary"
+const RANDOM = "random"
+
+var TOPOLOGIES = []string{LINE, STAR, BINARY, RANDOM}
+
+// Tree returns the parent of every node in a spanning tree rooted at node 0, whose parent is itself: the Holder
+// every node starts with, node 0 holding the token. RANDOM hangs every node off a random earlier one picked with r
+func Tree(topology string, numOfNodes int, r *rand.Rand) ([]int, error) {
+	parents := make([]int, numOfNodes)
+	for i := 1; i < numOfNodes; i++ {
+		switch topology {
+		case LINE:
+			parents[i] = i - 1
+		case STAR:
+			parents[i] = 0
+		case BINARY:
+			parents[i] = (i - 1) / 2
+		case RANDOM:
+			parents[i] = r.Intn(i)
+		}
+	}
+	for _, t := range TOPOLOGIES {
+		if t == topology {
+			return parents, nil
+		}
+	}
+	return nil, fmt.Errorf("unknown topology %q", topology)
+}
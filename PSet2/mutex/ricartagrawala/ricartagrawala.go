@@ -0,0 +1,368 @@
+// Package ricartagrawala is the mutual exclusion of P1_SharedPQ: a node that wants the lock asks every node for it
+// and enters the critical section once all of them have replied. A node that holds the lock, or is waiting for it
+// with an earlier timestamp, keeps the request in its PriorityQueue and only replies once it is done.
+//
+// On its own one crashed node blocks every request, since its reply never comes. With a Heartbeat every node tells
+// the others it is alive, and keeps a membership view of the nodes it heard from lately: replies are only needed from
+// members, a node that goes quiet is dropped from the view and from the deferred requests, and it is let back in as
+// soon as it is heard from again. A dropped request is set aside and handled like a new one when its node comes back,
+// so a node that was only slow gets the reply it is still waiting for, and a crashed one ignores it. Dropping a node
+// that is only slow still lets this node in without its reply, so Heartbeat has to be well above the network's delays.
+package ricartagrawala
+
+import (
+	"distsys/common/sim"
+	"distsys/common/trace"
+	"fmt"
+	"sort"
+	"time"
+)
+
+type Node struct {
+	Id               int
+	Queue            []int
+	State            StateType
+	ReceivingChannel <-chan Message // nil in a simulation, the simulator calls HandleRequest instead
+	Network          sim.Network[Message]
+	Runtime          sim.Runtime
+	Tracer           *trace.Tracer
+	Num              *int
+	PriorityQueue    []TimeStamp
+	WaitingArray     []int
+	Request          TimeStamp
+	Done             func() // called in every critical section, nil if nobody is counting them
+	Quiet            bool   // prints nothing, for benchmarks
+	HoldLock         bool   // keeps the lock after the critical section until ReleaseLock, for mutex.Cluster
+
+	Heartbeat time.Duration // how often the node says it is alive, 0 waits for every node's reply like P1 always did
+	Members   []bool        // the membership view: nodes heard from in the last MISSED_HEARTBEATS heartbeats, nil is every node
+	LastHeard []time.Time
+	Dropped   []TimeStamp // deferred requests of nodes out of the view, handled again when they come back
+	IsDown    bool        // crashed in a simulation, it ignores every message and timer until it recovers
+}
+
+type Message struct {
+	Sender    int
+	Type      MessageType
+	TimeStamp TimeStamp // of the request, for a Reply the request it answers
+	Trace     trace.Context
+}
+
+type TimeStamp struct {
+	Id   int
+	Time int64
+}
+
+type MessageType int
+type StateType int
+
+const (
+	Acquire MessageType = iota
+	Reply
+	Heartbeat
+)
+
+var MESSAGE_TYPES = []string{"Acquire", "Reply", "Heartbeat"}
+
+// MISSED_HEARTBEATS is how many heartbeats in a row a node may miss before it is taken out of the view
+const MISSED_HEARTBEATS = 3
+
+const (
+	HasLock StateType = iota
+	WaitingForReplies
+	Idle
+)
+
+func (m Message) TraceInfo() trace.Info {
+	return trace.Info{Type: MESSAGE_TYPES[m.Type], PageId: trace.NO_PAGE}
+}
+
+func (m Message) WithTrace(ctx trace.Context) Message {
+	m.Trace = ctx
+	return m
+}
+
+// Start runs the node for real: it handles messages and timers and asks for the lock again whenever it is idle
+func (n *Node) Start() {
+	for {
+		select {
+		case m := <-n.ReceivingChannel:
+			n.HandleRequest(m)
+		case f := <-sim.EventsOf(n.Runtime):
+			f()
+		default:
+			n.RequestIfIdle()
+		}
+	}
+}
+
+func (n *Node) RequestIfIdle() {
+	if n.State == Idle && !n.IsDown {
+		n.RandomLockRequest()
+	}
+}
+
+func (n *Node) printf(format string, a ...interface{}) {
+	if !n.Quiet {
+		fmt.Printf(format, a...)
+	}
+}
+
+func (n *Node) ExecuteCriticalSection(num *int) {
+	n.printf(`-----------------------------------------------------------------------------------
+----------%v : Has lock, executing critical section <Number to Add: %v>------------
+-----------------------------------------------------------------------------------
+`, n.Id, *num)
+	*num += 1
+	n.printf(`-----------------------------------------------------------------------------------
+--------%v : Executed critical section <Number to Add: %v> Releasing lock----------
+-----------------------------------------------------------------------------------
+`, n.Id, *num)
+	if n.Done != nil {
+		n.Done()
+	}
+}
+
+func (n *Node) RandomLockRequest() {
+	// time.Sleep(time.Second * time.Duration(rand.Intn(3)))
+	n.State = WaitingForReplies
+	n.printf("%v : requesting lock, waiting for replies\n", n.Id)
+	n.Tracer.Begin("lock request", trace.NO_PAGE)
+	requestTimeStamp := TimeStamp{n.Id, n.Runtime.Now().UnixNano()}
+	n.Request = requestTimeStamp
+	m := Message{
+		Sender:    n.Id,
+		Type:      Acquire,
+		TimeStamp: requestTimeStamp,
+	}
+
+	for i := 0; i < n.Network.Size(); i++ {
+		n.Network.Send(i, m)
+	}
+}
+
+func (n *Node) HandleRequest(m Message) {
+	if n.IsDown {
+		return
+	}
+	n.Tracer.Receive(m.Trace, m.TraceInfo())
+	n.heardFrom(m.Sender)
+	switch m.Type {
+	case Acquire:
+		//if waiting for reply, compare to own request time
+		if n.State == HasLock || n.State == WaitingForReplies && n.Request.IsSmaller(m.TimeStamp) {
+			n.PriorityQueue = append(n.PriorityQueue, m.TimeStamp)
+			n.PriorityQueue = SortQueue(n.PriorityQueue)
+			break
+		}
+
+		//else reply
+		n.Network.Send(m.Sender, Message{Sender: n.Id, Type: Reply, TimeStamp: m.TimeStamp})
+
+	case Reply:
+		if n.State != WaitingForReplies || m.TimeStamp != n.Request {
+			// answers a request this node is done with, e.g. from before it crashed
+			break
+		}
+		//If I receive a reply I will check whether I have a reply from every one
+		if ArrayContains(n.WaitingArray, m.Sender) {
+			n.printf("%v : duplicate of %v found\n", n.Id, m.Sender)
+		}
+		n.WaitingArray = append(n.WaitingArray, m.Sender)
+		n.printf("%v : Reply received from %v. %v replies remaining. %v \n", n.Id, m.Sender, n.repliesMissing(), n.WaitingArray)
+		n.enterIfReplied()
+
+	case Heartbeat:
+		// heardFrom is all a heartbeat is for
+	}
+}
+
+// repliesMissing counts the members that haven't replied to the request yet
+func (n *Node) repliesMissing() int {
+	missing := 0
+	for i := 0; i < n.Network.Size(); i++ {
+		if n.IsMember(i) && !ArrayContains(n.WaitingArray, i) {
+			missing++
+		}
+	}
+	return missing
+}
+
+func (n *Node) enterIfReplied() {
+	if n.State != WaitingForReplies || n.repliesMissing() > 0 {
+		return
+	}
+	n.State = HasLock
+	n.Tracer.Local("critical section", trace.NO_PAGE)
+	n.ExecuteCriticalSection(n.Num)
+	if !n.HoldLock {
+		n.ReleaseLock()
+	}
+}
+
+// Init starts the heartbeats, with every node in the view. Nothing to do without a Heartbeat
+func (n *Node) Init() {
+	if n.Heartbeat == 0 {
+		return
+	}
+	n.Members = make([]bool, n.Network.Size())
+	n.LastHeard = make([]time.Time, n.Network.Size())
+	for i := range n.Members {
+		n.Members[i] = true
+		n.LastHeard[i] = n.Runtime.Now()
+	}
+	n.Runtime.After(n.Heartbeat, n.HandleHeartbeatTick)
+}
+
+func (n *Node) IsMember(id int) bool {
+	return n.Members == nil || n.Members[id]
+}
+
+// HandleHeartbeatTick runs every Heartbeat
+func (n *Node) HandleHeartbeatTick() {
+	/**
+	1. Tell every other node this node is alive
+	2. Take every member that missed MISSED_HEARTBEATS heartbeats out of the view
+	*/
+	n.Runtime.After(n.Heartbeat, n.HandleHeartbeatTick)
+	if n.IsDown {
+		return
+	}
+	for i := 0; i < n.Network.Size(); i++ {
+		if i != n.Id {
+			n.Network.Send(i, Message{Sender: n.Id, Type: Heartbeat})
+		}
+	}
+	for i := range n.Members {
+		if i != n.Id && n.Members[i] && n.Runtime.Now().Sub(n.LastHeard[i]) > MISSED_HEARTBEATS*n.Heartbeat {
+			n.suspect(i)
+		}
+	}
+}
+
+// suspect takes node id out of the view: its reply isn't needed any more and its deferred request is dropped,
+// kept in Dropped until the node is heard from again
+func (n *Node) suspect(id int) {
+	n.printf("%v : lost node %v, taking it out of the view\n", n.Id, id)
+	n.Tracer.Local(fmt.Sprintf("suspect %v", id), trace.NO_PAGE)
+	n.Members[id] = false
+	queue := []TimeStamp{}
+	for _, t := range n.PriorityQueue {
+		if t.Id == id {
+			n.Dropped = append(n.Dropped, t)
+		} else {
+			queue = append(queue, t)
+		}
+	}
+	n.PriorityQueue = queue
+	n.enterIfReplied()
+}
+
+// heardFrom notes that node id is alive, letting it back into the view if it had been taken out
+func (n *Node) heardFrom(id int) {
+	if n.Members == nil {
+		return
+	}
+	n.LastHeard[id] = n.Runtime.Now()
+	if n.Members[id] {
+		return
+	}
+	n.printf("%v : node %v is back, letting it into the view\n", n.Id, id)
+	n.Tracer.Local(fmt.Sprintf("rejoin %v", id), trace.NO_PAGE)
+	n.Members[id] = true
+	if n.State == WaitingForReplies && !ArrayContains(n.WaitingArray, id) {
+		// the request may have been sent while it was down, and its reply is needed again
+		n.Network.Send(id, Message{Sender: n.Id, Type: Acquire, TimeStamp: n.Request})
+	}
+	// a node that was only slow is still waiting on the request it had deferred here, a crashed one ignores the reply
+	dropped := []TimeStamp{}
+	for _, t := range n.Dropped {
+		if t.Id != id {
+			dropped = append(dropped, t)
+			continue
+		}
+		if n.State == HasLock || n.State == WaitingForReplies && n.Request.IsSmaller(t) {
+			n.PriorityQueue = SortQueue(append(n.PriorityQueue, t))
+		} else {
+			n.Network.Send(id, Message{Sender: n.Id, Type: Reply, TimeStamp: t})
+		}
+	}
+	n.Dropped = dropped
+}
+
+// Crash takes the node down in a simulation, it ignores every message and timer until it recovers
+func (n *Node) Crash() {
+	n.printf("%v : crashed\n", n.Id)
+	n.Tracer.Local("crashed", trace.NO_PAGE)
+	n.IsDown = true
+}
+
+// Recover brings a crashed node back with nothing it knew before: no request, nothing deferred and every node in its view
+func (n *Node) Recover() {
+	n.printf("%v : recovered\n", n.Id)
+	n.Tracer.Begin("recovered", trace.NO_PAGE)
+	n.IsDown = false
+	n.State = Idle
+	n.PriorityQueue = nil
+	n.WaitingArray = nil
+	n.Dropped = nil
+	for i := range n.Members {
+		n.Members[i] = true
+		n.LastHeard[i] = n.Runtime.Now()
+	}
+}
+
+// ReleaseLock leaves the critical section, replying to every request deferred meanwhile
+func (n *Node) ReleaseLock() {
+	//reply to everyone else
+	for i := 0; i < len(n.PriorityQueue); i++ {
+		n.Network.Send(n.PriorityQueue[i].Id, Message{Sender: n.Id, Type: Reply, TimeStamp: n.PriorityQueue[i]})
+	}
+	n.PriorityQueue = nil
+	n.WaitingArray = nil
+	n.State = Idle
+}
+
+func (t *TimeStamp) IsEarliest(t_array []TimeStamp) bool {
+	for i := 0; i < len(t_array); i++ {
+		if t_array[i].Time < t.Time {
+			return false
+		}
+		if t_array[i].Time == t.Time && t_array[i].Id < t.Id {
+			return false
+		}
+	}
+	return true
+}
+
+func SortQueue(q []TimeStamp) []TimeStamp {
+	sort.Slice(q, func(i, j int) bool {
+		return q[j].Time > q[i].Time
+	})
+	return q
+}
+
+func (t *TimeStamp) IsSmaller(t2 TimeStamp) bool {
+	if t.Time == t2.Time {
+		return t.Id < t2.Id
+	}
+	return t.Time < t2.Time
+}
+
+func QueueContains(arr []TimeStamp, t TimeStamp) bool {
+	for i := 0; i < len(arr); i++ {
+		if arr[i] == t {
+			return true
+		}
+	}
+	return false
+}
+
+func ArrayContains(arr []int, t int) bool {
+	for i := 0; i < len(arr); i++ {
+		if arr[i] == t {
+			return true
+		}
+	}
+	return false
+}
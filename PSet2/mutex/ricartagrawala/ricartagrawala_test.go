@@ -0,0 +1,164 @@
+package ricartagrawala
+
+import (
+	"distsys/common/sim"
+	"testing"
+	"time"
+)
+
+const TEST_NODES = 5
+const TEST_HEARTBEAT = 50 * time.Millisecond
+
+// slowNetwork holds back every message node From sends for Hold, starting after its first request from Start on,
+// like a node stuck in a long GC pause that keeps receiving. Its request gets to the other nodes first, so they
+// have it deferred when they give up on the node
+type slowNetwork struct {
+	sim.Network[Message]
+	Sim   *sim.Simulator
+	From  int
+	Start time.Duration
+	Hold  time.Duration
+
+	end time.Duration // 0 until the node's request is out
+}
+
+func (n *slowNetwork) Send(to int, m Message) {
+	elapsed := n.Sim.Elapsed()
+	if m.Sender != n.From || elapsed < n.Start || (n.end != 0 && elapsed >= n.end) {
+		n.Network.Send(to, m)
+		return
+	}
+	if n.end == 0 {
+		n.Network.Send(to, m)
+		if m.Type == Acquire && to == n.Network.Size()-1 {
+			n.end = elapsed + n.Hold
+		}
+		return
+	}
+	n.Sim.After(n.end-elapsed, func() { n.Network.Send(to, m) })
+}
+
+// sentNetwork keeps every message sent instead of delivering it
+type sentNetwork struct {
+	size int
+	sent []Message
+	to   []int
+}
+
+func (n *sentNetwork) Send(to int, m Message) {
+	n.sent = append(n.sent, m)
+	n.to = append(n.to, to)
+}
+
+func (n *sentNetwork) Size() int {
+	return n.size
+}
+
+// runSim runs TEST_NODES nodes with heartbeats for d, every node asking for the lock again as soon as it is idle like
+// P1_SharedPQ's simulation does, and returns every node's critical sections before and after the time split
+func runSim(t *testing.T, seed int64, d time.Duration, split time.Duration, setup func(s *sim.Simulator, network sim.Network[Message], nodes []*Node) sim.Network[Message]) ([]int, []int) {
+	t.Helper()
+	s := sim.New(seed)
+	simNetwork := sim.NewSimNetwork[Message](s, TEST_NODES)
+	nodes := make([]*Node, TEST_NODES)
+	for i := range nodes {
+		nodes[i] = &Node{Id: i, State: Idle, Runtime: s, Num: new(int), Quiet: true, Heartbeat: TEST_HEARTBEAT}
+	}
+	network := setup(s, simNetwork, nodes)
+	before, after := make([]int, TEST_NODES), make([]int, TEST_NODES)
+	for _, node := range nodes {
+		node := node
+		node.Network = network
+		node.Done = func() {
+			if s.Elapsed() < split {
+				before[node.Id]++
+			} else {
+				after[node.Id]++
+			}
+		}
+		node.Init()
+		simNetwork.Handle(node.Id, func(m Message) {
+			node.HandleRequest(m)
+			node.RequestIfIdle()
+		})
+		s.After(0, node.RequestIfIdle)
+	}
+	s.Run(d)
+	return before, after
+}
+
+func TestCrashedNodeIsLeftOut(t *testing.T) {
+	for seed := int64(1); seed <= 3; seed++ {
+		before, after := runSim(t, seed, 4*time.Second, 2*time.Second, func(s *sim.Simulator, network sim.Network[Message], nodes []*Node) sim.Network[Message] {
+			s.After(time.Second, nodes[3].Crash)
+			s.After(3*time.Second, nodes[3].Recover)
+			return network
+		})
+		for id := range before {
+			// node 3 is down from 1s to 3s, everyone else has to carry on without it
+			if id != 3 && after[id] < 10 {
+				t.Errorf("seed %v: node %v entered %v times after node 3 crashed, entries before %v after %v", seed, id, after[id], before, after)
+			}
+		}
+	}
+}
+
+func TestRecoveredNodeGetsBackIn(t *testing.T) {
+	for seed := int64(1); seed <= 3; seed++ {
+		before, after := runSim(t, seed, 4*time.Second, 3*time.Second, func(s *sim.Simulator, network sim.Network[Message], nodes []*Node) sim.Network[Message] {
+			s.After(time.Second, nodes[3].Crash)
+			s.After(2*time.Second, nodes[3].Recover)
+			return network
+		})
+		for id := range after {
+			if after[id] < 10 {
+				t.Errorf("seed %v: node %v entered %v times in the last second, entries before %v after %v", seed, id, after[id], before, after)
+			}
+		}
+	}
+}
+
+func TestSlowNodeDoesNotStarve(t *testing.T) {
+	/**
+	Node 3 goes quiet for half a second, long enough for every other node to take it out of its view and drop its
+	deferred request. The request still needs its reply once node 3 is heard from again, or node 3 waits for it forever
+	*/
+	for seed := int64(1); seed <= 5; seed++ {
+		before, after := runSim(t, seed, 4*time.Second, 2*time.Second, func(s *sim.Simulator, network sim.Network[Message], nodes []*Node) sim.Network[Message] {
+			return &slowNetwork{Network: network, Sim: s, From: 3, Start: time.Second, Hold: 500 * time.Millisecond}
+		})
+		for id := range after {
+			if after[id] < 10 {
+				t.Errorf("seed %v: node %v entered %v times after node 3 was slow, entries before %v after %v", seed, id, after[id], before, after)
+			}
+		}
+	}
+}
+
+func TestSuspectedNodeIsDroppedAndReadmitted(t *testing.T) {
+	s := sim.New(1)
+	network := &sentNetwork{size: TEST_NODES}
+	node := &Node{Id: 0, State: HasLock, Network: network, Runtime: s, Num: new(int), Quiet: true, Heartbeat: TEST_HEARTBEAT}
+	node.Init()
+	node.PriorityQueue = []TimeStamp{{Id: 1, Time: 10}, {Id: 3, Time: 20}}
+
+	node.suspect(3)
+	if QueueContains(node.PriorityQueue, TimeStamp{Id: 3, Time: 20}) {
+		t.Errorf("node 3's request is still deferred after it was suspected: %v", node.PriorityQueue)
+	}
+	node.ReleaseLock()
+	for i, m := range network.sent {
+		if network.to[i] == 3 {
+			t.Errorf("released the lock to suspected node 3: %v", m)
+		}
+	}
+
+	network.sent, network.to = nil, nil
+	node.heardFrom(3)
+	if len(network.sent) != 1 || network.to[0] != 3 || network.sent[0].Type != Reply || network.sent[0].TimeStamp != (TimeStamp{Id: 3, Time: 20}) {
+		t.Errorf("sent %v to %v when node 3 came back, want a reply to its request", network.sent, network.to)
+	}
+	if len(node.Dropped) != 0 {
+		t.Errorf("node 3's request is still set aside after it came back: %v", node.Dropped)
+	}
+}
@@ -0,0 +1,28 @@
+// Package section is the critical section of the token and queue based algorithms: a node adds one to the number
+// every node shares, with the banner P1 to P3 print around it
+package section
+
+import "fmt"
+
+// Printf prints unless quiet, which benchmarks and mutex.Cluster set
+func Printf(quiet bool, format string, a ...interface{}) {
+	if !quiet {
+		fmt.Printf(format, a...)
+	}
+}
+
+// Execute is node id's critical section: it adds one to num and calls done, nil if nobody is counting them
+func Execute(id int, num *int, quiet bool, done func()) {
+	Printf(quiet, `-----------------------------------------------------------------------------------
+----------%v : Has lock, executing critical section <Number to Add: %v>------------
+-----------------------------------------------------------------------------------
+`, id, *num)
+	*num += 1
+	Printf(quiet, `-----------------------------------------------------------------------------------
+--------%v : Executed critical section <Number to Add: %v> Releasing lock----------
+-----------------------------------------------------------------------------------
+`, id, *num)
+	if done != nil {
+		done()
+	}
+}
@@ -0,0 +1,152 @@
+// Package suzukikasami is Suzuki and Kasami's broadcast token algorithm: a node that wants the lock tells every
+// node the number of its request, and the token goes to it once the holder is done. Every node keeps RN, the
+// latest request number of every node it has heard of, and the token carries LN, the number of every node's last
+// served request, with a queue of the nodes waiting for it. N messages per entry, none while the holder keeps it.
+package suzukikasami
+
+import (
+	"distsys/PSet2/mutex/section"
+	"distsys/common/sim"
+	"distsys/common/trace"
+)
+
+type Node struct {
+	Id       int
+	State    StateType
+	Network  sim.Network[Message]
+	Runtime  sim.Runtime
+	Tracer   *trace.Tracer
+	Num      *int
+	RN       []int  // latest request number of every node
+	Token    *Token // nil unless this node holds it, node 0 starts with it
+	Done     func() // called in every critical section, nil if nobody is counting them
+	Quiet    bool   // prints nothing, for benchmarks
+	HoldLock bool   // keeps the lock after the critical section until ReleaseLock, for mutex.Cluster and mutex/program
+}
+
+type Token struct {
+	LN    []int // number of every node's last served request
+	Queue []int // nodes waiting for the token, first served first
+}
+
+type Message struct {
+	Sender int
+	Type   MessageType
+	Number int    // Request only: the sender's request number
+	Token  *Token // TokenMessage only
+	Trace  trace.Context
+}
+
+type MessageType int
+type StateType int
+
+const (
+	Request MessageType = iota
+	TokenMessage
+)
+
+var MESSAGE_TYPES = []string{"Request", "Token"}
+
+const (
+	HasLock StateType = iota
+	WaitingForToken
+	Idle
+)
+
+func (m Message) TraceInfo() trace.Info {
+	return trace.Info{Type: MESSAGE_TYPES[m.Type], PageId: trace.NO_PAGE}
+}
+
+func (m Message) WithTrace(ctx trace.Context) Message {
+	m.Trace = ctx
+	return m
+}
+
+// NewToken is the token node 0 starts with, for numOfNodes nodes
+func NewToken(numOfNodes int) *Token {
+	return &Token{LN: make([]int, numOfNodes)}
+}
+
+func (n *Node) RequestIfIdle() {
+	if n.State == Idle {
+		n.RandomLockRequest()
+	}
+}
+
+// RandomLockRequest enters straight away if this node still holds the token, or asks every other node for it
+func (n *Node) RandomLockRequest() {
+	n.State = WaitingForToken
+	n.Tracer.Begin("lock request", trace.NO_PAGE)
+	if n.Token != nil {
+		n.enter()
+		return
+	}
+	n.RN[n.Id]++
+	section.Printf(n.Quiet, "%v : requesting lock, request number %v\n", n.Id, n.RN[n.Id])
+	for i := 0; i < n.Network.Size(); i++ {
+		if i != n.Id {
+			n.Network.Send(i, Message{Sender: n.Id, Type: Request, Number: n.RN[n.Id]})
+		}
+	}
+}
+
+func (n *Node) HandleRequest(m Message) {
+	n.Tracer.Receive(m.Trace, m.TraceInfo())
+	switch m.Type {
+	case Request:
+		if m.Number > n.RN[m.Sender] {
+			n.RN[m.Sender] = m.Number
+		}
+		//an idle holder hands the token over if the request isn't an old one it has served already
+		if n.Token != nil && n.State == Idle && n.RN[m.Sender] == n.Token.LN[m.Sender]+1 {
+			n.sendToken(m.Sender)
+		}
+
+	case TokenMessage:
+		n.Token = m.Token
+		n.enter()
+	}
+}
+
+func (n *Node) enter() {
+	n.State = HasLock
+	n.Tracer.Local("critical section", trace.NO_PAGE)
+	section.Execute(n.Id, n.Num, n.Quiet, n.Done)
+	if !n.HoldLock {
+		n.ReleaseLock()
+	}
+}
+
+// ReleaseLock leaves the critical section: it queues every node with an outstanding request and passes the token
+// to the first in the queue, if any
+func (n *Node) ReleaseLock() {
+	n.State = Idle
+	n.Token.LN[n.Id] = n.RN[n.Id]
+	for i := 0; i < n.Network.Size(); i++ {
+		if n.RN[i] == n.Token.LN[i]+1 && !queued(n.Token.Queue, i) {
+			n.Token.Queue = append(n.Token.Queue, i)
+		}
+	}
+	if len(n.Token.Queue) == 0 {
+		return
+	}
+	next := n.Token.Queue[0]
+	n.Token.Queue = n.Token.Queue[1:]
+	n.sendToken(next)
+}
+
+func (n *Node) sendToken(to int) {
+	section.Printf(n.Quiet, "%v : passing the token to %v\n", n.Id, to)
+	token := n.Token
+	n.Token = nil
+	n.Network.Send(to, Message{Sender: n.Id, Type: TokenMessage, Token: token})
+}
+
+func queued(queue []int, id int) bool {
+	for _, i := range queue {
+		if i == id {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,121 @@
+// Package tokenring is mutual exclusion with a token passed around a logical ring: node i hands it to node i+1, and
+// only the node holding it may enter the critical section. A node that wants the lock waits for the token to come
+// round, one message per node it passes whether anyone wants it or not.
+package tokenring
+
+import (
+	"distsys/PSet2/mutex/section"
+	"distsys/common/sim"
+	"distsys/common/trace"
+	"time"
+)
+
+type Node struct {
+	Id       int
+	State    StateType
+	HasToken bool // node 0 starts with it
+	Network  sim.Network[Message]
+	Runtime  sim.Runtime
+	Tracer   *trace.Tracer
+	Num      *int
+	Pause    time.Duration // how long a node that doesn't want the lock keeps the token, so an idle ring doesn't spin
+	Done     func()        // called in every critical section, nil if nobody is counting them
+	Quiet    bool          // prints nothing, for benchmarks
+	HoldLock bool          // keeps the lock after the critical section until ReleaseLock, for mutex.Cluster and mutex/program
+}
+
+type Message struct {
+	Sender int
+	Type   MessageType
+	Trace  trace.Context
+}
+
+type MessageType int
+type StateType int
+
+const (
+	Token MessageType = iota
+)
+
+var MESSAGE_TYPES = []string{"Token"}
+
+const (
+	HasLock StateType = iota
+	WaitingForToken
+	Idle
+)
+
+// PAUSE is the Pause of P4 and of nodes running for real, where passing the token on straight away keeps every node busy
+const PAUSE = 100 * time.Microsecond
+
+func (m Message) TraceInfo() trace.Info {
+	return trace.Info{Type: MESSAGE_TYPES[m.Type], PageId: trace.NO_PAGE}
+}
+
+func (m Message) WithTrace(ctx trace.Context) Message {
+	m.Trace = ctx
+	return m
+}
+
+// Init sets the token going if this node holds it
+func (n *Node) Init() {
+	if n.HasToken {
+		n.Runtime.After(n.Pause, n.passIfIdle)
+	}
+}
+
+func (n *Node) RequestIfIdle() {
+	if n.State == Idle {
+		n.RandomLockRequest()
+	}
+}
+
+// RandomLockRequest waits for the token, or enters straight away if it is still here
+func (n *Node) RandomLockRequest() {
+	n.State = WaitingForToken
+	section.Printf(n.Quiet, "%v : requesting lock, waiting for the token\n", n.Id)
+	n.Tracer.Begin("lock request", trace.NO_PAGE)
+	if n.HasToken {
+		n.enter()
+	}
+}
+
+func (n *Node) HandleRequest(m Message) {
+	n.Tracer.Receive(m.Trace, m.TraceInfo())
+	switch m.Type {
+	case Token:
+		n.HasToken = true
+		if n.State == WaitingForToken {
+			n.enter()
+			break
+		}
+		n.Runtime.After(n.Pause, n.passIfIdle)
+	}
+}
+
+func (n *Node) enter() {
+	n.State = HasLock
+	n.Tracer.Local("critical section", trace.NO_PAGE)
+	section.Execute(n.Id, n.Num, n.Quiet, n.Done)
+	if !n.HoldLock {
+		n.ReleaseLock()
+	}
+}
+
+// ReleaseLock leaves the critical section, passing the token on
+func (n *Node) ReleaseLock() {
+	n.State = Idle
+	n.pass()
+}
+
+// passIfIdle passes the token on unless this node asked for the lock meanwhile
+func (n *Node) passIfIdle() {
+	if n.HasToken && n.State == Idle {
+		n.pass()
+	}
+}
+
+func (n *Node) pass() {
+	n.HasToken = false
+	n.Network.Send((n.Id+1)%n.Network.Size(), Message{Sender: n.Id, Type: Token})
+}
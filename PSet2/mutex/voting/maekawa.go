@@ -0,0 +1,138 @@
+package voting
+
+import (
+	"distsys/common/trace"
+)
+
+/**
+Maekawa's algorithm on the same messages as the majority vote. Every node votes for one request at a time and
+only asks the nodes of its Quorum, any two of which share a node, so two nodes can't both hold every vote of their
+quorums. Waiting for every vote can deadlock, so voters and requesters also tell each other who should back off:
+	- Acquire asks for a vote. A voter that already gave its vote away queues the request and answers Failed if an
+	  earlier request is ahead of it, or asks the node it voted for to give the vote back (RescindVote, Maekawa's
+	  INQUIRE) if the new request is the earliest it knows of
+	- A requester gives a rescinded vote back with Relinquish once it got a Failed, as it can't win yet anyway,
+	  and keeps it if it is already in the critical section
+	- ReleaseVote gives the vote back after the critical section
+*/
+
+func (n *Node) handleQuorumMessage(m Message) {
+	switch m.Type {
+	case Acquire:
+		if n.HasVote {
+			n.voteFor(m.TimeStamp)
+			break
+		}
+		head, ok := n.earliestQueued()
+		n.PriorityQueue = append(n.PriorityQueue, m.TimeStamp)
+		if !m.TimeStamp.IsEarliest(append([]TimeStamp{n.VotedTo}, n.PriorityQueue...)) {
+			n.printf("%v : request from %v is behind %v, failing it\n", n.Id, m.Sender, n.VotedTo.Id)
+			n.Network.Send(m.Sender, Message{Sender: n.Id, Type: Failed})
+			break
+		}
+		if ok {
+			// the request that was first in line isn't any more, and every other queued one already failed
+			n.Network.Send(head.Id, Message{Sender: n.Id, Type: Failed})
+		}
+		if !n.Inquired {
+			n.printf("%v : request from %v is earlier, asking %v for my vote back\n", n.Id, m.Sender, n.VotedTo.Id)
+			n.Network.Send(n.VotedTo.Id, Message{Sender: n.Id, Type: RescindVote})
+			n.Inquired = true
+		}
+
+	case ReleaseVote, Relinquish:
+		if n.HasVote || m.Sender != n.VotedTo.Id {
+			break
+		}
+		if m.Type == Relinquish {
+			// it still wants the vote, after the earlier requests
+			n.PriorityQueue = append(n.PriorityQueue, n.VotedTo)
+		}
+		n.HasVote = true
+		n.Inquired = false
+		if head, ok := n.earliestQueued(); ok {
+			n.PriorityQueue = RemoveTimeStamp(n.PriorityQueue, head.Id)
+			n.voteFor(head)
+		}
+
+	case Vote:
+		if n.State != WaitingForReplies {
+			n.Network.Send(m.Sender, Message{Sender: n.Id, Type: ReleaseVote})
+			break
+		}
+		if !containsId(n.WaitingArray, m.Sender) {
+			n.WaitingArray = append(n.WaitingArray, m.Sender)
+		}
+		if len(n.WaitingArray) < len(n.Quorum) {
+			n.printf("%v : waiting for %v more votes\n", n.Id, len(n.Quorum)-len(n.WaitingArray))
+			break
+		}
+		n.State = HasLock
+		n.Inquiries = nil // the votes go back with ReleaseVote once this node is done
+		n.Tracer.Local("critical section", trace.NO_PAGE)
+		n.ExecuteCriticalSection(n.Num)
+		if !n.HoldLock {
+			n.ReleaseLock()
+		}
+
+	case RescindVote:
+		if n.State != WaitingForReplies || !containsId(n.WaitingArray, m.Sender) {
+			break
+		}
+		if n.GotFailed {
+			n.relinquish(m.Sender)
+			break
+		}
+		n.Inquiries = append(n.Inquiries, m.Sender)
+
+	case Failed:
+		if n.State != WaitingForReplies {
+			break
+		}
+		n.GotFailed = true
+		for _, voter := range n.Inquiries {
+			if containsId(n.WaitingArray, voter) {
+				n.relinquish(voter)
+			}
+		}
+		n.Inquiries = nil
+	}
+}
+
+func (n *Node) voteFor(t TimeStamp) {
+	n.printf("%v : voting to %v\n", n.Id, t.Id)
+	n.Network.Send(t.Id, Message{Sender: n.Id, Type: Vote})
+	n.HasVote = false
+	n.VotedTo = t
+	n.Inquired = false
+}
+
+// relinquish gives voter's vote back for an earlier request, this node asks for it again by staying in its queue
+func (n *Node) relinquish(voter int) {
+	n.printf("%v : giving %v's vote back\n", n.Id, voter)
+	for i := 0; i < len(n.WaitingArray); i++ {
+		if n.WaitingArray[i] == voter {
+			n.WaitingArray = append(n.WaitingArray[:i], n.WaitingArray[i+1:]...)
+			break
+		}
+	}
+	n.Network.Send(voter, Message{Sender: n.Id, Type: Relinquish})
+}
+
+func (n *Node) earliestQueued() (TimeStamp, bool) {
+	for _, t := range n.PriorityQueue {
+		if t.IsEarliest(n.PriorityQueue) {
+			return t, true
+		}
+	}
+	return TimeStamp{}, false
+}
+
+func containsId(ids []int, id int) bool {
+	for _, i := range ids {
+		if i == id {
+			return true
+		}
+	}
+	return false
+}
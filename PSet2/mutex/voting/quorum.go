@@ -0,0 +1,115 @@
+package voting
+
+import (
+	"fmt"
+	"math"
+)
+
+const MAJORITY = "majority"
+const GRID = "grid"
+const PLANE = "plane"
+
+var QUORUM_KINDS = []string{MAJORITY, GRID, PLANE}
+
+// Quorums returns every node's Quorum of the kind given, nil for MAJORITY
+func Quorums(kind string, numOfNodes int) ([][]int, error) {
+	switch kind {
+	case MAJORITY:
+		return nil, nil
+	case GRID:
+		return GridQuorums(numOfNodes), nil
+	case PLANE:
+		return PlaneQuorums(numOfNodes)
+	}
+	return nil, fmt.Errorf("unknown quorum %q", kind)
+}
+
+// GridQuorums lays the nodes out row by row in a grid ceil(sqrt(n)) wide, a node's quorum is its row and its column.
+// Any two quorums share the node in one's row and the other's column, about 2*sqrt(n) nodes each
+func GridQuorums(numOfNodes int) [][]int {
+	width := int(math.Ceil(math.Sqrt(float64(numOfNodes))))
+	quorums := make([][]int, numOfNodes)
+	for i := 0; i < numOfNodes; i++ {
+		row, column := i/width, i%width
+		for j := 0; j < numOfNodes; j++ {
+			if j/width == row || j%width == column {
+				quorums[i] = append(quorums[i], j)
+			}
+		}
+	}
+	return quorums
+}
+
+// PlaneQuorums takes the lines of the finite projective plane of order q as quorums, for n = q*q+q+1 nodes with q
+// prime: q+1 nodes each, about sqrt(n), and any two lines meet in exactly one node. Every node gets a line through it
+func PlaneQuorums(numOfNodes int) ([][]int, error) {
+	q := 2
+	for q*q+q+1 < numOfNodes {
+		q++
+	}
+	if q*q+q+1 != numOfNodes || !isPrime(q) {
+		return nil, fmt.Errorf("a projective plane needs q*q+q+1 nodes for a prime q (7, 13, 31, 57, ...), not %v", numOfNodes)
+	}
+
+	/**
+	1. The points, which are the nodes, and the lines are the same: the nonzero vectors (x, y, z) over the integers
+	   mod q, up to scaling, written with their first nonzero coordinate 1. A point is on a line if their dot product is 0
+	2. Match every node to a different line through it. Every point is on q+1 lines and every line has q+1 points,
+	   so there is always such a matching
+	*/
+	points := [][3]int{}
+	for x := 0; x < q; x++ {
+		for y := 0; y < q; y++ {
+			points = append(points, [3]int{1, x, y})
+		}
+	}
+	for y := 0; y < q; y++ {
+		points = append(points, [3]int{0, 1, y})
+	}
+	points = append(points, [3]int{0, 0, 1})
+	lines := make([][]int, numOfNodes)
+	for l, line := range points {
+		for p, point := range points {
+			if (line[0]*point[0]+line[1]*point[1]+line[2]*point[2])%q == 0 {
+				lines[l] = append(lines[l], p)
+			}
+		}
+	}
+
+	lineOf := make([]int, numOfNodes) // node to its line
+	nodeOf := make([]int, numOfNodes) // line to its node, -1 if none yet
+	for l := range nodeOf {
+		nodeOf[l] = -1
+	}
+	var match func(node int, seen []bool) bool
+	match = func(node int, seen []bool) bool {
+		for l, line := range lines {
+			if seen[l] || !containsId(line, node) {
+				continue
+			}
+			seen[l] = true
+			if nodeOf[l] == -1 || match(nodeOf[l], seen) {
+				nodeOf[l], lineOf[node] = node, l
+				return true
+			}
+		}
+		return false
+	}
+	quorums := make([][]int, numOfNodes)
+	for node := 0; node < numOfNodes; node++ {
+		match(node, make([]bool, numOfNodes))
+	}
+	for node := 0; node < numOfNodes; node++ {
+		quorums[node] = lines[lineOf[node]]
+	}
+	return quorums, nil
+}
+
+func isPrime(q int) bool {
+	for d := 2; d*d <= q; d++ {
+		if q%d == 0 {
+			return false
+		}
+	}
+	return q >= 2
+}
@@ -0,0 +1,86 @@
+package voting
+
+import (
+	"fmt"
+	"testing"
+)
+
+// checkQuorums fails the test unless every node is in its own quorum and every two quorums share a node.
+// It returns the size of the smallest intersection
+func checkQuorums(t *testing.T, name string, quorums [][]int) int {
+	t.Helper()
+	smallest := len(quorums)
+	for i, quorum := range quorums {
+		if !containsId(quorum, i) {
+			t.Errorf("%v: node %v isn't in its own quorum %v", name, i, quorum)
+		}
+		for j := i + 1; j < len(quorums); j++ {
+			shared := 0
+			for _, node := range quorums[j] {
+				if containsId(quorum, node) {
+					shared++
+				}
+			}
+			if shared == 0 {
+				t.Errorf("%v: quorums of nodes %v %v and %v %v share no node", name, i, quorum, j, quorums[j])
+			}
+			if shared < smallest {
+				smallest = shared
+			}
+		}
+	}
+	return smallest
+}
+
+func TestGridQuorumsIntersect(t *testing.T) {
+	// square and non-square, where the last row of the grid isn't full
+	for n := 1; n <= 40; n++ {
+		quorums := GridQuorums(n)
+		if len(quorums) != n {
+			t.Fatalf("%v nodes: %v quorums", n, len(quorums))
+		}
+		checkQuorums(t, "grid", quorums)
+	}
+}
+
+func TestPlaneQuorums(t *testing.T) {
+	for _, test := range []struct{ nodes, q int }{{7, 2}, {13, 3}, {31, 5}} {
+		quorums, err := PlaneQuorums(test.nodes)
+		if err != nil {
+			t.Fatalf("%v nodes: %v", test.nodes, err)
+		}
+		if smallest := checkQuorums(t, "plane", quorums); smallest != 1 {
+			t.Errorf("%v nodes: two lines meet in %v nodes, want exactly 1", test.nodes, smallest)
+		}
+		// every quorum is a different line of q+1 nodes, and every node is on q+1 of them
+		lines := map[string]bool{}
+		onLines := make([]int, test.nodes)
+		for i, quorum := range quorums {
+			if len(quorum) != test.q+1 {
+				t.Errorf("%v nodes: node %v's quorum %v has %v nodes, want %v", test.nodes, i, quorum, len(quorum), test.q+1)
+			}
+			key := fmt.Sprint(quorum)
+			for _, node := range quorum {
+				onLines[node]++
+			}
+			if lines[key] {
+				t.Errorf("%v nodes: node %v has the same quorum %v as another node", test.nodes, i, quorum)
+			}
+			lines[key] = true
+		}
+		for node, count := range onLines {
+			if count != test.q+1 {
+				t.Errorf("%v nodes: node %v is in %v quorums, want %v", test.nodes, node, count, test.q+1)
+			}
+		}
+	}
+}
+
+func TestPlaneQuorumsRejectsOtherSizes(t *testing.T) {
+	// 21 is 4*4+4+1 but 4 isn't prime
+	for _, n := range []int{1, 5, 8, 9, 21} {
+		if _, err := PlaneQuorums(n); err == nil {
+			t.Errorf("%v nodes: no error", n)
+		}
+	}
+}
@@ -0,0 +1,305 @@
+// Package voting is the mutual exclusion of P2_Voting: a node that wants the lock asks every node for its vote and
+// enters the critical section once a majority has voted for it. A node votes for the earliest request it knows of,
+// and rescinds its vote, once, when an earlier one arrives. The node voted for gives a rescinded vote back with
+// Relinquish unless it is in the critical section, and stays in the voter's queue.
+//
+// With a Quorum the node runs Maekawa's algorithm instead, see maekawa.go: it only asks the nodes of its quorum and
+// needs every one of their votes.
+package voting
+
+import (
+	"distsys/common/sim"
+	"distsys/common/trace"
+	"fmt"
+	"math"
+	"sort"
+	"time"
+)
+
+//=============================== STRUCTS AND HELPERS =========================================//
+//=============================================================================================//
+
+type Node struct {
+	Id               int
+	Queue            []int
+	State            StateType
+	HasVote          bool
+	VotedTo          TimeStamp
+	ReceivingChannel <-chan Message // nil in a simulation, the simulator calls HandleRequest instead
+	Network          sim.Network[Message]
+	Runtime          sim.Runtime
+	Tracer           *trace.Tracer
+	Num              *int
+	PriorityQueue    []TimeStamp
+	WaitingArray     []int
+	Done             func() // called in every critical section, nil if nobody is counting them
+	Quiet            bool   // prints nothing, for benchmarks
+	HoldLock         bool   // keeps the lock after the critical section until ReleaseLock, for mutex.Cluster
+
+	// Maekawa only
+	Quorum    []int // nodes asked for their votes, every one of which is needed. nil asks every node and needs a majority
+	Inquired  bool  // as a voter: asked the node it voted for to give the vote back
+	GotFailed bool  // the request can't get every vote yet, some voter prefers an earlier one
+	Inquiries []int // voters that asked for their vote back before this node knew whether it had failed
+}
+
+type Message struct {
+	Sender    int
+	Type      MessageType
+	TimeStamp TimeStamp
+	Trace     trace.Context
+}
+
+type TimeStamp struct {
+	Id   int
+	Time int
+}
+
+type MessageType int
+type StateType int
+
+const (
+	Acquire MessageType = iota
+	Vote
+	ReleaseVote
+	RescindVote
+	Failed
+	Relinquish
+)
+
+var MESSAGE_TYPES = []string{"Acquire", "Vote", "ReleaseVote", "RescindVote", "Failed", "Relinquish"}
+
+const (
+	HasLock StateType = iota
+	WaitingForReplies
+	Idle
+	Resting // waits a random 0 to 2 seconds before asking for the lock again
+)
+
+func (m Message) TraceInfo() trace.Info {
+	return trace.Info{Type: MESSAGE_TYPES[m.Type], PageId: trace.NO_PAGE}
+}
+
+func (m Message) WithTrace(ctx trace.Context) Message {
+	m.Trace = ctx
+	return m
+}
+
+// Checks whether current timestamp is earlier than all other timestamps in the array
+func (t *TimeStamp) IsEarliest(tArray []TimeStamp) bool {
+	for i := 0; i < len(tArray); i++ {
+		if t.Time > tArray[i].Time {
+			return false
+		}
+
+		//tie breaker using Id
+		if t.Time == tArray[i].Time && t.Id > tArray[i].Id {
+			return false
+		}
+	}
+	return true
+}
+
+// Removes machine <id>'s timestamp from a timestamp array
+func RemoveTimeStamp(tArray []TimeStamp, id int) []TimeStamp {
+	for i := 0; i < len(tArray); i++ {
+		if tArray[i].Id == id {
+			return append(tArray[:i], tArray[i+1:]...)
+		}
+	}
+	return tArray
+}
+
+func (n *Node) printf(format string, a ...interface{}) {
+	if !n.Quiet {
+		fmt.Printf(format, a...)
+	}
+}
+
+func (n *Node) ExecuteCriticalSection(num *int) {
+	n.printf(`-----------------------------------------------------------------------------------
+----------%v : Has lock, executing critical section <Number to Add: %v>------------
+-----------------------------------------------------------------------------------
+`, n.Id, *num)
+	*num += 1
+	n.printf(`-----------------------------------------------------------------------------------
+--------%v : Executed critical section <Number to Add: %v> Releasing lock----------
+-----------------------------------------------------------------------------------
+`, n.Id, *num)
+	if n.Done != nil {
+		n.Done()
+	}
+}
+
+func SortQueue(q []TimeStamp) []TimeStamp {
+	sort.Slice(q, func(i, j int) bool {
+		return q[i].Time < q[j].Time
+	})
+	return q
+}
+
+//========================= END OF STRUCTS AND HELPERS ========================================//
+//=============================================================================================//
+
+const STATUS_INTERVAL = 5 * time.Second
+
+// Start runs the node for real: it handles messages and timers, prints its status every STATUS_INTERVAL and
+// asks for the lock again whenever it is idle
+func (n *Node) Start() {
+	n.Runtime.After(STATUS_INTERVAL, n.PrintStatus)
+	for {
+		select {
+		case m := <-n.ReceivingChannel:
+			n.HandleRequest(m)
+
+		case f := <-sim.EventsOf(n.Runtime):
+			f()
+		default:
+			n.RestIfIdle()
+		}
+	}
+}
+
+func (n *Node) PrintStatus() {
+	n.printf("%v : vote with : %v\n time stamp: %v\n state: %v\n wait queue: %v\n", n.Id, n.VotedTo.Id, n.VotedTo.Time, n.State, n.WaitingArray)
+	n.Runtime.After(STATUS_INTERVAL, n.PrintStatus)
+}
+
+// RestIfIdle asks for the lock again after a random 0 to 2 seconds, the node keeps handling messages meanwhile
+func (n *Node) RestIfIdle() {
+	if n.State != Idle {
+		return
+	}
+	n.State = Resting
+	n.Runtime.After(time.Second*time.Duration(n.Runtime.Intn(3)), n.RandomLockRequest)
+}
+
+func (n *Node) HandleRequest(m Message) {
+	n.Tracer.Receive(m.Trace, m.TraceInfo())
+	if n.Quorum != nil {
+		n.handleQuorumMessage(m)
+		return
+	}
+	switch m.Type {
+	case Acquire:
+		if !n.HasVote && m.Sender == n.VotedTo.Id {
+			// the node asked again, e.g. after a late vote for its last request counted for this one
+			n.VotedTo = m.TimeStamp
+		} else {
+			n.enqueue(m.TimeStamp)
+		}
+		if n.HasVote {
+			head, _ := n.earliestQueued()
+			n.PriorityQueue = RemoveTimeStamp(n.PriorityQueue, head.Id)
+			n.voteFor(head)
+			break
+		}
+		n.rescindIfEarlier()
+	case Vote:
+		n.printf("%v : vote received from %v \n", n.Id, m.Sender)
+
+		if n.State != WaitingForReplies {
+			//release vote
+			n.Network.Send(m.Sender, Message{Sender: n.Id, Type: ReleaseVote})
+			break
+		}
+
+		//compute value of majority
+		majorityValue := int(math.Floor(float64(n.Network.Size())/2) + 1)
+		if !containsId(n.WaitingArray, m.Sender) {
+			n.WaitingArray = append(n.WaitingArray, m.Sender)
+		}
+		sort.Ints(n.WaitingArray)
+
+		//check whether has majority
+		if len(n.WaitingArray) >= majorityValue {
+			n.State = HasLock
+			n.Tracer.Local("critical section", trace.NO_PAGE)
+			n.ExecuteCriticalSection(n.Num)
+			if !n.HoldLock {
+				n.ReleaseLock()
+			}
+		} else {
+			n.printf("%v : waiting for %v more replies\n", n.Id, majorityValue-len(n.WaitingArray))
+			n.printf("%v : waiting array %v \n", n.Id, n.WaitingArray)
+		}
+	case ReleaseVote, Relinquish:
+		// only the node voted for gives the vote back, once
+		if n.HasVote || m.Sender != n.VotedTo.Id {
+			break
+		}
+		if m.Type == Relinquish {
+			// it still wants the vote, after the earlier request
+			n.enqueue(n.VotedTo)
+		}
+		n.HasVote = true
+		n.Inquired = false
+		//vote if there is a request waiting in the queue
+		if head, ok := n.earliestQueued(); ok {
+			n.printf("%v : released, voting to %v \n", n.Id, head.Id)
+			n.PriorityQueue = RemoveTimeStamp(n.PriorityQueue, head.Id)
+			n.voteFor(head)
+		}
+	case RescindVote:
+		//keep the vote while in the critical section, ReleaseLock returns it. A vote already given back needs nothing
+		if n.State != WaitingForReplies || !containsId(n.WaitingArray, m.Sender) {
+			break
+		}
+		n.relinquish(m.Sender)
+	}
+}
+
+// enqueue queues a request for this node's vote, in place of any earlier request of the same node, which is done
+func (n *Node) enqueue(t TimeStamp) {
+	n.PriorityQueue = append(RemoveTimeStamp(n.PriorityQueue, t.Id), t)
+	n.PriorityQueue = SortQueue(n.PriorityQueue)
+}
+
+// rescindIfEarlier asks for the vote back if a queued request is earlier than the one voted for, once per vote
+func (n *Node) rescindIfEarlier() {
+	head, ok := n.earliestQueued()
+	if !ok || n.Inquired || !head.IsEarliest([]TimeStamp{n.VotedTo}) {
+		return
+	}
+	n.printf("%v : request from %v is earlier than my vote's %v, rescinding vote from %v\n", n.Id, head.Id, n.VotedTo, n.VotedTo.Id)
+	n.Network.Send(n.VotedTo.Id, Message{Sender: n.Id, Type: RescindVote})
+	n.Inquired = true
+}
+
+// ReleaseLock leaves the critical section, giving back every vote
+func (n *Node) ReleaseLock() {
+	n.ReleaseAndReply()
+	n.State = Idle
+}
+
+func (n *Node) ReleaseAndReply() {
+	//pop self's timestamp off the priority queue
+	n.PriorityQueue = RemoveTimeStamp(n.PriorityQueue, n.Id)
+
+	//release vote to those who voted for me
+	for i := 0; i < len(n.WaitingArray); i++ {
+		n.Network.Send(n.WaitingArray[i], Message{Sender: n.Id, Type: ReleaseVote})
+	}
+
+	// clear waiting array and set state to idle
+	n.WaitingArray = nil
+}
+func (n *Node) RandomLockRequest() {
+	n.State = WaitingForReplies
+	n.printf("%v : requesting lock, waiting for replies\n", n.Id)
+	n.Tracer.Begin("lock request", trace.NO_PAGE)
+	requestTimeStamp := TimeStamp{n.Id, int(n.Runtime.Now().UnixNano())}
+
+	m := Message{Sender: n.Id, Type: Acquire, TimeStamp: requestTimeStamp}
+
+	if n.Quorum != nil {
+		n.GotFailed, n.Inquiries = false, nil
+		for _, i := range n.Quorum {
+			n.Network.Send(i, m)
+		}
+		return
+	}
+	for i := 0; i < n.Network.Size(); i++ {
+		n.Network.Send(i, m)
+	}
+}
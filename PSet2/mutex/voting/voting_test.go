@@ -0,0 +1,85 @@
+package voting
+
+import (
+	"distsys/common/sim"
+	"testing"
+	"time"
+)
+
+const TEST_HOLD = time.Millisecond
+
+// runSim runs nodes in a simulation for d, every one asking for the lock again as soon as it has released it, and
+// holding it for TEST_HOLD. It returns every node's critical sections and how many found another node inside
+func runSim(t *testing.T, seed int64, nodes int, quorums [][]int, d time.Duration) ([]int, int) {
+	t.Helper()
+	s := sim.New(seed)
+	network := sim.NewSimNetwork[Message](s, nodes)
+	entries := make([]int, nodes)
+	inside, overlaps := 0, 0
+	for i := 0; i < nodes; i++ {
+		node := &Node{Id: i, State: Idle, HasVote: true, Network: network, Runtime: s, Num: new(int), Quiet: true, HoldLock: true}
+		if quorums != nil {
+			node.Quorum = quorums[i]
+		}
+		node.Done = func() {
+			entries[node.Id]++
+			inside++
+			if inside > 1 {
+				overlaps++
+			}
+			s.After(TEST_HOLD, func() {
+				inside--
+				node.ReleaseLock()
+				s.After(0, node.RandomLockRequest)
+			})
+		}
+		network.Handle(i, node.HandleRequest)
+		s.After(0, node.RandomLockRequest)
+	}
+	s.Run(d)
+	return entries, overlaps
+}
+
+func TestEveryNodeAsksAtOnce(t *testing.T) {
+	/**
+	Every node asking again straight away keeps earlier requests arriving at voters that already voted, which used to
+	leave the majority vote with duplicate queue entries and dropped votes, so nobody was ever let in again
+	*/
+	tests := []struct {
+		name    string
+		nodes   int
+		quorums [][]int
+	}{
+		{"majority of 3", 3, nil},
+		{"majority of 4", 4, nil},
+		{"majority of 5", 5, nil},
+		{"majority of 7", 7, nil},
+		{"majority of 16", 16, nil},
+		{"grid of 5", 5, GridQuorums(5)},
+		{"grid of 9", 9, GridQuorums(9)},
+		{"plane of 7", 7, plane(t, 7)},
+	}
+	for _, test := range tests {
+		for seed := int64(1); seed <= 3; seed++ {
+			entries, overlaps := runSim(t, seed, test.nodes, test.quorums, 5*time.Second)
+			if overlaps > 0 {
+				t.Errorf("%v, seed %v: %v overlapping critical sections", test.name, seed, overlaps)
+			}
+			for id, n := range entries {
+				if n < 10 {
+					t.Errorf("%v, seed %v: node %v entered %v times in 5s, entries %v", test.name, seed, id, n, entries)
+					break
+				}
+			}
+		}
+	}
+}
+
+func plane(t *testing.T, nodes int) [][]int {
+	t.Helper()
+	quorums, err := PlaneQuorums(nodes)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return quorums
+}
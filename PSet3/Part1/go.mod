@@ -1,3 +1,7 @@
 module main
 
 go 1.18
+
+require distsys v0.0.0
+
+replace distsys => ../..
@@ -3,6 +3,7 @@ package lib
 import "time"
 
 type CentralManager struct {
+	Id                  int // which shard of the page directory this manager holds
 	PChannels           []chan Message
 	Incoming            chan Message
 	ConfirmationChan    chan Message
@@ -0,0 +1,196 @@
+package lib
+
+import (
+	"math/rand"
+	"time"
+)
+
+// DynamicProcessor runs Li & Hudak's dynamic distributed manager: there is no central manager,
+// every processor keeps a probable owner for each page and requests are forwarded along
+// the probOwner chain until they reach the real owner.
+type DynamicProcessor struct {
+	Id             int
+	Channels       []chan Message
+	NumOfVariables int
+	RequestMap     map[int]RequestStatus
+	Cache          map[int]CacheEntry
+	ProbOwner      map[int]int       // {[pageId]: processor believed to own the page}, InitialOwner if missing
+	CopySet        map[int][]int     // owner only: {[pageId]: processors holding a read copy}
+	Deferred       map[int][]Message // {[pageId]: requests that arrived while this processor was waiting to own the page}
+	Invalidations  map[int]int       // {[pageId]: INVALIDATE_CONFIRMATIONs the new owner still waits for}
+	InitialOwner   int               // owns every page at the start
+	Debug          bool
+	Stats          *Stats   // nil if completed requests aren't counted
+	History        *History // nil if no history is recorded
+}
+
+func (p *DynamicProcessor) Start() {
+	// ticker to make regular requests
+	reqTicker := time.NewTicker(time.Duration(rand.Intn(2 * int(time.Second))))
+	for {
+		select {
+		case <-reqTicker.C:
+			p.SendRandomRequest()
+
+		case m := <-p.Channels[p.Id]:
+			p.log(true, "%v message received", MESSAGE_TYPES[m.Type])
+			p.HandleMessage(m)
+		}
+	}
+}
+
+func (p *DynamicProcessor) HandleMessage(m Message) {
+	p.log(true, "%v message (%v) from %v for pageId %v", MESSAGE_TYPES[m.Type], m.Type, m.Sender, m.PageId)
+
+	switch m.Type {
+	case READ_REQUEST:
+		p.HandleReadRequest(m)
+	case WRITE_REQUEST:
+		p.HandleWriteRequest(m)
+	case PAGE_COPY_FORWARD:
+		// the owner sent a copy, it is also the best guess for the owner from now on
+		p.Cache[m.PageId] = CacheEntry{IsOwner: false, IsValid: true, Data: m.Content}
+		p.ProbOwner[m.PageId] = m.Sender
+		p.completeRequest(m.PageId, false)
+		p.HandleDeferred(m.PageId)
+	case PAGE_TO_WRITE:
+		p.HandlePageToWrite(m)
+	case INVALIDATE_COPY:
+		// the sender is the new owner
+		p.Cache[m.PageId] = CacheEntry{IsOwner: false, IsValid: false}
+		p.ProbOwner[m.PageId] = m.Sender
+		p.Channels[m.Sender] <- Message{Sender: p.Id, Type: INVALIDATE_CONFIRMATION, PageId: m.PageId}
+		p.log(false, "cache for pageId %v invalidated", m.PageId)
+	case INVALIDATE_CONFIRMATION:
+		p.Invalidations[m.PageId]--
+		if p.Invalidations[m.PageId] == 0 {
+			p.FinishWrite(m.PageId)
+		}
+	}
+}
+
+func (p *DynamicProcessor) HandleReadRequest(m Message) {
+	/**
+	1. Wait if this processor is about to become the owner
+	2. The owner adds the reader to the copyset and sends it a copy
+	3. Anyone else forwards the request to its probOwner
+	*/
+	if p.isAcquiring(m.PageId) {
+		p.Deferred[m.PageId] = append(p.Deferred[m.PageId], m)
+		return
+	}
+	if !p.Cache[m.PageId].IsOwner {
+		p.forward(m)
+		return
+	}
+	p.CopySet[m.PageId] = append(p.CopySet[m.PageId], m.Sender)
+	p.Channels[m.Sender] <- Message{Sender: p.Id, Type: PAGE_COPY_FORWARD, PageId: m.PageId, Content: p.Cache[m.PageId].Data}
+}
+
+func (p *DynamicProcessor) HandleWriteRequest(m Message) {
+	/**
+	1. Wait if this processor is about to become the owner
+	2. The owner hands the page and its copyset to the writer and gives up the page
+	3. Anyone else forwards the request to its probOwner
+	Either way the writer is the owner soon, so it becomes this processor's probOwner
+	*/
+	if p.isAcquiring(m.PageId) {
+		p.Deferred[m.PageId] = append(p.Deferred[m.PageId], m)
+		return
+	}
+	if !p.Cache[m.PageId].IsOwner {
+		p.forward(m)
+		p.ProbOwner[m.PageId] = m.Sender
+		return
+	}
+
+	copySet := p.CopySet[m.PageId]
+	delete(p.CopySet, m.PageId)
+	p.Channels[m.Sender] <- Message{Sender: p.Id, Type: PAGE_TO_WRITE, PageId: m.PageId, Content: p.Cache[m.PageId].Data, CopySet: copySet}
+	p.Cache[m.PageId] = CacheEntry{IsOwner: false, IsValid: false}
+	p.ProbOwner[m.PageId] = m.Sender
+	p.log(false, "page %v handed to %v", m.PageId, m.Sender)
+}
+
+func (p *DynamicProcessor) HandlePageToWrite(m Message) {
+	/**
+	Invalidate every copy in the old owner's copyset before taking over the page
+	*/
+	invalidations := 0
+	for _, copyHolder := range m.CopySet {
+		if copyHolder == p.Id {
+			continue
+		}
+		invalidations++
+		go func(copyHolder int) {
+			p.Channels[copyHolder] <- Message{Sender: p.Id, Type: INVALIDATE_COPY, PageId: m.PageId}
+		}(copyHolder)
+	}
+	p.Invalidations[m.PageId] = invalidations
+	if invalidations == 0 {
+		p.FinishWrite(m.PageId)
+	}
+}
+
+func (p *DynamicProcessor) FinishWrite(pageId int) {
+	p.Cache[pageId] = CacheEntry{IsOwner: true, IsValid: true, Data: p.Id}
+	p.ProbOwner[pageId] = p.Id
+	p.completeRequest(pageId, false)
+	p.log(false, "became owner of pageId %v", pageId)
+	p.HandleDeferred(pageId)
+}
+
+func (p *DynamicProcessor) HandleDeferred(pageId int) {
+	deferred := p.Deferred[pageId]
+	delete(p.Deferred, pageId)
+	for _, m := range deferred {
+		p.HandleMessage(m)
+	}
+}
+
+func (p *DynamicProcessor) SendRandomRequest() {
+	pageId, requestType, ok := RandomRequest(p.NumOfVariables, p.RequestMap, p.Cache)
+	if !ok {
+		return
+	}
+
+	requestState := PENDING_READ_COMPLETION
+	if requestType == WRITE_REQUEST {
+		requestState = PENDING_WRITE_COMPLETION
+	}
+
+	request := Message{ // make request
+		Sender: p.Id,
+		Type:   requestType,
+		PageId: pageId,
+	}
+	now := time.Now()
+	p.RequestMap[pageId] = RequestStatus{Timestamp: now.UnixNano(), State: requestState, Message: request,
+		OpId: p.History.Invoke(now, p.Id, requestType, pageId, p.Id)}
+	p.forward(request)
+	p.log(false, "%v request (%v) sent for page Id %v", MESSAGE_TYPES[requestType], requestType, pageId)
+}
+
+// isAcquiring is true while this processor's own WRITE_REQUEST is on its way to the owner or invalidating copies
+func (p *DynamicProcessor) isAcquiring(pageId int) bool {
+	return p.RequestMap[pageId].State == PENDING_WRITE_COMPLETION
+}
+
+func (p *DynamicProcessor) forward(m Message) {
+	probOwner, ok := p.ProbOwner[m.PageId]
+	if !ok {
+		probOwner = p.InitialOwner
+	}
+	p.log(true, "forwarding %v from %v for pageId %v to %v", MESSAGE_TYPES[m.Type], m.Sender, m.PageId, probOwner)
+	go func() {
+		p.Channels[probOwner] <- m
+	}()
+}
+
+// completeRequest records the end of the pending request for pageId, a read returns what is now in the cache
+func (p *DynamicProcessor) completeRequest(pageId int, notFound bool) {
+	status := p.RequestMap[pageId]
+	p.Stats.RecordCompletion(status.Timestamp)
+	p.History.Return(time.Now(), status.OpId, p.Id, status.Message.Type, pageId, p.Cache[pageId].Data, notFound)
+	p.RequestMap[pageId] = RequestStatus{State: IDLE}
+}
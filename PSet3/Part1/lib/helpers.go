@@ -6,7 +6,7 @@ func (c *CentralManager) log(args ...interface{}) {
 	debug := args[0].(bool)
 	mainString := args[1].(string)
 	if (c.Debug && debug) || !debug {
-		fmt.Printf("CM: %v\n", fmt.Sprintf(mainString, args[2:]...))
+		fmt.Printf("CM %v: %v\n", c.Id, fmt.Sprintf(mainString, args[2:]...))
 	}
 }
 
@@ -18,6 +18,14 @@ func (p *Processor) log(args ...interface{}) {
 	}
 }
 
+func (p *DynamicProcessor) log(args ...interface{}) {
+	debug := args[0].(bool)
+	mainString := args[1].(string)
+	if (p.Debug && debug) || !debug {
+		fmt.Printf("%v: %v\n", p.Id, fmt.Sprintf(mainString, args[2:]...))
+	}
+}
+
 func MessageArrayRemove(arr []Message, c int) []Message {
 	for i := 0; i < len(arr); i++ {
 		if arr[i].Sender == c {
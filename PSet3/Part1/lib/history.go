@@ -0,0 +1,98 @@
+package lib
+
+import (
+	"encoding/binary"
+	"encoding/json"
+	"os"
+	"sync"
+	"time"
+)
+
+const HISTORY_INVOKE = "invoke"
+const HISTORY_RETURN = "return"
+const HISTORY_READ = "read"
+const HISTORY_WRITE = "write"
+const HISTORY_PAGE_SIZE = 8 // a page's int is recorded as 8 big endian bytes
+
+// HistoryEvent is one line of a history file. It is the format Part2 records, so Part2's cmd/checkhistory checks Part1 runs too
+type HistoryEvent struct {
+	Kind     string // HISTORY_INVOKE or HISTORY_RETURN
+	OpId     int64  // the same on an operation's invoke and return
+	Process  int
+	Op       string // HISTORY_READ or HISTORY_WRITE
+	PageId   int
+	PageSize int    `json:",omitempty"` // write invoke
+	Value    []byte `json:",omitempty"` // write invoke: value written, read return: value read
+	NotFound bool   `json:",omitempty"` // read return: the page had never been written
+	Time     int64  // UnixNano on the processor's clock
+}
+
+// History records every read and write invocation and response as JSON lines
+type History struct {
+	mu       sync.Mutex
+	file     *os.File
+	encoder  *json.Encoder
+	nextOpId int64
+}
+
+func NewHistory(path string) (*History, error) {
+	file, err := os.Create(path)
+	if err != nil {
+		return nil, err
+	}
+	return &History{file: file, encoder: json.NewEncoder(file)}, nil
+}
+
+// Invoke records the start of a read or write (of value) at now and returns its OpId. Safe to call on a nil History
+func (h *History) Invoke(now time.Time, process int, requestType MessageType, pageId int, value int) int64 {
+	if h == nil {
+		return 0
+	}
+	event := HistoryEvent{Kind: HISTORY_INVOKE, Process: process, Op: HISTORY_READ, PageId: pageId, Time: now.UnixNano()}
+	if requestType == WRITE_REQUEST {
+		event.Op = HISTORY_WRITE
+		event.PageSize = HISTORY_PAGE_SIZE
+		event.Value = historyValue(value)
+	}
+
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	h.nextOpId++
+	event.OpId = h.nextOpId
+	h.encoder.Encode(event)
+	return event.OpId
+}
+
+// Return records the end of the operation opId at now, with the value read for a read. Safe to call on a nil History
+func (h *History) Return(now time.Time, opId int64, process int, requestType MessageType, pageId int, value int, notFound bool) {
+	if h == nil {
+		return
+	}
+	event := HistoryEvent{Kind: HISTORY_RETURN, OpId: opId, Process: process, Op: HISTORY_WRITE, PageId: pageId, Time: now.UnixNano()}
+	if requestType == READ_REQUEST {
+		event.Op = HISTORY_READ
+		event.NotFound = notFound
+		if !notFound {
+			event.Value = historyValue(value)
+		}
+	}
+
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	h.encoder.Encode(event)
+}
+
+func (h *History) Close() error {
+	if h == nil {
+		return nil
+	}
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	return h.file.Close()
+}
+
+func historyValue(value int) []byte {
+	bytes := make([]byte, HISTORY_PAGE_SIZE)
+	binary.BigEndian.PutUint64(bytes, uint64(value))
+	return bytes
+}
@@ -1,24 +1,28 @@
 package lib
 
 import (
+	"distsys/common/directory"
 	"math/rand"
 	"time"
 )
 
 type Processor struct {
-	Id                 int
-	CMRequestChan      chan Message
-	CMConfirmationChan chan Message
-	Channels           []chan Message
-	NumOfVariables     int
-	RequestMap         map[int]RequestStatus
-	Cache              map[int]struct { // {pageId: {isOwner, isValid, Data}}
+	Id                  int
+	CMRequestChans      []chan Message      // one per central manager
+	CMConfirmationChans []chan Message      // one per central manager
+	Directory           directory.Directory // which central manager each page's messages go to
+	Channels            []chan Message
+	NumOfVariables      int
+	RequestMap          map[int]RequestStatus
+	Cache               map[int]struct { // {pageId: {isOwner, isValid, Data}}
 		IsOwner bool
 		IsValid bool
 		Data    int
 	}
 	Debug      bool
 	TimeoutDur int
+	Stats      *Stats   // nil if completed requests aren't counted
+	History    *History // nil if no history is recorded
 }
 
 func (p *Processor) Start() {
@@ -61,8 +65,8 @@ func (p *Processor) HandleMessage(m Message) {
 			IsValid bool
 			Data    int
 		}{IsOwner: false, IsValid: true, Data: m.Content}
-		p.RequestMap[m.PageId] = RequestStatus{State: IDLE}
-		p.CMConfirmationChan <- Message{Sender: p.Id, Type: READ_CONFIRMATION, PageId: m.PageId}
+		p.completeRequest(m.PageId, false)
+		p.CMConfirmationChans[p.Directory.ManagerOf(m.PageId)] <- Message{Sender: p.Id, Type: READ_CONFIRMATION, PageId: m.PageId}
 	case INVALIDATE_COPY:
 		// update cache map
 		p.Cache[m.PageId] = struct {
@@ -70,31 +74,27 @@ func (p *Processor) HandleMessage(m Message) {
 			IsValid bool
 			Data    int
 		}{IsOwner: false, IsValid: false}
-		p.CMConfirmationChan <- Message{Sender: p.Id, Type: INVALIDATE_CONFIRMATION, PageId: m.PageId}
+		p.CMConfirmationChans[p.Directory.ManagerOf(m.PageId)] <- Message{Sender: p.Id, Type: INVALIDATE_CONFIRMATION, PageId: m.PageId}
 		p.log(false, "cache for pageId %v invalidated", m.PageId)
 	case PAGE_TO_WRITE:
 		// write to variable and send confirmation to CM
-		p.RequestMap[m.PageId] = RequestStatus{State: IDLE}
-		p.CMConfirmationChan <- Message{Sender: p.Id, Type: WRITE_CONFIRMATION, PageId: m.PageId}
+		p.completeRequest(m.PageId, false)
+		p.CMConfirmationChans[p.Directory.ManagerOf(m.PageId)] <- Message{Sender: p.Id, Type: WRITE_CONFIRMATION, PageId: m.PageId}
 	case PAGE_NOT_FOUND:
 		p.log(true, "%v received, resetting request status to idle", MESSAGE_TYPES[PAGE_NOT_FOUND])
-		p.RequestMap[m.PageId] = RequestStatus{State: IDLE}
+		p.completeRequest(m.PageId, true)
 	}
 }
 
 func (p *Processor) SendRandomRequest() {
-	pageId := rand.Intn(p.NumOfVariables) // choose random page to read or write to
-	readOrWrite := rand.Intn(2)           // randomly read or write
-
-	if p.RequestMap[pageId].State != IDLE || //don't make any request if pending reply
-		p.Cache[pageId].IsOwner || //don't make any READ or WRITE request if owner
-		(readOrWrite == 0 && p.Cache[pageId].IsValid) { //don't make READ request if cache is valid
+	pageId, requestType, ok := RandomRequest(p.NumOfVariables, p.RequestMap, p.Cache)
+	if !ok {
 		return
 	}
 
 	requestState := PENDING_READ_COMPLETION
-	// if readOrWrite == 1 => write operation => set self to owner
-	if readOrWrite == 1 {
+	// if requestType == WRITE_REQUEST => write operation => set self to owner
+	if requestType == WRITE_REQUEST {
 		p.Cache[pageId] = struct {
 			IsOwner bool
 			IsValid bool
@@ -105,12 +105,36 @@ func (p *Processor) SendRandomRequest() {
 
 	request := Message{ // make request
 		Sender:  p.Id,
-		Type:    MessageType(readOrWrite), // 0 = READ_REQUEST, 1 = WRITE_REQUEST
+		Type:    requestType,
 		PageId:  pageId,
 		Content: p.Id,
 	}
-	p.CMRequestChan <- request //send request
+	p.CMRequestChans[p.Directory.ManagerOf(pageId)] <- request //send request to the page's manager
+
+	now := time.Now()
+	p.RequestMap[pageId] = RequestStatus{Timestamp: now.UnixNano(), State: requestState, Message: request,
+		OpId: p.History.Invoke(now, p.Id, requestType, pageId, p.Id)}
+	p.log(false, "%v request (%v) sent for page Id %v", MESSAGE_TYPES[requestType], requestType, pageId)
+}
+
+// RandomRequest is the workload every processor runs: a READ or WRITE of a random page.
+// ok is false if the processor should skip this tick
+func RandomRequest(numOfVariables int, requestMap map[int]RequestStatus, cache map[int]CacheEntry) (int, MessageType, bool) {
+	pageId := rand.Intn(numOfVariables) // choose random page to read or write to
+	readOrWrite := rand.Intn(2)         // randomly read or write
+
+	if requestMap[pageId].State != IDLE || //don't make any request if pending reply
+		cache[pageId].IsOwner || //don't make any READ or WRITE request if owner
+		(readOrWrite == 0 && cache[pageId].IsValid) { //don't make READ request if cache is valid
+		return 0, 0, false
+	}
+	return pageId, MessageType(readOrWrite), true // 0 = READ_REQUEST, 1 = WRITE_REQUEST
+}
 
-	p.RequestMap[pageId] = RequestStatus{Timestamp: time.Now().UnixNano(), State: requestState, Message: request}
-	p.log(false, "%v request (%v) sent for page Id %v", MESSAGE_TYPES[readOrWrite], MessageType(readOrWrite), pageId)
+// completeRequest records the end of the pending request for pageId, a read returns what is now in the cache
+func (p *Processor) completeRequest(pageId int, notFound bool) {
+	status := p.RequestMap[pageId]
+	p.Stats.RecordCompletion(status.Timestamp)
+	p.History.Return(time.Now(), status.OpId, p.Id, status.Message.Type, pageId, p.Cache[pageId].Data, notFound)
+	p.RequestMap[pageId] = RequestStatus{State: IDLE}
 }
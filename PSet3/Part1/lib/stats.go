@@ -0,0 +1,45 @@
+package lib
+
+import (
+	"fmt"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// Stats counts the requests completed by every processor, so the central and dynamic managers can be compared
+type Stats struct {
+	Target int64         // completed requests before Done is closed
+	Done   chan struct{} // closed once Target requests have completed
+
+	start        time.Time
+	completed    int64
+	totalLatency int64 // nanoseconds
+	doneOnce     sync.Once
+}
+
+func NewStats(target int64) *Stats {
+	return &Stats{Target: target, Done: make(chan struct{}), start: time.Now()}
+}
+
+// RecordCompletion counts one completed request that was sent at sentAt (UnixNano). Safe to call on a nil Stats
+func (s *Stats) RecordCompletion(sentAt int64) {
+	if s == nil || sentAt == 0 {
+		return
+	}
+	atomic.AddInt64(&s.totalLatency, time.Now().UnixNano()-sentAt)
+	if atomic.AddInt64(&s.completed, 1) == s.Target {
+		s.doneOnce.Do(func() { close(s.Done) })
+	}
+}
+
+func (s *Stats) String() string {
+	completed := atomic.LoadInt64(&s.completed)
+	if completed == 0 {
+		return "no requests completed"
+	}
+	elapsed := time.Since(s.start)
+	averageLatency := time.Duration(atomic.LoadInt64(&s.totalLatency) / completed)
+	return fmt.Sprintf("%v requests completed -- Time Elapsed: %v ms, Average Latency: %.3f ms",
+		completed, elapsed.Milliseconds(), float64(averageLatency)/float64(time.Millisecond))
+}
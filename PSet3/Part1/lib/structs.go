@@ -4,6 +4,7 @@ type RequestStatus struct {
 	Timestamp int64
 	State     RequestState
 	Message   Message
+	OpId      int64 // the request's operation in the History
 }
 
 type RequestState int
@@ -19,6 +20,14 @@ type Message struct {
 	Type    MessageType
 	PageId  int
 	Content int
+	CopySet []int // PAGE_TO_WRITE in the dynamic distributed manager: the processors the new owner has to invalidate
+}
+
+// CacheEntry is the value type of Processor.Cache
+type CacheEntry = struct {
+	IsOwner bool
+	IsValid bool
+	Data    int
 }
 
 type MessageType int
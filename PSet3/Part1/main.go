@@ -1,7 +1,11 @@
 package main
 
 import (
+	"distsys/common/directory"
+	"flag"
 	"fmt"
+	"os"
+	"time"
 
 	lib "main/lib"
 )
@@ -10,36 +14,100 @@ const NUM_OF_VARIABLES = 4
 const NUM_OF_PROCESSORS = 10
 const TIMEOUT_DURATION = 5
 const TOTAL_CM_MESSAGES = 10000
+const RING_POINTS_PER_MANAGER = 100
+const TOTAL_REQUESTS = 2000
 
 func main() {
-	cmIncomingChan := make(chan lib.Message, 10*NUM_OF_PROCESSORS)
-	cmConfirmationChan := make(chan lib.Message, 10*NUM_OF_PROCESSORS)
+	manager := flag.String("manager", "central", "page manager algorithm: central | dynamic (Li & Hudak's distributed manager)")
+	numOfManagers := flag.Int("managers", 1, "number of central managers the pages are sharded across")
+	directoryType := flag.String("directory", "modulo", "how pages are assigned to managers: modulo | hash")
+	totalRequests := flag.Int64("requests", TOTAL_REQUESTS, "print the time taken once this many requests have completed")
+	historyPath := flag.String("history", "", "record every read and write to this file, check it with Part2's cmd/checkhistory")
+	flag.Parse()
+
+	if *numOfManagers < 1 {
+		fmt.Printf("-managers must be at least 1, not %v\n", *numOfManagers)
+		os.Exit(1)
+	}
+
 	processorChannels := make([]chan lib.Message, NUM_OF_PROCESSORS)
 	for i := 0; i < NUM_OF_PROCESSORS; i++ {
 		processorChannels[i] = make(chan lib.Message, 10*NUM_OF_PROCESSORS)
 	}
-	cm := lib.CentralManager{
-		Debug:               false,
-		Incoming:            cmIncomingChan,
-		ConfirmationChan:    cmConfirmationChan,
-		InvalidationCounter: map[int]int{},
-		Entries:             map[int]lib.CMEntry{},
-		RequestMap: map[int]struct {
-			Status lib.RequestStatus
-			Queue  []lib.Message
-		}{},
-		PChannels:        processorChannels,
-		CountDownToDeath: TOTAL_CM_MESSAGES,
-	}
-	go cm.Start()
+
+	stats := lib.NewStats(*totalRequests)
+	go func() {
+		<-stats.Done
+		fmt.Printf("%v manager: %v\n", *manager, stats)
+	}()
+
+	var history *lib.History
+	if *historyPath != "" {
+		var err error
+		history, err = lib.NewHistory(*historyPath)
+		if err != nil {
+			fmt.Printf("could not create history: %v\n", err)
+			os.Exit(1)
+		}
+		defer history.Close()
+	}
+
+	switch *manager {
+	case "central":
+		runCentral(*numOfManagers, *directoryType, processorChannels, stats, history)
+	case "dynamic":
+		runDynamic(processorChannels, stats, history)
+	default:
+		fmt.Printf("unknown manager %q\n", *manager)
+		os.Exit(1)
+	}
+	fmt.Scanln()
+}
+
+func runCentral(numOfManagers int, directoryType string, processorChannels []chan lib.Message, stats *lib.Stats, history *lib.History) {
+	var pageDirectory directory.Directory
+	switch directoryType {
+	case "modulo":
+		pageDirectory = directory.Modulo{Managers: numOfManagers}
+	case "hash":
+		pageDirectory = directory.NewConsistentHash(numOfManagers, RING_POINTS_PER_MANAGER)
+	default:
+		fmt.Printf("unknown directory %q\n", directoryType)
+		os.Exit(1)
+	}
+
+	// every manager holds one shard of the page directory, together they still handle TOTAL_CM_MESSAGES
+	cmIncomingChans := make([]chan lib.Message, numOfManagers)
+	cmConfirmationChans := make([]chan lib.Message, numOfManagers)
+	for i := 0; i < numOfManagers; i++ {
+		cmIncomingChans[i] = make(chan lib.Message, 10*NUM_OF_PROCESSORS)
+		cmConfirmationChans[i] = make(chan lib.Message, 10*NUM_OF_PROCESSORS)
+		cm := lib.CentralManager{
+			Id:                  i,
+			Debug:               false,
+			Incoming:            cmIncomingChans[i],
+			ConfirmationChan:    cmConfirmationChans[i],
+			InvalidationCounter: map[int]int{},
+			Entries:             map[int]lib.CMEntry{},
+			RequestMap: map[int]struct {
+				Status lib.RequestStatus
+				Queue  []lib.Message
+			}{},
+			PChannels:        processorChannels,
+			CountDownToDeath: TOTAL_CM_MESSAGES / numOfManagers,
+		}
+		go cm.Start()
+	}
+
 	for i := 0; i < NUM_OF_PROCESSORS; i++ {
 		p := lib.Processor{
-			Id:                 i,
-			CMRequestChan:      cmIncomingChan,
-			CMConfirmationChan: cmConfirmationChan,
-			Channels:           processorChannels,
-			NumOfVariables:     NUM_OF_VARIABLES,
-			RequestMap:         map[int]lib.RequestStatus{},
+			Id:                  i,
+			CMRequestChans:      cmIncomingChans,
+			CMConfirmationChans: cmConfirmationChans,
+			Directory:           pageDirectory,
+			Channels:            processorChannels,
+			NumOfVariables:      NUM_OF_VARIABLES,
+			RequestMap:          map[int]lib.RequestStatus{},
 			Cache: map[int]struct {
 				IsOwner bool
 				IsValid bool
@@ -47,8 +115,40 @@ func main() {
 			}{},
 			Debug:      false,
 			TimeoutDur: TIMEOUT_DURATION,
+			Stats:      stats,
+			History:    history,
+		}
+		go p.Start()
+	}
+}
+
+func runDynamic(processorChannels []chan lib.Message, stats *lib.Stats, history *lib.History) {
+	for i := 0; i < NUM_OF_PROCESSORS; i++ {
+		p := lib.DynamicProcessor{
+			Id:             i,
+			Channels:       processorChannels,
+			NumOfVariables: NUM_OF_VARIABLES,
+			RequestMap:     map[int]lib.RequestStatus{},
+			Cache:          map[int]lib.CacheEntry{},
+			ProbOwner:      map[int]int{},
+			CopySet:        map[int][]int{},
+			Deferred:       map[int][]lib.Message{},
+			Invalidations:  map[int]int{},
+			InitialOwner:   0,
+			Debug:          false,
+			Stats:          stats,
+			History:        history,
+		}
+		if i == p.InitialOwner {
+			// processor 0 starts out owning every page
+			for pageId := 0; pageId < NUM_OF_VARIABLES; pageId++ {
+				p.Cache[pageId] = lib.CacheEntry{IsOwner: true, IsValid: true, Data: i}
+				// recorded as a write so the first reads of the page can be explained
+				now := time.Now()
+				opId := history.Invoke(now, i, lib.WRITE_REQUEST, pageId, i)
+				history.Return(now, opId, i, lib.WRITE_REQUEST, pageId, i, false)
+			}
 		}
 		go p.Start()
 	}
-	fmt.Scanln()
 }
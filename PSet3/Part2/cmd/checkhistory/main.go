@@ -0,0 +1,39 @@
+package main
+
+import (
+	"fmt"
+	"os"
+
+	lib "main/lib"
+)
+
+// Checks that the reads and writes recorded with -history are sequentially consistent, page by page.
+// Several files (one per processor process) can be given at once:
+//
+//	go run ./cmd/checkhistory history.jsonl
+func main() {
+	if len(os.Args) < 2 {
+		fmt.Println("usage: checkhistory <history file>...")
+		os.Exit(2)
+	}
+
+	events := []lib.HistoryEvent{}
+	for _, path := range os.Args[1:] {
+		fileEvents, err := lib.LoadHistory(path)
+		if err != nil {
+			fmt.Printf("could not load %v: %v\n", path, err)
+			os.Exit(2)
+		}
+		events = append(events, fileEvents...)
+	}
+
+	pages := lib.PairOperations(events)
+	violations := lib.CheckHistory(events)
+	for _, violation := range violations {
+		fmt.Println(violation)
+	}
+	if len(violations) > 0 {
+		os.Exit(1)
+	}
+	fmt.Printf("%v events on %v pages are sequentially consistent\n", len(events), len(pages))
+}
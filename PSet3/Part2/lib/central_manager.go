@@ -1,28 +1,36 @@
 package lib
 
 import (
-	"encoding/json"
+	"distsys/common/sim"
+	"distsys/common/trace"
 	"time"
 )
 
 type CentralManager struct {
-	Id                          int
-	IsPrimary                   bool
-	PChannels                   []chan Message
-	CentralManagersIncoming     []chan Message
-	CentralManagersConfirmation []chan Message
-	Incoming                    chan Message
-	ConfirmationChan            chan Message
-	CurrentState                State //to track all changes for the secondary replica to take over
-	Debug                       bool
-	IsAlive                     bool
-	CountDownToDeath            int
-	FinalCountDownToDeath       int
-	Die                         chan int
-	ReallyDie                   chan int
+	Id                    int
+	IsPrimary             bool  // this CM is the Raft leader
+	Peers                 []int // the CMs in this CM's Raft group including itself, nil for every CM
+	Transport             Transport
+	CurrentState          State //to track all changes for the secondary replica to take over
+	Debug                 bool
+	IsAlive               bool
+	CountDownToDeath      int
+	FinalCountDownToDeath int
+	Runtime               sim.Runtime    // time, timers and randomness, Start uses the real ones if nil
+	Tracer                *trace.Tracer  // nil if the CM isn't traced, its Transport records the sends
+	Metrics               *Metrics       // nil if nothing is measured
+	WAL                   *WriteAheadLog // nil keeps the state in memory only
+	Raft                  Raft
+	PendingOps            []StateOp // leader only: ops made while handling the current message, see ForwardState
+
+	pendingOutbox []outboxMessage // leader only: messages to processors made while handling the current message
+	replicaOutbox []chan Message
+	startTime     time.Time
+	reallyDead    bool
+	countDown     int // what CountDownToDeath starts at again after a ressurection
 }
 
-// State would be sent to the secondary replica everytime it updates its state
+// State is what the CMs replicate through the Raft log
 type State struct {
 	Entries             map[int]CMEntry  // {[pageId]: {CopyArray, Data}}
 	InvalidationCounter map[int]int      // {[pageId]: {number of confirmations}}
@@ -30,6 +38,7 @@ type State struct {
 		Status RequestStatus
 		Queue  []Message
 	}
+	Seq int64 // number of StateOps applied so far
 }
 
 type CMEntry struct {
@@ -38,63 +47,116 @@ type CMEntry struct {
 }
 
 func (cm *CentralManager) Start() {
-	cm.log(true, "starting %v", "test")
-	cm.Die = make(chan int, 20)
-	cm.ReallyDie = make(chan int, 20)
-	startTime := time.Now().UnixNano()
+	if cm.Runtime == nil {
+		cm.Runtime = sim.NewRealRuntime()
+	}
+	cm.Init()
 
-	// ticker to make regular requests
-	for {
+	for !cm.reallyDead {
 		select {
-		case <-cm.ReallyDie:
-			cm.log(false, "DIED -- Time Elapsed: %v ms", float32((time.Now().UnixNano()-startTime)/int64(time.Millisecond)))
-			return
-		case <-cm.Die:
-			cm.IsAlive = false
-			cm.log(false, "dead, ressurecting in 6 seconds")
-			go cm.Ressurect()
-
-		case m := <-cm.Incoming:
-			if !cm.IsAlive {
-				break
-			}
-			cm.EnqueueRequest(m)
-			cm.ForwardState()
+		case f := <-sim.EventsOf(cm.Runtime):
+			// raft ticks and ressurection
+			f()
 
-		case m := <-cm.ConfirmationChan:
-			if !cm.IsAlive {
-				break
-			}
-			cm.log(true, "%v message (%v) from %v for pageId %v", m.Type.toString(), m.Type, m.Sender, m.PageId)
-			cm.HandleMessage(m)
-			cm.ForwardState()
+		case m := <-cm.Transport.CMInbox(cm.Id):
+			cm.HandleRequest(m)
 
-		default:
-			if !cm.IsPrimary || cm.IsAlive {
-				break
-			}
+		case m := <-cm.Transport.CMConfirmationInbox(cm.Id):
+			cm.HandleConfirmation(m)
 
-			pageId := cm.LongestQueue()
-			if pageId == -1 {
-				// cm.log(false, "breaking cus no available req")
-				break
-			}
-			cm.log(true, "pageId: %v, requestMap: %v", pageId, cm.CurrentState.RequestMap[pageId].Queue)
-			queuedMessage := cm.CurrentState.RequestMap[pageId].Queue[0]
-			cm.HandleMessage(queuedMessage)
-			cm.ForwardState()
+		default:
+			cm.ServeQueue()
 		}
 	}
 }
 
+// Init starts the CM's Raft timers. Start calls it, a simulation calls it instead of Start
+func (cm *CentralManager) Init() {
+	cm.log(true, "starting %v", "test")
+	cm.startTime = cm.Runtime.Now()
+	cm.countDown = cm.CountDownToDeath
+	cm.StartRaft(cm.Runtime.Now())
+	cm.Runtime.After(RAFT_TICK, cm.HandleTick)
+}
+
+func (cm *CentralManager) HandleTick() {
+	cm.Runtime.After(RAFT_TICK, cm.HandleTick)
+	if !cm.IsAlive {
+		return
+	}
+	cm.Tick(cm.Runtime.Now())
+	// the tick may have made this CM a leader with queued requests
+	cm.ServeQueues()
+}
+
+// HandleRequest queues a READ_REQUEST or WRITE_REQUEST, or points the processor at the leader
+func (cm *CentralManager) HandleRequest(m Message) {
+	if !cm.IsAlive {
+		return
+	}
+	cm.Tracer.Receive(m.Trace, m.TraceInfo())
+	if !cm.IsPrimary || !cm.Raft.Ready {
+		cm.RedirectRequest(m)
+		return
+	}
+	cm.EnqueueRequest(m)
+	cm.ForwardState()
+}
+
+// HandleConfirmation handles every message other than a request straight away
+func (cm *CentralManager) HandleConfirmation(m Message) {
+	if !cm.IsAlive {
+		return
+	}
+	cm.log(true, "%v message (%v) from %v for pageId %v", m.Type.toString(), m.Type, m.Sender, m.PageId)
+	cm.Tracer.Receive(m.Trace, m.TraceInfo())
+	cm.HandleMessage(m)
+	cm.ForwardState()
+}
+
+// ServeQueue starts on the first queued request of the longest queue whose page is IDLE, false if there is none
+func (cm *CentralManager) ServeQueue() bool {
+	if !cm.IsPrimary || !cm.IsAlive || !cm.Raft.Ready {
+		return false
+	}
+
+	pageId := cm.LongestQueue()
+	if pageId == -1 {
+		// cm.log(false, "breaking cus no available req")
+		return false
+	}
+	cm.log(true, "pageId: %v, requestMap: %v", pageId, cm.CurrentState.RequestMap[pageId].Queue)
+	queuedMessage := cm.CurrentState.RequestMap[pageId].Queue[0]
+	// the rest of the request is in the trace of the processor that made it
+	cm.Tracer.Resume("serve "+queuedMessage.Type.toString(), pageId, queuedMessage.Trace)
+	cm.HandleMessage(queuedMessage)
+	cm.ForwardState()
+	return true
+}
+
+// ServeQueues serves queued requests until every page with a queue is busy
+func (cm *CentralManager) ServeQueues() {
+	for cm.ServeQueue() {
+	}
+}
+
+func (cm *CentralManager) RedirectRequest(m Message) {
+	/**
+	Only the leader handles requests. Point the processor at the leader if this CM knows who it is,
+	else drop the request - the processor times out and tries the next CM
+	*/
+	if cm.Raft.LeaderId == -1 || cm.Raft.LeaderId == cm.Id {
+		cm.log(true, "no leader known, dropping %v from %v", m.Type.toString(), m.Sender)
+		return
+	}
+	cm.Transport.SendToProcessor(m.Sender, Message{Sender: cm.Raft.LeaderId, Type: ANNOUNCE_PRIMARY, Term: cm.Raft.CurrentTerm})
+}
+
 func (cm *CentralManager) EnqueueRequest(m Message) {
 	// Enqueues any message into the request map
 	pageStatus, ok := cm.CurrentState.RequestMap[m.PageId]
 	if !ok {
-		cm.CurrentState.RequestMap[m.PageId] = struct {
-			Status RequestStatus
-			Queue  []Message
-		}{Status: RequestStatus{State: IDLE}, Queue: []Message{m}}
+		cm.SetRequest(m.PageId, PageRequests{Status: RequestStatus{State: IDLE}, Queue: []Message{m}})
 		return
 	}
 
@@ -105,7 +167,7 @@ func (cm *CentralManager) EnqueueRequest(m Message) {
 	}
 
 	pageStatus.Queue = append(pageStatus.Queue, m)
-	cm.CurrentState.RequestMap[m.PageId] = pageStatus
+	cm.SetRequest(m.PageId, pageStatus)
 	cm.log(true, "queued %v's request for pageId: %v. requestMap is %v", m.Sender, m.PageId, cm.CurrentState.RequestMap)
 }
 
@@ -114,13 +176,33 @@ func (cm *CentralManager) HandleMessage(m Message) {
 	cm.log(true, "entry map when %v received is %v", m.Type.toString(), cm.CurrentState.Entries)
 	cm.log(true, "%v message (%v) from %v for pageId %v", m.Type.toString(), m.Type, m.Sender, m.PageId)
 
-	if cm.IsPrimary {
-		if cm.CountDownToDeath--; cm.CountDownToDeath <= 0 {
-			cm.Die <- 1
-		}
-		if cm.FinalCountDownToDeath--; cm.FinalCountDownToDeath <= 0 {
-			cm.ReallyDie <- 1
-		}
+	// Raft traffic between CMs doesn't count towards the leader's death
+	switch m.Type {
+	case REQUEST_VOTE, VOTE_REPLY, APPEND_ENTRIES, APPEND_REPLY, FORWARD_STATE:
+		cm.HandleRaftMessage(m, cm.Runtime.Now())
+		return
+	}
+
+	if m.Term > cm.Raft.CurrentTerm {
+		// the processor has heard from a newer leader, so this CM can't be the leader any more
+		cm.StepDown(m.Term, cm.Runtime.Now())
+	}
+	if m.Term != 0 && m.Term < cm.Raft.CurrentTerm && m.Type != READ_REQUEST && m.Type != WRITE_REQUEST {
+		// confirms an instruction from an older leader, this leader redoes the request it belongs to
+		cm.log(true, "dropping %v from %v for old term %v", m.Type.toString(), m.Sender, m.Term)
+		return
+	}
+	if !cm.IsPrimary || !cm.Raft.Ready {
+		// a confirmation sent to an old leader, the new leader redoes the request it belongs to
+		cm.log(true, "not the leader, dropping %v from %v", m.Type.toString(), m.Sender)
+		return
+	}
+
+	if cm.CountDownToDeath--; cm.CountDownToDeath <= 0 {
+		cm.Die()
+	}
+	if cm.FinalCountDownToDeath--; cm.FinalCountDownToDeath <= 0 {
+		cm.ReallyDie()
 	}
 
 	switch m.Type {
@@ -134,14 +216,6 @@ func (cm *CentralManager) HandleMessage(m Message) {
 		cm.HandleWriteConfirmation(m)
 	case INVALIDATE_CONFIRMATION:
 		cm.HandleInvalidateConfirmation(m)
-	case FORWARD_STATE:
-		cm.HandleForwardState(m)
-	case ELECT:
-		cm.HandleElect(m)
-	case ANNOUNCE_PRIMARY:
-		cm.HandleAnnouncePrimary(m)
-	case CHECK_ALIVE:
-		cm.PChannels[m.Sender] <- Message{Sender: cm.Id, Type: ACKNOWLEDGE}
 	}
 }
 
@@ -151,6 +225,9 @@ func (cm *CentralManager) HandleReadConfirmation(m Message) {
 	2. Adds sender of READ_CONFIRMATION to CopyArray to track who has a copy of the info
 	3. Remove request from the queue, and update request state for that given pageId to IDLE.
 	*/
+	if !cm.IsAwaitedConfirmation(m, PENDING_READ_COMPLETION) {
+		return
+	}
 
 	cmEntry, ok := cm.CurrentState.Entries[m.PageId]
 	if !ok {
@@ -159,17 +236,20 @@ func (cm *CentralManager) HandleReadConfirmation(m Message) {
 	}
 	// update copy array
 	cmEntry.CopyArray = append(cmEntry.CopyArray, m.Sender)
-	cm.CurrentState.Entries[m.PageId] = cmEntry
+	cm.SetEntry(m.PageId, cmEntry)
 	cm.log(false, "updated entry map %v ", cm.CurrentState.Entries)
 
 	// set pageId request to IDLE
 	request, ok := cm.CurrentState.RequestMap[m.PageId]
 	request.Queue = request.Queue[1:]
 	request.Status = RequestStatus{State: IDLE}
-	cm.CurrentState.RequestMap[m.PageId] = request
+	cm.SetRequest(m.PageId, request)
 }
 
 func (cm *CentralManager) HandleWriteConfirmation(m Message) {
+	if !cm.IsAwaitedConfirmation(m, PENDING_WRITE_COMPLETION) {
+		return
+	}
 	// add to page map
 	cmEntry, ok := cm.CurrentState.Entries[m.PageId]
 	if !ok {
@@ -178,17 +258,27 @@ func (cm *CentralManager) HandleWriteConfirmation(m Message) {
 	}
 	// update owner
 	cmEntry.Owner = m.Sender
-	cm.CurrentState.Entries[m.PageId] = cmEntry
+	cm.SetEntry(m.PageId, cmEntry)
 	//update request status for that pageId
 	request, ok := cm.CurrentState.RequestMap[m.PageId]
 	cm.log(false, "request map before removing %v: %v", m.Sender, cm.CurrentState.RequestMap)
 	request.Queue = request.Queue[1:]
 	request.Status = RequestStatus{State: IDLE}
-	cm.CurrentState.RequestMap[m.PageId] = request
+	cm.SetRequest(m.PageId, request)
 	cm.log(false, "request map: %v", cm.CurrentState.RequestMap)
 	cm.log(false, "updated entries map to %v", cm.CurrentState.Entries)
 }
 
+// IsAwaitedConfirmation is false for confirmations sent twice, which happens when a new leader redoes a request that had already completed
+func (cm *CentralManager) IsAwaitedConfirmation(m Message, state RequestState) bool {
+	request := cm.CurrentState.RequestMap[m.PageId]
+	if request.Status.State != state || len(request.Queue) == 0 || request.Queue[0].Sender != m.Sender {
+		cm.log(true, "dropping unexpected %v from %v", m.Type.toString(), m.Sender)
+		return false
+	}
+	return true
+}
+
 func (cm *CentralManager) HandleReadReqeuest(m Message) {
 	pageStatus, ok := cm.CurrentState.RequestMap[m.PageId]
 	if ok {
@@ -203,14 +293,14 @@ func (cm *CentralManager) HandleReadReqeuest(m Message) {
 		cm.log(false, "error, pagedId %v not found", m.PageId)
 		request, _ := cm.CurrentState.RequestMap[m.PageId]
 		request.Queue = request.Queue[1:]
-		cm.CurrentState.RequestMap[m.PageId] = request
-		cm.PChannels[m.Sender] <- Message{Type: PAGE_NOT_FOUND, PageId: m.PageId}
+		cm.SetRequest(m.PageId, request)
+		cm.sendToProcessor(m.Sender, Message{Type: PAGE_NOT_FOUND, PageId: m.PageId})
 		return
 	}
 	pageStatus.Status = RequestStatus{State: PENDING_READ_COMPLETION}
-	cm.CurrentState.RequestMap[m.PageId] = pageStatus
+	cm.SetRequest(m.PageId, pageStatus)
 	//send read forward or error
-	cm.PChannels[cmEntry.Owner] <- Message{Sender: m.Sender, Type: READ_FORWARD, PageId: m.PageId} //send the WRITE_FORWARD request to owner
+	cm.sendToProcessor(cmEntry.Owner, Message{Sender: m.Sender, Type: READ_FORWARD, PageId: m.PageId}) //send the WRITE_FORWARD request to owner
 }
 
 func (cm *CentralManager) HandleWriteRequest(m Message) {
@@ -218,11 +308,12 @@ func (cm *CentralManager) HandleWriteRequest(m Message) {
 	pageStatus, _ := cm.CurrentState.RequestMap[m.PageId]
 
 	pageStatus.Status = RequestStatus{State: PENDING_WRITE_COMPLETION}
-	cm.CurrentState.RequestMap[m.PageId] = pageStatus
+	cm.SetRequest(m.PageId, pageStatus)
 	//entry doesn't exist? send write
 	if _, ok := cm.CurrentState.Entries[m.PageId]; !ok {
-		cm.CurrentState.Entries[m.PageId] = CMEntry{CopyArray: []int{}, Owner: m.Sender} //set new owner
-		cm.PChannels[m.Sender] <- Message{Type: PAGE_TO_WRITE, PageId: m.PageId}         //send the pageVariable to alow the write
+		cm.Metrics.Invalidations(cm.Id, 0)
+		cm.SetEntry(m.PageId, CMEntry{CopyArray: []int{}, Owner: m.Sender})          //set new owner
+		cm.sendToProcessor(m.Sender, Message{Type: PAGE_TO_WRITE, PageId: m.PageId}) //send the pageVariable to alow the write
 		cm.log(true, "page variable sent to %v", m.Sender)
 		return
 	}
@@ -231,49 +322,54 @@ func (cm *CentralManager) HandleWriteRequest(m Message) {
 
 	if len(cmEntry.CopyArray) != 0 {
 		//send invalidate copies
-		cm.CurrentState.InvalidationCounter[m.PageId] = len(cmEntry.CopyArray)
+		cm.SetInvalidationCounter(m.PageId, len(cmEntry.CopyArray))
+		invalidations := 0
 		for i := 0; i < len(cmEntry.CopyArray); i++ {
 			if cmEntry.CopyArray[i] == m.Sender {
 				//don't invalidate the requester
-				cm.ConfirmationChan <- Message{Type: INVALIDATE_CONFIRMATION, PageId: m.PageId}
+				cm.Transport.SendConfirmationToCM(cm.Id, Message{Type: INVALIDATE_CONFIRMATION, PageId: m.PageId, Term: cm.Raft.CurrentTerm})
 				continue
 			}
-			go func(copyHolder int, channels []chan Message) {
-				channels[cmEntry.CopyArray[copyHolder]] <- Message{Type: INVALIDATE_COPY, PageId: m.PageId}
-			}(i, cm.PChannels)
+			cm.sendToProcessor(cmEntry.CopyArray[i], Message{Type: INVALIDATE_COPY, PageId: m.PageId})
+			invalidations++
 		}
+		cm.Metrics.Invalidations(cm.Id, invalidations)
 		return
 	}
 
 	// If no copies to invalidate, send the write forward message
-	cm.PChannels[cmEntry.Owner] <- Message{Sender: m.Sender, Type: WRITE_FORWARD, PageId: m.PageId} //send the WRITE_FORWARD request to owner
+	cm.Metrics.Invalidations(cm.Id, 0)
+	cm.sendToProcessor(cmEntry.Owner, Message{Sender: m.Sender, Type: WRITE_FORWARD, PageId: m.PageId}) //send the WRITE_FORWARD request to owner
 }
 
 func (cm *CentralManager) HandleInvalidateConfirmation(m Message) {
 	//do nothing if waiting for more confirmation, else send write forward
-	cm.CurrentState.InvalidationCounter[m.PageId]--
+	cm.SetInvalidationCounter(m.PageId, cm.CurrentState.InvalidationCounter[m.PageId]-1)
 	if cm.CurrentState.InvalidationCounter[m.PageId] != 0 {
 		return
 	}
 	cmEntry := cm.CurrentState.Entries[m.PageId]
-	cm.PChannels[cmEntry.Owner] <- Message{
+	cm.sendToProcessor(cmEntry.Owner, Message{
 		Sender: cm.CurrentState.RequestMap[m.PageId].Queue[0].Sender,
 		Type:   WRITE_FORWARD,
-		PageId: m.PageId} //send the WRITE_FORWARD request to owner
+		PageId: m.PageId}) //send the WRITE_FORWARD request to owner
 
 	cmEntry.CopyArray = []int{}
-	cm.CurrentState.Entries[m.PageId] = cmEntry
+	cm.SetEntry(m.PageId, cmEntry)
 	cm.log(true, "Entry map: %v after sending WRITE_FORWARD to %v", cm.CurrentState.Entries, cmEntry.Owner)
 }
 
-func (cm *CentralManager) HandleElect(m Message) {
-	//update IsPrimary and acts as per normal
+func (cm *CentralManager) HandleElect() {
+	/**
+	Called once the new leader's first entry commits, so its state has every change the old leader committed.
+	1. Tell the processors to send their requests here
+	2. Redo the requests the old leader was in the middle of
+	*/
 	cm.log(false, "elected as new primary")
-	cm.IsPrimary = true
-	for i := range cm.PChannels {
-		cm.PChannels[i] <- Message{Sender: cm.Id, Type: ANNOUNCE_PRIMARY}
+	for i := 0; i < cm.Transport.NumOfProcessors(); i++ {
+		cm.Transport.SendToProcessor(i, Message{Sender: cm.Id, Type: ANNOUNCE_PRIMARY, Term: cm.Raft.CurrentTerm})
 	}
-	for pageId := range cm.CurrentState.RequestMap {
+	for _, pageId := range sortedKeys(cm.CurrentState.RequestMap) {
 		pageStatus := cm.CurrentState.RequestMap[pageId]
 		if len(pageStatus.Queue) == 0 {
 			continue
@@ -281,53 +377,30 @@ func (cm *CentralManager) HandleElect(m Message) {
 		lastRequest := pageStatus.Queue[0]
 		if pageStatus.Status.State == PENDING_READ_COMPLETION && lastRequest.Type == READ_REQUEST {
 			pageOwner := cm.CurrentState.Entries[pageId].Owner
-			cm.PChannels[pageOwner] <- Message{Sender: lastRequest.Sender, Type: READ_FORWARD, PageId: pageId} //send the READ_FORWARD request to owner
+			cm.sendToProcessor(pageOwner, Message{Sender: lastRequest.Sender, Type: READ_FORWARD, PageId: pageId}) //send the READ_FORWARD request to owner
 		}
 		if pageStatus.Status.State == PENDING_WRITE_COMPLETION && lastRequest.Type == WRITE_REQUEST {
 			cm.HandleWriteRequest(lastRequest)
 		}
 	}
-	for i := range cm.CentralManagersConfirmation {
-		if i == cm.Id {
-			continue
-		}
-		cm.CentralManagersConfirmation[i] <- Message{Type: ANNOUNCE_PRIMARY}
-	}
-}
-
-func (cm *CentralManager) HandleForwardState(m Message) {
-	if err := json.Unmarshal(m.State, &cm.CurrentState); err != nil {
-		cm.log(false, "Unmarshal Error: %v", err)
-	}
-	cm.log(true, "currentstate set to:  %v", cm.CurrentState)
+	cm.ForwardState()
 }
 
-func (cm *CentralManager) ForwardState() {
-	stateBytes, err := json.Marshal(cm.CurrentState)
-	if err != nil {
-		cm.log(false, "Serialize Error: %v", err)
+// recordQueueLengths measures the queue of every page, after the whole state has been replaced
+func (cm *CentralManager) recordQueueLengths() {
+	if cm.Metrics == nil {
 		return
 	}
-
-	if !cm.IsPrimary {
-		// don't forward state if not primary CM
-		return
-	}
-	for i := 0; i < len(cm.CentralManagersIncoming); i++ {
-		if i == cm.Id {
-			// don't forward to self
-			continue
-		}
-		go func(n int) {
-			cm.CentralManagersConfirmation[n] <- Message{Type: FORWARD_STATE, State: stateBytes}
-		}(i)
+	for _, pageId := range sortedKeys(cm.CurrentState.RequestMap) {
+		cm.Metrics.QueueLength(cm.Id, pageId, len(cm.CurrentState.RequestMap[pageId].Queue))
 	}
 }
 
 func (cm *CentralManager) LongestQueue() int {
+	// ties go to the smallest pageId, so the choice doesn't depend on map order
 	maxSeenLength := 0
 	result := -1
-	for pageId := range cm.CurrentState.RequestMap {
+	for _, pageId := range sortedKeys(cm.CurrentState.RequestMap) {
 		resStatus := cm.CurrentState.RequestMap[pageId]
 		if resStatus.Status.State == IDLE {
 			if len(resStatus.Queue) > maxSeenLength {
@@ -339,15 +412,34 @@ func (cm *CentralManager) LongestQueue() int {
 	return result
 }
 
-func (cm *CentralManager) Ressurect() {
-	time.Sleep(5 * time.Second)
-	cm.IsAlive = true
-	cm.CountDownToDeath = 100
-	for i := 0; i < len(cm.PChannels); i++ {
-		cm.PChannels[i] <- Message{Type: START_ELECTION}
-	}
+func (cm *CentralManager) Die() {
+	cm.DieFor(5 * time.Second)
+}
+
+// DieFor stops the CM handling anything until it ressurects d later
+func (cm *CentralManager) DieFor(d time.Duration) {
+	cm.IsAlive = false
+	cm.Metrics.Leader(cm.Id, false)
+	cm.log(false, "dead, ressurecting in %v", d)
+	cm.Tracer.Local("die", trace.NO_PAGE)
+	cm.Runtime.After(d, cm.Ressurect)
+}
+
+func (cm *CentralManager) ReallyDie() {
+	cm.IsAlive = false
+	cm.reallyDead = true
+	cm.Metrics.Leader(cm.Id, false)
+	cm.Tracer.Local("really die", trace.NO_PAGE)
+	cm.log(false, "DIED -- Time Elapsed: %v ms", float32(cm.Runtime.Now().Sub(cm.startTime)/time.Millisecond))
 }
 
-func (cm *CentralManager) HandleAnnouncePrimary(m Message) {
-	cm.IsPrimary = false
+func (cm *CentralManager) Ressurect() {
+	if cm.reallyDead {
+		return
+	}
+	// comes back as a follower, it keeps its term and log like a real crash that kept its disk
+	cm.IsAlive = true
+	cm.CountDownToDeath = cm.countDown
+	cm.Tracer.Local("ressurect", trace.NO_PAGE)
+	cm.StepDown(cm.Raft.CurrentTerm, cm.Runtime.Now())
 }
@@ -0,0 +1,307 @@
+package lib
+
+import (
+	"bytes"
+	"fmt"
+	"math"
+	"sort"
+	"strings"
+)
+
+// HistoryOperation is an invoke paired with its return
+type HistoryOperation struct {
+	OpId     int64
+	Process  int
+	Op       string
+	PageId   int
+	Offset   int
+	PageSize int
+	Written  []byte // write only
+	Read     []byte // read only
+	NotFound bool   // read only
+	Invoke   int64
+	Return   int64
+	Pending  bool // never returned: a pending write may or may not have happened, a pending read is ignored
+}
+
+func (o HistoryOperation) String() string {
+	status := ""
+	if o.Pending {
+		status = " (pending)"
+	}
+	if o.Op == HISTORY_WRITE {
+		return fmt.Sprintf("op %v: processor %v write page %v offset %v %q%v", o.OpId, o.Process, o.PageId, o.Offset, o.Written, status)
+	}
+	if o.NotFound {
+		return fmt.Sprintf("op %v: processor %v read page %v -> not found%v", o.OpId, o.Process, o.PageId, status)
+	}
+	return fmt.Sprintf("op %v: processor %v read page %v -> %q%v", o.OpId, o.Process, o.PageId, bytes.TrimRight(o.Read, "\x00"), status)
+}
+
+// PageViolation is a page whose operations can't be put in any sequential order
+type PageViolation struct {
+	PageId     int
+	Operations int                // operations on the page in the whole history
+	Minimal    []HistoryOperation // a smallest subset found that still can't be ordered, removing any one of its reads can
+}
+
+func (v PageViolation) String() string {
+	lines := []string{fmt.Sprintf("page %v is not sequentially consistent, minimal violating subsequence (%v of %v operations):", v.PageId, len(v.Minimal), v.Operations)}
+	for _, o := range v.Minimal {
+		lines = append(lines, "  "+o.String())
+	}
+	return strings.Join(lines, "\n")
+}
+
+// PairOperations matches every invoke with its return and groups the operations by page, in the order they were invoked
+// by each processor (events from several files may be interleaved in any way)
+func PairOperations(events []HistoryEvent) map[int][]HistoryOperation {
+	type opKey struct {
+		Process int
+		OpId    int64
+	}
+	byId := map[opKey]*HistoryOperation{}
+	order := []opKey{}
+	for _, event := range events {
+		switch event.Kind {
+		case HISTORY_INVOKE:
+			byId[opKey{event.Process, event.OpId}] = &HistoryOperation{OpId: event.OpId, Process: event.Process, Op: event.Op, PageId: event.PageId,
+				Offset: event.Offset, PageSize: event.PageSize, Written: event.Value, Invoke: event.Time, Pending: true}
+			order = append(order, opKey{event.Process, event.OpId})
+		case HISTORY_RETURN:
+			o, ok := byId[opKey{event.Process, event.OpId}]
+			if !ok {
+				continue
+			}
+			o.Pending = false
+			o.Return = event.Time
+			o.Read = event.Value
+			o.NotFound = event.NotFound
+		}
+	}
+
+	pages := map[int][]HistoryOperation{}
+	for _, key := range order {
+		o := byId[key]
+		if o.Op == HISTORY_READ && o.Pending {
+			// a read that never returned says nothing about the page
+			continue
+		}
+		pages[o.PageId] = append(pages[o.PageId], *o)
+	}
+	return pages
+}
+
+// CheckHistory checks each page is sequentially consistent on its own
+// and returns the pages that aren't, sorted by page id
+func CheckHistory(events []HistoryEvent) []PageViolation {
+	pages := PairOperations(events)
+	pageIds := []int{}
+	for pageId := range pages {
+		pageIds = append(pageIds, pageId)
+	}
+	sort.Ints(pageIds)
+
+	violations := []PageViolation{}
+	for _, pageId := range pageIds {
+		if IsSequentiallyConsistent(pages[pageId]) {
+			continue
+		}
+		violations = append(violations, PageViolation{PageId: pageId, Operations: len(pages[pageId]), Minimal: ShrinkViolation(pages[pageId])})
+	}
+	return violations
+}
+
+// IsSequentiallyConsistent is true if the operations on one page can be put in a single order that keeps
+// every processor's own order and in which every read returns what the writes before it left in the page
+func IsSequentiallyConsistent(ops []HistoryOperation) bool {
+	/**
+	Depth first search over the interleavings of the processors' operations, like Wing & Gong's algorithm
+	but with program order instead of real time order. Remembers every (progress of each processor, page contents)
+	already tried so no interleaving is explored twice.
+	The next operation tried is always the one that returned first, so a history that is also linearizable
+	is checked without backtracking.
+	*/
+	processes := map[int][]HistoryOperation{}
+	for _, o := range ops {
+		processes[o.Process] = append(processes[o.Process], o)
+	}
+	queues := [][]HistoryOperation{}
+	for _, queue := range processes {
+		queues = append(queues, queue)
+	}
+
+	s := search{queues: queues, positions: make([]int, len(queues)), failed: map[string]bool{}}
+	return s.run(nil)
+}
+
+type search struct {
+	queues    [][]HistoryOperation
+	positions []int
+	failed    map[string]bool
+}
+
+func (s *search) run(page []byte) bool {
+	done := true
+	for i, queue := range s.queues {
+		if s.positions[i] < len(queue) {
+			done = false
+			break
+		}
+	}
+	if done {
+		return true
+	}
+
+	key := fmt.Sprint(s.positions) + string(page)
+	if page == nil {
+		key += "<none>"
+	}
+	if s.failed[key] {
+		return false
+	}
+	if s.stuck(page) {
+		s.failed[key] = true
+		return false
+	}
+
+	candidates := []int{}
+	for i, queue := range s.queues {
+		if s.positions[i] < len(queue) {
+			candidates = append(candidates, i)
+		}
+	}
+	sort.Slice(candidates, func(a, b int) bool {
+		return returnTime(s.queues[candidates[a]][s.positions[candidates[a]]]) < returnTime(s.queues[candidates[b]][s.positions[candidates[b]]])
+	})
+
+	for _, i := range candidates {
+		o := s.queues[i][s.positions[i]]
+		next, ok := apply(page, o)
+		if o.Pending {
+			// a write that never returned may have never happened either
+			s.positions[i]++
+			skipped := s.run(page)
+			s.positions[i]--
+			if skipped {
+				return true
+			}
+		}
+		if !ok {
+			continue
+		}
+		s.positions[i]++
+		found := s.run(next)
+		s.positions[i]--
+		if found {
+			return true
+		}
+	}
+	s.failed[key] = true
+	return false
+}
+
+// stuck is true if some processor's next read can't return what it did whatever order the rest go in:
+// a page never goes back to not found, and the last write before a read has to agree with what it read.
+// Without this a read that had to go before a write which returned first is only found after every interleaving
+// of the other processors has been tried
+func (s *search) stuck(page []byte) bool {
+	for i, queue := range s.queues {
+		if s.positions[i] == len(queue) || queue[s.positions[i]].Op != HISTORY_READ {
+			continue
+		}
+		read := queue[s.positions[i]]
+		if _, ok := apply(page, read); ok {
+			continue
+		}
+		if read.NotFound || !s.anyWriteAgrees(read) {
+			return true
+		}
+	}
+	return false
+}
+
+func (s *search) anyWriteAgrees(read HistoryOperation) bool {
+	for i, queue := range s.queues {
+		for _, o := range queue[s.positions[i]:] {
+			if o.Op == HISTORY_WRITE && agrees(read, o) {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+func returnTime(o HistoryOperation) int64 {
+	if o.Pending {
+		return math.MaxInt64
+	}
+	return o.Return
+}
+
+// apply returns the page after o, and false if o is a read that couldn't have returned what it did
+func apply(page []byte, o HistoryOperation) ([]byte, bool) {
+	if o.Op == HISTORY_READ {
+		if o.NotFound || page == nil {
+			return page, o.NotFound && page == nil
+		}
+		return page, bytes.Equal(page, o.Read)
+	}
+
+	size := o.PageSize
+	if size < o.Offset+len(o.Written) {
+		size = o.Offset + len(o.Written)
+	}
+	next := make([]byte, size)
+	copy(next, page)
+	copy(next[o.Offset:], o.Written)
+	return next, true
+}
+
+// ShrinkViolation greedily drops operations while the rest still can't be ordered
+func ShrinkViolation(ops []HistoryOperation) []HistoryOperation {
+	/**
+	1. Drop reads one at a time, keeping every write
+	2. Drop the writes none of the remaining reads saw: a write whose bytes don't show up in any remaining read.
+	Dropping a write some read saw would only leave that read without an explanation, which isn't the real problem
+	*/
+	minimal := append([]HistoryOperation{}, ops...)
+	for i := len(minimal) - 1; i >= 0; i-- {
+		if minimal[i].Op != HISTORY_READ {
+			continue
+		}
+		minimal = tryRemove(minimal, i)
+	}
+	for i := len(minimal) - 1; i >= 0; i-- {
+		if minimal[i].Op != HISTORY_WRITE || seenByAnyRead(minimal, minimal[i]) {
+			continue
+		}
+		minimal = tryRemove(minimal, i)
+	}
+	return minimal
+}
+
+func tryRemove(ops []HistoryOperation, i int) []HistoryOperation {
+	without := append(append([]HistoryOperation{}, ops[:i]...), ops[i+1:]...)
+	if IsSequentiallyConsistent(without) {
+		return ops
+	}
+	return without
+}
+
+func seenByAnyRead(ops []HistoryOperation, write HistoryOperation) bool {
+	for _, o := range ops {
+		if o.Op == HISTORY_READ && agrees(o, write) {
+			return true
+		}
+	}
+	return false
+}
+
+// agrees is true if read saw the bytes write wrote
+func agrees(read HistoryOperation, write HistoryOperation) bool {
+	if read.NotFound || len(read.Read) < write.Offset+len(write.Written) {
+		return false
+	}
+	return bytes.Equal(read.Read[write.Offset:write.Offset+len(write.Written)], write.Written)
+}
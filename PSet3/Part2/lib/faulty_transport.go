@@ -0,0 +1,46 @@
+package lib
+
+import "distsys/common/faults"
+
+// FaultyTransport passes every message one node sends through a fault injector.
+// To the injector processor i is node i and CM i is node NumOfProcessors()+i
+type FaultyTransport struct {
+	Transport
+	From     int // the sending node
+	Injector *faults.Injector
+}
+
+// WithFaults returns the Transport node sends on, transport itself if there is no injector
+func WithFaults(transport Transport, node int, injector *faults.Injector) Transport {
+	if injector == nil {
+		return transport
+	}
+	return &FaultyTransport{Transport: transport, From: node, Injector: injector}
+}
+
+// ProcessorNode and CMNode are the nodes a fault injector knows processors and CMs as
+func ProcessorNode(processorId int) int {
+	return processorId
+}
+
+func CMNode(transport Transport, cmId int) int {
+	return transport.NumOfProcessors() + cmId
+}
+
+func (t *FaultyTransport) SendToProcessor(processorId int, m Message) {
+	t.Injector.Send(t.From, ProcessorNode(processorId), func() {
+		t.Transport.SendToProcessor(processorId, m)
+	})
+}
+
+func (t *FaultyTransport) SendToCM(cmId int, m Message) {
+	t.Injector.Send(t.From, CMNode(t.Transport, cmId), func() {
+		t.Transport.SendToCM(cmId, m)
+	})
+}
+
+func (t *FaultyTransport) SendConfirmationToCM(cmId int, m Message) {
+	t.Injector.Send(t.From, CMNode(t.Transport, cmId), func() {
+		t.Transport.SendConfirmationToCM(cmId, m)
+	})
+}
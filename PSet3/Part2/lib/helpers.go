@@ -4,6 +4,7 @@ import (
 	"encoding/json"
 	"fmt"
 	"reflect"
+	"sort"
 )
 
 func (cm *CentralManager) log(args ...interface{}) {
@@ -45,14 +46,35 @@ func (s *State) Clone() (State, error) {
 	return stateCopy, nil
 }
 
+func CopyPage(page []byte) []byte {
+	pageCopy := make([]byte, len(page))
+	copy(pageCopy, page)
+	return pageCopy
+}
+
 func CheckDuplicate(arr []Message, m Message) bool {
 	/**
-	Checks whether message "m" exists in queue "arr"
+	Checks whether message "m" exists in queue "arr".
+	The term is left out, a processor resending a request after a new leader is elected stamps it with the new term.
+	So is the trace context, a TracingTransport stamps every send with a new one
 	*/
 	for i := range arr {
-		if reflect.DeepEqual(m, arr[i]) {
+		withTerm := m
+		withTerm.Term = arr[i].Term
+		withTerm.Trace = arr[i].Trace
+		if reflect.DeepEqual(withTerm, arr[i]) {
 			return true
 		}
 	}
 	return false
 }
+
+// sortedKeys returns the keys of m in increasing order, for loops whose order decides what gets sent first
+func sortedKeys[V any](m map[int]V) []int {
+	keys := make([]int, 0, len(m))
+	for key := range m {
+		keys = append(keys, key)
+	}
+	sort.Ints(keys)
+	return keys
+}
@@ -0,0 +1,36 @@
+package lib
+
+import (
+	"distsys/common/sim"
+	"distsys/common/trace"
+	"math"
+	"path/filepath"
+	"testing"
+)
+
+func TestResentRequestIsQueuedOnce(t *testing.T) {
+	recorder, err := trace.NewRecorder(filepath.Join(t.TempDir(), "trace.jsonl"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer recorder.Close()
+	transport := NewChannelTransport(2, 1, 10)
+	cm := &CentralManager{
+		Transport:             transport,
+		CurrentState:          NewState(),
+		CountDownToDeath:      math.MaxInt32,
+		FinalCountDownToDeath: math.MaxInt32,
+		IsAlive:               true,
+	}
+
+	// processor 0 holds the page, processor 1 asks for it and asks again after timing out
+	cm.EnqueueRequest(Message{Sender: 0, Type: WRITE_REQUEST, PageId: 3})
+	sender := WithTracer(transport, recorder.Tracer(ProcessorNode(1), "processor 1", sim.WallClock{}))
+	for i := 0; i < 2; i++ {
+		sender.SendToCM(0, Message{Sender: 1, Type: READ_REQUEST, PageId: 3})
+		cm.EnqueueRequest(<-transport.CMInbox(0))
+	}
+	if queue := cm.CurrentState.RequestMap[3].Queue; len(queue) != 2 {
+		t.Errorf("queue is %v, want the write and one copy of the read", queue)
+	}
+}
@@ -0,0 +1,101 @@
+package lib
+
+import (
+	"bufio"
+	"encoding/json"
+	"os"
+	"sync"
+)
+
+const HISTORY_INVOKE = "invoke"
+const HISTORY_RETURN = "return"
+const HISTORY_READ = "read"
+const HISTORY_WRITE = "write"
+
+// HistoryEvent is one line of a history file: a processor starting or finishing a read or write.
+// Part1 writes the same format, its pages are 8 byte big endian ints.
+type HistoryEvent struct {
+	Kind     string // HISTORY_INVOKE or HISTORY_RETURN
+	OpId     int64  // the same on an operation's invoke and return
+	Process  int
+	Op       string // HISTORY_READ or HISTORY_WRITE
+	PageId   int
+	Offset   int    `json:",omitempty"` // write invoke: where in the page Value goes
+	PageSize int    `json:",omitempty"` // write invoke: size of the page if this write creates it
+	Value    []byte `json:",omitempty"` // write invoke: bytes written, read return: page contents
+	NotFound bool   `json:",omitempty"` // read return: the page had never been written
+	Time     int64  // UnixNano on the processor's clock, virtual in a simulation
+}
+
+// History records every read and write invocation and response as JSON lines, see cmd/checkhistory.
+// Events go straight to the file, so a node that is killed mid run leaves a usable history behind.
+type History struct {
+	mu       sync.Mutex
+	file     *os.File
+	encoder  *json.Encoder
+	nextOpId int64
+}
+
+func NewHistory(path string) (*History, error) {
+	file, err := os.Create(path)
+	if err != nil {
+		return nil, err
+	}
+	return &History{file: file, encoder: json.NewEncoder(file)}, nil
+}
+
+// Invoke records the start of an operation, at event.Time, and returns its OpId. Safe to call on a nil History
+func (h *History) Invoke(event HistoryEvent) int64 {
+	if h == nil {
+		return 0
+	}
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	h.nextOpId++
+	event.Kind = HISTORY_INVOKE
+	event.OpId = h.nextOpId
+	h.encoder.Encode(event)
+	return event.OpId
+}
+
+// Return records the end of the operation event.OpId, at event.Time. Safe to call on a nil History
+func (h *History) Return(event HistoryEvent) {
+	if h == nil {
+		return
+	}
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	event.Kind = HISTORY_RETURN
+	h.encoder.Encode(event)
+}
+
+func (h *History) Close() error {
+	if h == nil {
+		return nil
+	}
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	return h.file.Close()
+}
+
+// LoadHistory reads a history file, stopping at a torn last line.
+// OpIds are only unique per file, operations are told apart by Process and OpId
+func LoadHistory(path string) ([]HistoryEvent, error) {
+	file, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+
+	events := []HistoryEvent{}
+	scanner := bufio.NewScanner(file)
+	scanner.Buffer(make([]byte, 64*1024), 64*1024*1024)
+	for scanner.Scan() {
+		event := HistoryEvent{}
+		if err := json.Unmarshal(scanner.Bytes(), &event); err != nil {
+			break
+		}
+		events = append(events, event)
+	}
+	return events, scanner.Err()
+}
@@ -0,0 +1,99 @@
+package lib
+
+import (
+	"distsys/common/metrics"
+	"strconv"
+	"time"
+)
+
+// Metrics is what Processors and CentralManagers measure for a /metrics endpoint (see common/metrics).
+// Every node in a process shares one, the processor or cm label tells them apart.
+// Every method is safe to call on nil Metrics, which measure nothing
+type Metrics struct {
+	requestDuration   *metrics.Histogram
+	timeouts          *metrics.Counter
+	invalidations     *metrics.Histogram
+	queueLength       *metrics.Gauge
+	electionsStarted  *metrics.Counter
+	leader            *metrics.Gauge
+	stateForwardBytes *metrics.Counter
+}
+
+// INVALIDATION_BUCKETS are upper bounds for the number of copies a write invalidates
+var INVALIDATION_BUCKETS = []float64{0, 1, 2, 3, 5, 10, 20}
+
+func NewMetrics(registry *metrics.Registry) *Metrics {
+	return &Metrics{
+		requestDuration: registry.Histogram("ivy_processor_request_duration_seconds",
+			"Time from a read or write being asked for to it completing, served from the cache or not", metrics.LATENCY_BUCKETS, "processor", "op"),
+		timeouts: registry.Counter("ivy_processor_timeouts_total",
+			"Request timeouts, after which the processor tries the next CM of the page's shard", "processor"),
+		invalidations: registry.Histogram("ivy_cm_invalidations_per_write",
+			"INVALIDATE_COPY messages sent for each WRITE_REQUEST the CM serves", INVALIDATION_BUCKETS, "cm"),
+		queueLength: registry.Gauge("ivy_cm_queue_length",
+			"Requests queued for a page in the CM's RequestMap, including the one being served", "cm", "page"),
+		electionsStarted: registry.Counter("ivy_cm_elections_started_total",
+			"Raft elections the CM started", "cm"),
+		leader: registry.Gauge("ivy_cm_leader",
+			"1 if the CM is the Raft leader of its shard, 0 if not", "cm"),
+		stateForwardBytes: registry.Counter("ivy_cm_state_forward_bytes_total",
+			"Bytes of log entries (APPEND_ENTRIES) and base state (FORWARD_STATE) the CM sent to its followers", "cm", "type"),
+	}
+}
+
+func (m *Metrics) RequestDone(processorId int, op MessageType, d time.Duration) {
+	if m == nil {
+		return
+	}
+	name := "read"
+	if op == WRITE_REQUEST {
+		name = "write"
+	}
+	m.requestDuration.Observe(d.Seconds(), strconv.Itoa(processorId), name)
+}
+
+func (m *Metrics) Timeout(processorId int) {
+	if m == nil {
+		return
+	}
+	m.timeouts.Inc(strconv.Itoa(processorId))
+}
+
+func (m *Metrics) Invalidations(cmId int, count int) {
+	if m == nil {
+		return
+	}
+	m.invalidations.Observe(float64(count), strconv.Itoa(cmId))
+}
+
+func (m *Metrics) QueueLength(cmId int, pageId int, length int) {
+	if m == nil {
+		return
+	}
+	m.queueLength.Set(float64(length), strconv.Itoa(cmId), strconv.Itoa(pageId))
+}
+
+func (m *Metrics) ElectionStarted(cmId int) {
+	if m == nil {
+		return
+	}
+	m.electionsStarted.Inc(strconv.Itoa(cmId))
+}
+
+func (m *Metrics) Leader(cmId int, isLeader bool) {
+	if m == nil {
+		return
+	}
+	value := 0.0
+	if isLeader {
+		value = 1
+	}
+	m.leader.Set(value, strconv.Itoa(cmId))
+}
+
+func (m *Metrics) StateForwarded(cmId int, messageType MessageType, bytes int) {
+	if m == nil {
+		return
+	}
+	m.stateForwardBytes.Add(float64(bytes), strconv.Itoa(cmId), messageType.toString())
+}
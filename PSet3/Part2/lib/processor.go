@@ -1,97 +1,186 @@
 package lib
 
 import (
-	"math/rand"
+	"context"
+	"distsys/common/directory"
+	"distsys/common/sim"
+	"distsys/common/trace"
+	"errors"
+	"strconv"
+	"sync"
 	"time"
 )
 
+var ErrPageNotFound = errors.New("page has never been written")
+var ErrPageOverflow = errors.New("write goes past the end of the page")
+
 type Processor struct {
-	Id                   int
-	CentralManagers      []*CentralManager
-	PrimaryCM            int
-	Channels             []chan Message
-	NumOfVariables       int
-	RequestMap           map[int]RequestStatus
-	Cache                map[int]PageCache
-	Debug                bool
-	TimeoutChan          chan int
-	TimeoutDur           int
-	InElection           bool
-	AcknowledgementArray []int
+	Id                int
+	PrimaryCMs        []int               // {[shard]: CM believed to lead the shard's Raft group}, starts at each group's first CM if nil
+	Epochs            []int64             // {[shard]: highest Raft term seen from the shard's CMs}, messages from older terms are ignored
+	Directory         directory.Directory // which shard of CMs each page belongs to, nil if pages aren't sharded
+	Transport         Transport
+	NumOfVariables    int
+	PageSize          int // number of bytes in every page
+	RequestMap        map[int]RequestStatus
+	Cache             map[int]PageCache
+	Debug             bool
+	TimeoutDur        int
+	RandomRequests    bool                // make random READ and WRITE requests every tick
+	ReadPercent       *int                // percent of random requests that are reads, a coin flip if nil
+	RequestInterval   time.Duration       // random requests are up to this far apart, 2 seconds if 0
+	PendingOperations map[int][]operation // {[pageId]: operations waiting on the page, first one is in flight}
+	History           *History            // nil if reads and writes aren't recorded
+	Runtime           sim.Runtime         // time, timers and randomness, Start uses the real ones if nil
+	Tracer            *trace.Tracer       // nil if the processor isn't traced, its Transport records the sends
+	Metrics           *Metrics            // nil if nothing is measured
+
+	operationsOnce sync.Once
+	operations     chan operation
 }
 
 type PageCache struct {
-	// {pageId: {isOwner, isValid, Data}}
-	IsOwner bool
-	IsValid bool
-	Data    int
+	// {pageId: {isOwner, isValid, isWritable, Data}}
+	IsOwner    bool
+	IsValid    bool
+	IsWritable bool   // owner that hasn't handed out a copy since it got the page, so it can write without the CM
+	Data       []byte // always PageSize bytes when IsValid
+}
+
+// operation is a Read or Write waiting to be applied by the processor's own goroutine
+type operation struct {
+	Type    MessageType // READ_REQUEST or WRITE_REQUEST
+	PageId  int
+	Offset  int                  // WRITE_REQUEST only: where in the page Value goes
+	Value   []byte               // WRITE_REQUEST only
+	Result  chan operationResult // nil for random requests, nobody is waiting on those
+	OpId    int64                // the operation's id in History
+	Started time.Time            // when the processor was asked for it, for Metrics
+}
+
+type operationResult struct {
+	Value []byte
+	Err   error
 }
 
 func (p *Processor) Start() {
-	// ticker to make regular requests
+	if p.Runtime == nil {
+		p.Runtime = sim.NewRealRuntime()
+	}
+	p.Init()
 
-	reqTicker := time.NewTicker(time.Duration(rand.Intn(2 * int(time.Second))))
-	p.TimeoutChan = make(chan int, p.NumOfVariables) //fault tolerant mod: add timeout to requests
 	for {
 		select {
-		case <-reqTicker.C:
-			if p.InElection {
-				break
-			}
-			p.SendRandomRequest()
-			go p.StartRequestTimer()
+		case f := <-sim.EventsOf(p.Runtime):
+			// request ticks and request timeouts
+			f()
 
-		case <-p.TimeoutChan:
-			p.HandleTimeout()
+		case op := <-p.operationChan():
+			p.HandleOperation(op)
 
-		case m := <-p.Channels[p.Id]:
+		case m := <-p.Transport.ProcessorInbox(p.Id):
 			p.log(true, "%v message received", m.Type.toString())
 			p.HandleMessage(m)
 		}
 	}
 }
 
+// Init picks the CMs to start with and starts the request ticker. Start calls it, a simulation calls it instead of Start
+func (p *Processor) Init() {
+	if p.PrimaryCMs == nil {
+		for shard := 0; shard < p.numOfShards(); shard++ {
+			p.PrimaryCMs = append(p.PrimaryCMs, shard*p.shardSize())
+		}
+	}
+	if p.Epochs == nil {
+		p.Epochs = make([]int64, p.numOfShards())
+	}
+	// ticker to make regular requests
+	maxInterval := p.RequestInterval
+	if maxInterval == 0 {
+		maxInterval = 2 * time.Second
+	}
+	requestInterval := time.Duration(1 + p.Runtime.Intn(int(maxInterval)))
+	var tick func()
+	tick = func() {
+		p.Runtime.After(requestInterval, tick)
+		if p.RandomRequests {
+			p.SendRandomRequest()
+		}
+	}
+	p.Runtime.After(requestInterval, tick)
+}
+
 func (p *Processor) HandleMessage(m Message) {
 	p.log(true, "%v message (%v) from %v for pageId %v", m.Type.toString(), m.Type, m.Sender, m.PageId)
+	p.Tracer.Receive(m.Trace, m.TraceInfo())
+
+	if m.Term != 0 && !p.CheckEpoch(m) {
+		p.log(false, "ignoring %v for pageId %v from old term %v", m.Type.toString(), m.PageId, m.Term)
+		return
+	}
 
 	switch m.Type {
 	case WRITE_FORWARD:
-		// invalidate my cache
+		// hand the page contents to the new owner and invalidate my cache
+		content := p.Cache[m.PageId].Data
 		p.Cache[m.PageId] = PageCache{IsOwner: false, IsValid: false}
 		p.log(false, "cache for pageId %v invalidated", m.PageId)
-		p.Channels[m.Sender] <- Message{Type: PAGE_TO_WRITE, PageId: m.PageId}
+		p.Transport.SendToProcessor(m.Sender, Message{Type: PAGE_TO_WRITE, PageId: m.PageId, Content: content})
 
 	case READ_FORWARD:
-		// send PAGE_COPY_FORWARD message to forwardee
-		content := p.Cache[m.PageId].Data
-		p.Channels[m.Sender] <- Message{Type: PAGE_COPY_FORWARD, PageId: m.PageId, Content: content}
+		// send PAGE_COPY_FORWARD message to forwardee, a write now has to go through the CM to invalidate the copy
+		cache := p.Cache[m.PageId]
+		cache.IsWritable = false
+		p.Cache[m.PageId] = cache
+		content := CopyPage(cache.Data)
+		p.Transport.SendToProcessor(m.Sender, Message{Type: PAGE_COPY_FORWARD, PageId: m.PageId, Content: content})
 
 	case PAGE_COPY_FORWARD:
 		// send read confirmation to CM
-		p.Cache[m.PageId] = PageCache{IsOwner: false, IsValid: true, Data: m.Content}
+		if queue := p.PendingOperations[m.PageId]; p.RequestMap[m.PageId].State != PENDING_READ_COMPLETION ||
+			len(queue) == 0 || queue[0].Type != READ_REQUEST {
+			if p.Cache[m.PageId].IsValid && !p.Cache[m.PageId].IsOwner {
+				// a new leader redoing a read this processor already has, confirm again but keep the copy
+				p.Confirm(m.PageId, READ_CONFIRMATION)
+			} else {
+				// a late or duplicate copy of a read this processor is done with, it must not complete anything else
+				p.log(false, "ignoring %v for pageId %v, no read is waiting for it", m.Type.toString(), m.PageId)
+			}
+			break
+		}
+		page := p.NewPage(m.Content)
+		p.Cache[m.PageId] = PageCache{IsOwner: false, IsValid: true, Data: page}
 		p.RequestMap[m.PageId] = RequestStatus{Timestamp: 0, State: IDLE}
-		p.CentralManagers[p.PrimaryCM].ConfirmationChan <- Message{Sender: p.Id, Type: READ_CONFIRMATION, PageId: m.PageId}
+		p.Confirm(m.PageId, READ_CONFIRMATION)
+		p.FinishOperation(m.PageId, operationResult{Value: CopyPage(page)})
 
 	case INVALIDATE_COPY:
 		// update cache map
 		p.Cache[m.PageId] = PageCache{IsOwner: false, IsValid: false}
-		p.CentralManagers[p.PrimaryCM].ConfirmationChan <- Message{Sender: p.Id, Type: INVALIDATE_CONFIRMATION, PageId: m.PageId}
+		p.Confirm(m.PageId, INVALIDATE_CONFIRMATION)
 		p.log(false, "cache for pageId %v invalidated", m.PageId)
 
 	case PAGE_TO_WRITE:
-		// write to variable and send confirmation to CM
+		// write to the page contents handed over by the old owner (empty for a new page) and send confirmation to CM
+		if p.Cache[m.PageId].IsOwner && p.RequestMap[m.PageId].State != PENDING_WRITE_COMPLETION {
+			// a new leader redoing a write this processor already has, confirm again but keep the page
+			p.Confirm(m.PageId, WRITE_CONFIRMATION)
+			break
+		}
+		page := p.NewPage(m.Content)
+		if queue := p.PendingOperations[m.PageId]; len(queue) > 0 && queue[0].Type == WRITE_REQUEST {
+			copy(page[queue[0].Offset:], queue[0].Value)
+		}
+		p.Cache[m.PageId] = PageCache{IsOwner: true, IsValid: true, IsWritable: true, Data: page}
 		p.RequestMap[m.PageId] = RequestStatus{Timestamp: 0, State: IDLE}
-		p.CentralManagers[p.PrimaryCM].ConfirmationChan <- Message{Sender: p.Id, Type: WRITE_CONFIRMATION, PageId: m.PageId}
+		p.Confirm(m.PageId, WRITE_CONFIRMATION)
+		p.FinishOperation(m.PageId, operationResult{Value: CopyPage(page)})
 
 	case PAGE_NOT_FOUND:
 		p.log(true, "%v received, resetting request status to IDLE", MESSAGE_TYPES[PAGE_NOT_FOUND])
 		p.RequestMap[m.PageId] = RequestStatus{Timestamp: 0, State: IDLE}
-
-	case START_ELECTION:
-		p.HandleStartElection(m)
-
-	case ACKNOWLEDGE:
-		p.HandleAcknowledge(m)
+		p.FinishOperation(m.PageId, operationResult{Err: ErrPageNotFound})
 
 	case ANNOUNCE_PRIMARY:
 		p.HandleAnnouncePrimary(m)
@@ -99,8 +188,14 @@ func (p *Processor) HandleMessage(m Message) {
 }
 
 func (p *Processor) SendRandomRequest() {
-	pageId := rand.Intn(p.NumOfVariables) // choose random page to read or write to
-	readOrWrite := rand.Intn(2)           // randomly read or write
+	pageId := p.Runtime.Intn(p.NumOfVariables) // choose random page to read or write to
+	readOrWrite := p.Runtime.Intn(2)           // randomly read or write
+	if p.ReadPercent != nil {
+		readOrWrite = 1
+		if p.Runtime.Intn(100) < *p.ReadPercent {
+			readOrWrite = 0
+		}
+	}
 
 	if p.RequestMap[pageId].State != IDLE || //don't make any request if pending reply
 		p.Cache[pageId].IsOwner || //don't make any READ or WRITE request if owner
@@ -108,51 +203,172 @@ func (p *Processor) SendRandomRequest() {
 		return
 	}
 
+	// 0 = READ_REQUEST, 1 = WRITE_REQUEST, a write stores the processor's own id
+	p.HandleOperation(operation{Type: MessageType(readOrWrite), PageId: pageId, Value: []byte(strconv.Itoa(p.Id))})
+}
+
+// Read blocks until the processor holds a valid copy of the page and returns its contents.
+// Returns ErrPageNotFound if the page has never been written.
+func (p *Processor) Read(ctx context.Context, pageId int) ([]byte, error) {
+	return p.submit(ctx, operation{Type: READ_REQUEST, PageId: pageId})
+}
+
+// Write is WriteAt offset 0.
+func (p *Processor) Write(ctx context.Context, pageId int, data []byte) error {
+	return p.WriteAt(ctx, pageId, 0, data)
+}
+
+// WriteAt blocks until the processor owns the page and data has been copied into it starting at offset.
+// The rest of the page keeps the contents handed over by the previous owner.
+func (p *Processor) WriteAt(ctx context.Context, pageId int, offset int, data []byte) error {
+	if offset < 0 || offset+len(data) > p.PageSize {
+		return ErrPageOverflow
+	}
+	value := make([]byte, len(data))
+	copy(value, data)
+	_, err := p.submit(ctx, operation{Type: WRITE_REQUEST, PageId: pageId, Offset: offset, Value: value})
+	return err
+}
+
+func (p *Processor) submit(ctx context.Context, op operation) ([]byte, error) {
+	/**
+	Hands the operation to the processor's goroutine and waits for the result.
+	If ctx is cancelled the caller stops waiting, but a request already sent to the CM still completes
+	*/
+	op.Result = make(chan operationResult, 1)
+	select {
+	case p.operationChan() <- op:
+	case <-ctx.Done():
+		return nil, ctx.Err()
+	}
+
+	select {
+	case result := <-op.Result:
+		return result.Value, result.Err
+	case <-ctx.Done():
+		return nil, ctx.Err()
+	}
+}
+
+func (p *Processor) operationChan() chan operation {
+	p.operationsOnce.Do(func() {
+		p.operations = make(chan operation, p.NumOfVariables)
+	})
+	return p.operations
+}
+
+func (p *Processor) HandleOperation(op operation) {
+	/**
+	Operations on the same page are applied one at a time, in the order they arrive
+	*/
+	if p.PendingOperations == nil {
+		p.PendingOperations = map[int][]operation{}
+	}
+	op.OpId = p.RecordInvoke(op)
+	op.Started = p.Runtime.Now()
+	p.PendingOperations[op.PageId] = append(p.PendingOperations[op.PageId], op)
+	if len(p.PendingOperations[op.PageId]) == 1 {
+		p.StartOperation(op)
+	}
+}
+
+func (p *Processor) StartOperation(op operation) {
+	/**
+	1. Complete straight away if the cache already allows it (valid copy for a READ, owner with no copies out for a WRITE)
+	2. Else send a READ_REQUEST/WRITE_REQUEST to the primary CM, the operation completes on PAGE_COPY_FORWARD or PAGE_TO_WRITE
+	*/
+	cache := p.Cache[op.PageId]
+	if op.Type == READ_REQUEST && (cache.IsValid || cache.IsOwner) {
+		p.FinishOperation(op.PageId, operationResult{Value: CopyPage(cache.Data)})
+		return
+	}
+	if op.Type == WRITE_REQUEST && cache.IsWritable {
+		copy(cache.Data[op.Offset:], op.Value)
+		p.FinishOperation(op.PageId, operationResult{Value: CopyPage(cache.Data)})
+		return
+	}
+
+	p.Tracer.Begin(op.Type.toString(), op.PageId)
 	requestState := PENDING_READ_COMPLETION
-	// if readOrWrite == 1 => write operation => set self to owner
-	if readOrWrite == 1 {
-		p.Cache[pageId] = struct {
-			IsOwner bool
-			IsValid bool
-			Data    int
-		}{true, true, p.Id}
+	if op.Type == WRITE_REQUEST {
 		requestState = PENDING_WRITE_COMPLETION
 	}
 
+	// the value to write stays in PendingOperations until PAGE_TO_WRITE arrives, the CM never needs it
 	request := Message{ // make request
-		Sender:  p.Id,
-		Type:    MessageType(readOrWrite), // 0 = READ_REQUEST, 1 = WRITE_REQUEST
-		PageId:  pageId,
-		Content: p.Id,
+		Sender: p.Id,
+		Type:   op.Type,
+		PageId: op.PageId,
+		Term:   p.Epochs[p.shardOf(op.PageId)],
+	}
+	p.Transport.SendToCM(p.primaryCMFor(op.PageId), request) //send request
+
+	p.RequestMap[op.PageId] = RequestStatus{Timestamp: p.Runtime.Now().UnixNano(), State: requestState, Message: request}
+	p.log(false, "%v request (%v) sent for page Id %v", op.Type.toString(), op.Type, op.PageId)
+	p.StartRequestTimer()
+}
+
+// NewPage returns a page of PageSize bytes holding content, or an empty page if content is nil
+func (p *Processor) NewPage(content []byte) []byte {
+	page := make([]byte, p.PageSize)
+	copy(page, content)
+	return page
+}
+
+func (p *Processor) FinishOperation(pageId int, result operationResult) {
+	/**
+	Replies to the operation in flight for pageId and starts the next queued one
+	*/
+	queue := p.PendingOperations[pageId]
+	if len(queue) == 0 {
+		return
+	}
+	p.RecordReturn(queue[0], result)
+	p.Metrics.RequestDone(p.Id, queue[0].Type, p.Runtime.Now().Sub(queue[0].Started))
+	if queue[0].Result != nil {
+		queue[0].Result <- result
 	}
-	p.CentralManagers[p.PrimaryCM].Incoming <- request //send request
+	p.PendingOperations[pageId] = queue[1:]
+	if len(p.PendingOperations[pageId]) > 0 {
+		p.StartOperation(p.PendingOperations[pageId][0])
+	}
+}
+
+func (p *Processor) RecordInvoke(op operation) int64 {
+	event := HistoryEvent{Process: p.Id, Op: HISTORY_READ, PageId: op.PageId, Time: p.Runtime.Now().UnixNano()}
+	if op.Type == WRITE_REQUEST {
+		event.Op = HISTORY_WRITE
+		event.Offset = op.Offset
+		event.PageSize = p.PageSize
+		event.Value = op.Value
+	}
+	return p.History.Invoke(event)
+}
 
-	p.RequestMap[pageId] = RequestStatus{Timestamp: time.Now().UnixNano(), State: requestState, Message: request}
-	p.log(false, "%v request (%v) sent for page Id %v", MESSAGE_TYPES[readOrWrite], MessageType(readOrWrite), pageId)
+func (p *Processor) RecordReturn(op operation, result operationResult) {
+	event := HistoryEvent{OpId: op.OpId, Process: p.Id, Op: HISTORY_READ, PageId: op.PageId, Value: result.Value, NotFound: result.Err == ErrPageNotFound}
+	if op.Type == WRITE_REQUEST {
+		event = HistoryEvent{OpId: op.OpId, Process: p.Id, Op: HISTORY_WRITE, PageId: op.PageId}
+	}
+	event.Time = p.Runtime.Now().UnixNano()
+	p.History.Return(event)
 }
 
 func (p *Processor) StartRequestTimer() {
 	/**
 	after a duration, do a request timeout
 	*/
-	time.Sleep(time.Duration(p.TimeoutDur) * time.Second)
-	p.TimeoutChan <- 1
+	p.Runtime.After(time.Duration(p.TimeoutDur)*time.Second, p.HandleTimeout)
 }
 
 func (p *Processor) HandleTimeout() {
 	/**
 	after a timeout, check for requests that are NOT IDLE, and have exceeded specified timeout duration
-	if request is not Idle and exceeded timeout duration => start election
+	if any has => the leader of the page's shard may be dead, send the shard's pending requests to the next CM in its group.
+	A CM that isn't the leader replies with ANNOUNCE_PRIMARY if it knows who is
 	*/
-
-	if p.InElection {
-		// check for acknowledgement messages and elect
-		p.ElectNewCM()
-		return
-	}
-	// p.InElection = true
-
-	for key := range p.RequestMap {
+	timedOutShards := map[int]bool{}
+	for _, key := range sortedKeys(p.RequestMap) {
 		requestState := p.RequestMap[key]
 		if requestState.State == IDLE {
 			// Don't check if request is in idle mode (means the request has completed)
@@ -160,93 +376,86 @@ func (p *Processor) HandleTimeout() {
 		}
 
 		//Check whether : currentTime >= requestTimestamp + timeoutDuration
-		if time.Now().UnixNano() >= requestState.Timestamp+int64(p.TimeoutDur*int(time.Second)) {
+		if p.Runtime.Now().UnixNano() >= requestState.Timestamp+int64(p.TimeoutDur*int(time.Second)) {
 			p.log(false, "timeout for pageId: %v, operation: %v", requestState.Message.PageId, requestState.Message.Type.toString())
-			p.StartElection()
+			p.Tracer.Local("timeout", requestState.Message.PageId)
+			p.Metrics.Timeout(p.Id)
+			timedOutShards[p.shardOf(key)] = true
 		}
 	}
+
+	for _, shard := range sortedKeys(timedOutShards) {
+		first := shard * p.shardSize()
+		p.PrimaryCMs[shard] = first + (p.PrimaryCMs[shard]-first+1)%p.shardSize()
+		p.log(false, "trying CM %v", p.PrimaryCMs[shard])
+		p.ResendRequests(shard)
+	}
 }
 
-func (p *Processor) StartElection() {
+func (p *Processor) HandleAnnouncePrimary(m Message) {
 	/**
-	set state to be in election mode
+	1. Set primary CM of the announcer's shard to announcer
+	2. Sent pending requests for that shard again
 	*/
-	if p.InElection {
-		// do nothing if already in election mode
-		return
-	}
+	shard := m.Sender / p.shardSize()
+	p.PrimaryCMs[shard] = m.Sender
+	p.ResendRequests(shard)
+}
+
+func (p *Processor) ResendRequests(shard int) {
+	for _, pageId := range sortedKeys(p.RequestMap) {
+		requestStatus := p.RequestMap[pageId]
+		if requestStatus.State == IDLE || p.shardOf(pageId) != shard {
+			continue
+		}
 
-	p.log(false, "broadcasting election")
-	for i := 0; i < len(p.Channels); i++ {
-		p.Channels[i] <- Message{Sender: p.Id, Type: START_ELECTION}
+		p.Transport.SendToCM(p.PrimaryCMs[shard], requestStatus.Message)
+		p.RequestMap[pageId] = RequestStatus{Timestamp: p.Runtime.Now().UnixNano(), State: requestStatus.State, Message: requestStatus.Message}
+		p.StartRequestTimer()
 	}
 }
 
-func (p *Processor) HandleStartElection(m Message) {
+func (p *Processor) CheckEpoch(m Message) bool {
 	/**
-	Loop through list of CMs
-	Reply id of the first CM that is alive to the initating processor
+	Only messages sent by a CM carry a term. Reject one from an older term than the newest seen for its shard,
+	it comes from a leader that has since been replaced - whatever it asks for, the new leader redoes
 	*/
-
-	if p.InElection {
-		// do nothing if already in election mode
-		return
+	shard := p.shardOf(m.PageId)
+	if m.Type == ANNOUNCE_PRIMARY {
+		shard = m.Sender / p.shardSize()
 	}
-
-	p.AcknowledgementArray = nil
-	p.InElection = true
-	p.log(false, "starting election")
-
-	if len(p.Channels) == p.Id+1 { //current channel has highest Id
-		//do something
-		for i := 0; i < len(p.CentralManagers); i++ {
-			go func(n int) {
-				p.log(true, "test sending to CM%v", n)
-				p.CentralManagers[n].ConfirmationChan <- Message{Sender: p.Id, Type: CHECK_ALIVE}
-				p.log(true, "test sent to CM%v", n)
-			}(i)
-			p.log(true, "before timeout")
-			go p.StartRequestTimer()
-
-		}
+	if m.Term < p.Epochs[shard] {
+		return false
 	}
+	p.Epochs[shard] = m.Term
+	return true
 }
 
-func (p *Processor) HandleAcknowledge(m Message) {
-	p.log(false, "CM %v alive, chosen", m.Sender)
-	p.AcknowledgementArray = append(p.AcknowledgementArray, m.Sender)
+// Confirm tells the page's primary CM that the instruction it sent has been carried out
+func (p *Processor) Confirm(pageId int, confirmation MessageType) {
+	p.Transport.SendConfirmationToCM(p.primaryCMFor(pageId), Message{Sender: p.Id, Type: confirmation, PageId: pageId, Term: p.Epochs[p.shardOf(pageId)]})
 }
 
-func (p *Processor) HandleAnnouncePrimary(m Message) {
-	/**
-	1. Set primary CM to announcer
-	2. Sent pending requests again
-	*/
-	p.PrimaryCM = m.Sender
-	p.InElection = false
-	for pageId := range p.RequestMap {
-		requestStatus := p.RequestMap[pageId]
-		if requestStatus.State == IDLE {
-			continue
-		}
-
-		p.CentralManagers[p.PrimaryCM].Incoming <- requestStatus.Message
-		p.RequestMap[pageId] = RequestStatus{Timestamp: time.Now().UnixNano(), State: requestStatus.State, Message: requestStatus.Message}
-		go p.StartRequestTimer()
+// shardOf returns the shard responsible for pageId, always 0 if pages aren't sharded
+func (p *Processor) shardOf(pageId int) int {
+	if p.Directory == nil {
+		return 0
 	}
+	return p.Directory.ManagerOf(pageId)
 }
 
-func (p *Processor) ElectNewCM() {
-	if len(p.AcknowledgementArray) == 0 {
-		p.log(true, "no responses gg ")
-		return
-	}
-	newCM := p.AcknowledgementArray[0]
-	for i := 1; i < len(p.AcknowledgementArray); i++ {
-		if p.AcknowledgementArray[i] < newCM {
-			newCM = p.AcknowledgementArray[i]
-		}
+func (p *Processor) numOfShards() int {
+	if p.Directory == nil {
+		return 1
 	}
-	p.log(false, "new Cm elected is CM %v", newCM)
-	p.CentralManagers[newCM].ConfirmationChan <- Message{Sender: p.Id, Type: ELECT}
+	return p.Directory.NumOfManagers()
+}
+
+// shardSize is the number of CMs in every shard's Raft group, shard i has CMs i*shardSize to (i+1)*shardSize-1
+func (p *Processor) shardSize() int {
+	return p.Transport.NumOfCentralManagers() / p.numOfShards()
+}
+
+func (p *Processor) primaryCMFor(pageId int) int {
+	return p.PrimaryCMs[p.shardOf(pageId)]
 }
@@ -0,0 +1,223 @@
+package lib
+
+import (
+	"bytes"
+	"distsys/common/sim"
+	"math"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+const TEST_PAGE_SIZE = 8
+
+// newSimCluster is numOfProcessors processors and one Raft group of numOfCentralManagers CMs in a simulation,
+// with no random requests, the way runSimulation in main.go builds them. The CMs keep write-ahead logs in walDir
+// unless it is empty
+func newSimCluster(t *testing.T, seed int64, numOfProcessors int, numOfCentralManagers int, walDir string) (*sim.Simulator, *SimTransport) {
+	t.Helper()
+	s := sim.New(seed)
+	transport := NewSimTransport(s, numOfProcessors, numOfCentralManagers)
+	for i := 0; i < numOfCentralManagers; i++ {
+		transport.CentralManagers[i] = newSimCM(t, s, transport, i, walDir)
+	}
+	for i := 0; i < numOfProcessors; i++ {
+		transport.Processors[i] = &Processor{
+			Id:             i,
+			Transport:      transport,
+			NumOfVariables: 4,
+			PageSize:       TEST_PAGE_SIZE,
+			RequestMap:     map[int]RequestStatus{},
+			Cache:          map[int]PageCache{},
+			TimeoutDur:     1,
+			Runtime:        s,
+		}
+	}
+	for _, cm := range transport.CentralManagers {
+		cm.Init()
+	}
+	for _, p := range transport.Processors {
+		p.Init()
+	}
+	return s, transport
+}
+
+// newSimCM is CM id, recovered from its write-ahead log in walDir like newCentralManager in main.go does.
+// It isn't started
+func newSimCM(t *testing.T, s *sim.Simulator, transport *SimTransport, id int, walDir string) *CentralManager {
+	t.Helper()
+	peers := []int{}
+	for i := 0; i < transport.NumOfCentralManagers(); i++ {
+		peers = append(peers, i)
+	}
+	cm := &CentralManager{
+		Id:                    id,
+		Peers:                 peers,
+		Transport:             transport,
+		CurrentState:          NewState(),
+		CountDownToDeath:      math.MaxInt32,
+		FinalCountDownToDeath: math.MaxInt32,
+		IsAlive:               true,
+		Runtime:               s,
+	}
+	if walDir == "" {
+		return cm
+	}
+	wal, saved, err := OpenWriteAheadLog(walDir, id, 10)
+	if err != nil {
+		t.Fatalf("CM %v: %v", id, err)
+	}
+	t.Cleanup(func() { wal.Close() })
+	cm.WAL = wal
+	cm.RestoreRaft(saved)
+	if cm.Raft.CurrentTerm, cm.Raft.VotedFor, err = wal.LoadVote(); err != nil {
+		t.Fatalf("CM %v: %v", id, err)
+	}
+	return cm
+}
+
+// do runs op on p and the simulation until op completes, failing the test if it doesn't within a minute
+func do(t *testing.T, s *sim.Simulator, p *Processor, op operation) operationResult {
+	t.Helper()
+	op.Result = make(chan operationResult, 1)
+	s.After(0, func() { p.HandleOperation(op) })
+	var result operationResult
+	done := func() bool {
+		select {
+		case result = <-op.Result:
+			return true
+		default:
+			return false
+		}
+	}
+	if !s.RunUntil(done, time.Minute) {
+		t.Fatalf("processor %v: %v of page %v never completed", p.Id, op.Type.toString(), op.PageId)
+	}
+	return result
+}
+
+func write(t *testing.T, s *sim.Simulator, p *Processor, pageId int, value string) {
+	t.Helper()
+	if result := do(t, s, p, operation{Type: WRITE_REQUEST, PageId: pageId, Value: []byte(value)}); result.Err != nil {
+		t.Fatalf("processor %v: write %q to page %v: %v", p.Id, value, pageId, result.Err)
+	}
+}
+
+func read(t *testing.T, s *sim.Simulator, p *Processor, pageId int) string {
+	t.Helper()
+	result := do(t, s, p, operation{Type: READ_REQUEST, PageId: pageId})
+	if result.Err != nil {
+		t.Fatalf("processor %v: read page %v: %v", p.Id, pageId, result.Err)
+	}
+	return string(bytes.TrimRight(result.Value, "\x00"))
+}
+
+func TestOwnerWriteInvalidatesCopies(t *testing.T) {
+	for seed := int64(1); seed <= 5; seed++ {
+		s, transport := newSimCluster(t, seed, 3, 3, "")
+		owner, reader, other := transport.Processors[0], transport.Processors[1], transport.Processors[2]
+
+		write(t, s, owner, 0, "a")
+		if got := read(t, s, reader, 0); got != "a" {
+			t.Fatalf("seed %v: reader read %q after the first write, want %q", seed, got, "a")
+		}
+		if got := read(t, s, other, 0); got != "a" {
+			t.Fatalf("seed %v: other read %q after the first write, want %q", seed, got, "a")
+		}
+		// the owner handed out copies, so this write has to go through the CM and invalidate them
+		write(t, s, owner, 0, "b")
+		if got := read(t, s, reader, 0); got != "b" {
+			t.Errorf("seed %v: reader read %q after the owner wrote again, want %q", seed, got, "b")
+		}
+		if got := read(t, s, other, 0); got != "b" {
+			t.Errorf("seed %v: other read %q after the owner wrote again, want %q", seed, got, "b")
+		}
+		if !owner.Cache[0].IsOwner {
+			t.Errorf("seed %v: owner lost the page to its own write", seed)
+		}
+	}
+}
+
+func TestOwnerWritesLocallyWithoutCopies(t *testing.T) {
+	s, transport := newSimCluster(t, 1, 2, 3, "")
+	owner := transport.Processors[0]
+
+	write(t, s, owner, 1, "a")
+	steps := s.Steps()
+	write(t, s, owner, 1, "b")
+	if s.Steps() != steps+1 {
+		t.Errorf("second write took %v events, want it done locally in 1", s.Steps()-steps)
+	}
+	if got := read(t, s, transport.Processors[1], 1); got != "b" {
+		t.Errorf("read %q, want %q", got, "b")
+	}
+}
+
+func TestLateCopyDoesNotCompleteWrite(t *testing.T) {
+	s, transport := newSimCluster(t, 1, 2, 3, "")
+	reader, writer := transport.Processors[0], transport.Processors[1]
+	write(t, s, writer, 0, "a")
+	if got := read(t, s, reader, 0); got != "a" {
+		t.Fatalf("read %q, want %q", got, "a")
+	}
+
+	// the reader's write is on its way to the CM when a duplicate of the copy it already read arrives
+	op := operation{Type: WRITE_REQUEST, PageId: 0, Value: []byte("b"), Result: make(chan operationResult, 1)}
+	reader.HandleOperation(op)
+	reader.HandleMessage(Message{Sender: writer.Id, Type: PAGE_COPY_FORWARD, PageId: 0, Content: []byte("a")})
+	select {
+	case <-op.Result:
+		t.Fatal("a late copy of the page completed the write")
+	default:
+	}
+	if reader.RequestMap[0].State != PENDING_WRITE_COMPLETION {
+		t.Errorf("write request state is %v after a late copy, want it still pending", reader.RequestMap[0].State)
+	}
+
+	done := func() bool { return len(op.Result) > 0 }
+	if !s.RunUntil(done, time.Minute) {
+		t.Fatal("the write never completed")
+	}
+	if got := read(t, s, writer, 0); got != "b" {
+		t.Errorf("writer read %q after the reader's write, want %q", got, "b")
+	}
+}
+
+func TestHistoryFollowsVirtualTime(t *testing.T) {
+	/**
+	Two runs of the same seed have to record the same history, stamped with the simulator's time
+	*/
+	histories := []string{}
+	for run := 0; run < 2; run++ {
+		s, transport := newSimCluster(t, 1, 2, 3, "")
+		path := filepath.Join(t.TempDir(), "history.jsonl")
+		history, err := NewHistory(path)
+		if err != nil {
+			t.Fatal(err)
+		}
+		for _, p := range transport.Processors {
+			p.History = history
+		}
+		write(t, s, transport.Processors[0], 0, "a")
+		read(t, s, transport.Processors[1], 0)
+		history.Close()
+
+		events, err := LoadHistory(path)
+		if err != nil {
+			t.Fatal(err)
+		}
+		last := events[len(events)-1].Time
+		if last != s.Now().UnixNano() {
+			t.Errorf("run %v: last event at %v, want the simulator's time %v", run, last, s.Now().UnixNano())
+		}
+		contents, err := os.ReadFile(path)
+		if err != nil {
+			t.Fatal(err)
+		}
+		histories = append(histories, string(contents))
+	}
+	if histories[0] != histories[1] {
+		t.Errorf("two runs of the same seed recorded different histories:\n%v\n%v", histories[0], histories[1])
+	}
+}
@@ -0,0 +1,687 @@
+package lib
+
+import (
+	"distsys/common/sim"
+	"distsys/common/trace"
+	"encoding/json"
+	"time"
+)
+
+const RAFT_TICK = 50 * time.Millisecond
+const RAFT_HEARTBEAT_INTERVAL = 200 * time.Millisecond
+const RAFT_ELECTION_TIMEOUT_MIN = 1 * time.Second
+const RAFT_ELECTION_TIMEOUT_MAX = 2 * time.Second
+const RAFT_MAX_ENTRIES_PER_APPEND = 100
+const RAFT_MAX_LOG_ENTRIES = 1000 // committed entries kept in memory before they are folded into the base state
+const REPLICA_OUTBOX_SIZE = 1000
+
+type RaftRole int
+
+const (
+	FOLLOWER RaftRole = iota
+	CANDIDATE
+	LEADER
+)
+
+var RAFT_ROLES []string = []string{
+	"FOLLOWER",
+	"CANDIDATE",
+	"LEADER",
+}
+
+// LogEntry is one batch of StateOps made by the leader while handling a single message.
+// A new leader appends an entry without ops to commit everything from earlier terms.
+type LogEntry struct {
+	Term int64
+	Ops  []StateOp
+}
+
+// RaftMessage holds the Raft fields of a message between CMs
+type RaftMessage struct {
+	LastLogIndex  int   // REQUEST_VOTE
+	LastLogTerm   int64 // REQUEST_VOTE
+	PrevLogIndex  int   // APPEND_ENTRIES
+	PrevLogTerm   int64 // APPEND_ENTRIES
+	LeaderCommit  int   // APPEND_ENTRIES
+	SnapshotIndex int   // FORWARD_STATE: index of the last entry folded into the state
+	SnapshotTerm  int64 // FORWARD_STATE
+	Success       bool  // VOTE_REPLY: vote granted, APPEND_REPLY: entries accepted
+	MatchIndex    int   // APPEND_REPLY: on success the last index the follower has, else the last index worth retrying from
+}
+
+// Raft is a CM's view of the replicated log
+type Raft struct {
+	Role        RaftRole
+	CurrentTerm int64
+	VotedFor    int // -1 if no vote this term
+	LeaderId    int // -1 if unknown
+
+	Log           []LogEntry // Log[i] has index SnapshotIndex+1+i, index 0 is the empty log
+	SnapshotIndex int
+	SnapshotTerm  int64
+	BaseState     State // the state after applying every entry up to SnapshotIndex
+	CommitIndex   int
+	LastApplied   int
+
+	// leader only
+	ReadyIndex int  // the entry appended on election, requests are only handled once it commits
+	Ready      bool // ReadyIndex has committed
+	NextIndex  map[int]int
+	MatchIndex map[int]int
+	Outbox     []outboxMessage // messages to processors waiting for their entry to commit
+
+	// candidate only
+	Votes map[int]bool
+
+	ElectionDeadline time.Time
+	NextHeartbeat    time.Time
+}
+
+type outboxMessage struct {
+	Index       int // sent once this entry commits
+	ProcessorId int
+	Message     Message
+}
+
+func (cm *CentralManager) StartRaft(now time.Time) {
+	/**
+	Every CM starts as a follower. The base state is the snapshot the CM recovered, see RestoreRaft,
+	entries that were already folded into it are skipped when they are sent again since their ops' Seq are already applied
+	*/
+	cm.Raft.Role = FOLLOWER
+	cm.Raft.LeaderId = -1
+	if cm.Raft.CurrentTerm == 0 {
+		cm.Raft.VotedFor = -1
+	}
+	cm.Raft.BaseState, _ = cm.CurrentState.Clone()
+	cm.IsPrimary = false
+	cm.ResetElectionDeadline(now)
+}
+
+// RestoreRaft puts back the log a CM saved in its write-ahead log before it died. Only the snapshot is known to have
+// committed, the entries after it are applied again once a leader says they have
+func (cm *CentralManager) RestoreRaft(saved SavedLog) {
+	cm.CurrentState = saved.State
+	cm.Raft.SnapshotIndex = saved.SnapshotIndex
+	cm.Raft.SnapshotTerm = saved.SnapshotTerm
+	cm.Raft.Log = saved.Entries
+	cm.Raft.CommitIndex = saved.SnapshotIndex
+	cm.Raft.LastApplied = saved.SnapshotIndex
+}
+
+func (cm *CentralManager) ResetElectionDeadline(now time.Time) {
+	timeout := RAFT_ELECTION_TIMEOUT_MIN + time.Duration(cm.Runtime.Int63n(int64(RAFT_ELECTION_TIMEOUT_MAX-RAFT_ELECTION_TIMEOUT_MIN)))
+	cm.Raft.ElectionDeadline = now.Add(timeout)
+}
+
+func (cm *CentralManager) Tick(now time.Time) {
+	/**
+	Leader: send heartbeats (and any entries a follower is missing) every RAFT_HEARTBEAT_INTERVAL
+	Others: start an election if nothing was heard from a leader before the deadline
+	*/
+	if cm.Raft.Role == LEADER {
+		if now.Before(cm.Raft.NextHeartbeat) {
+			return
+		}
+		cm.Raft.NextHeartbeat = now.Add(RAFT_HEARTBEAT_INTERVAL)
+		cm.BroadcastAppendEntries()
+		return
+	}
+	if now.After(cm.Raft.ElectionDeadline) {
+		cm.StartRaftElection(now)
+	}
+}
+
+func (cm *CentralManager) StartRaftElection(now time.Time) {
+	cm.Raft.Role = CANDIDATE
+	cm.Raft.CurrentTerm++
+	cm.Raft.VotedFor = cm.Id
+	cm.Raft.LeaderId = -1
+	cm.Raft.Votes = map[int]bool{cm.Id: true}
+	cm.persistRaftState()
+	cm.ResetElectionDeadline(now)
+	cm.log(false, "starting election for term %v", cm.Raft.CurrentTerm)
+	cm.Tracer.Begin("election", trace.NO_PAGE)
+	cm.Metrics.ElectionStarted(cm.Id)
+
+	if cm.hasMajority(len(cm.Raft.Votes)) {
+		cm.BecomeLeader(now)
+		return
+	}
+	lastIndex := cm.lastLogIndex()
+	for _, i := range cm.RaftPeers() {
+		if i == cm.Id {
+			continue
+		}
+		cm.sendToReplica(i, Message{Sender: cm.Id, Type: REQUEST_VOTE, Term: cm.Raft.CurrentTerm,
+			Raft: &RaftMessage{LastLogIndex: lastIndex, LastLogTerm: cm.termAt(lastIndex)}})
+	}
+}
+
+func (cm *CentralManager) BecomeLeader(now time.Time) {
+	/**
+	1. Assume every follower has the whole log until told otherwise
+	2. Append an entry without ops, committing it also commits every entry from earlier terms
+	3. Requests from processors are only handled once that entry commits (see applyCommitted)
+	*/
+	cm.log(false, "elected as leader for term %v", cm.Raft.CurrentTerm)
+	cm.Tracer.Local("elected", trace.NO_PAGE)
+	cm.Metrics.Leader(cm.Id, true)
+	cm.Raft.Role = LEADER
+	cm.Raft.LeaderId = cm.Id
+	cm.Raft.NextIndex = map[int]int{}
+	cm.Raft.MatchIndex = map[int]int{}
+	for _, i := range cm.RaftPeers() {
+		cm.Raft.NextIndex[i] = cm.lastLogIndex() + 1
+	}
+	cm.Raft.Log = append(cm.Raft.Log, LogEntry{Term: cm.Raft.CurrentTerm})
+	cm.persistEntries(cm.lastLogIndex())
+	cm.Raft.ReadyIndex = cm.lastLogIndex()
+	cm.Raft.Ready = false
+	cm.Raft.Outbox = nil
+	cm.PendingOps = nil
+	cm.pendingOutbox = nil
+	cm.IsPrimary = true
+
+	cm.Raft.NextHeartbeat = now.Add(RAFT_HEARTBEAT_INTERVAL)
+	cm.BroadcastAppendEntries()
+	cm.AdvanceCommitIndex()
+}
+
+func (cm *CentralManager) StepDown(term int64, now time.Time) {
+	/**
+	1. Adopt the newer term
+	2. A leader throws away the ops it made that haven't committed by rebuilding its state from the committed entries,
+	messages waiting on them are never sent - processors time out and ask the new leader again
+	*/
+	if term > cm.Raft.CurrentTerm {
+		cm.Raft.CurrentTerm = term
+		cm.Raft.VotedFor = -1
+		cm.persistRaftState()
+	}
+	if cm.Raft.Role == LEADER {
+		cm.log(false, "stepping down in term %v", cm.Raft.CurrentTerm)
+		cm.RebuildState()
+		cm.Raft.Outbox = nil
+		cm.PendingOps = nil
+		cm.pendingOutbox = nil
+	}
+	cm.Raft.Role = FOLLOWER
+	cm.Raft.Ready = false
+	cm.IsPrimary = false
+	cm.Metrics.Leader(cm.Id, false)
+	cm.ResetElectionDeadline(now)
+}
+
+func (cm *CentralManager) RebuildState() {
+	state, err := cm.Raft.BaseState.Clone()
+	if err != nil {
+		cm.log(false, "Serialize Error: %v", err)
+		return
+	}
+	for index := cm.Raft.SnapshotIndex + 1; index <= cm.Raft.CommitIndex; index++ {
+		applyOps(&state, cm.logEntry(index).Ops)
+	}
+	cm.CurrentState = state
+	cm.Raft.LastApplied = cm.Raft.CommitIndex
+	cm.recordQueueLengths()
+}
+
+func (cm *CentralManager) HandleRaftMessage(m Message, now time.Time) {
+	if m.Term > cm.Raft.CurrentTerm {
+		cm.StepDown(m.Term, now)
+	}
+
+	switch m.Type {
+	case REQUEST_VOTE:
+		cm.HandleRequestVote(m, now)
+	case VOTE_REPLY:
+		cm.HandleVoteReply(m, now)
+	case APPEND_ENTRIES:
+		cm.HandleAppendEntries(m, now)
+	case APPEND_REPLY:
+		cm.HandleAppendReply(m)
+	case FORWARD_STATE:
+		cm.HandleForwardState(m, now)
+	}
+}
+
+func (cm *CentralManager) HandleRequestVote(m Message, now time.Time) {
+	/**
+	Vote for the candidate if this CM hasn't voted for anyone else this term
+	and the candidate's log is at least as up to date as this CM's
+	*/
+	lastIndex := cm.lastLogIndex()
+	lastTerm := cm.termAt(lastIndex)
+	upToDate := m.Raft.LastLogTerm > lastTerm || (m.Raft.LastLogTerm == lastTerm && m.Raft.LastLogIndex >= lastIndex)
+
+	granted := m.Term == cm.Raft.CurrentTerm && (cm.Raft.VotedFor == -1 || cm.Raft.VotedFor == m.Sender) && upToDate
+	if granted {
+		cm.Raft.VotedFor = m.Sender
+		cm.persistRaftState()
+		cm.ResetElectionDeadline(now)
+		cm.log(true, "voted for CM %v in term %v", m.Sender, m.Term)
+	}
+	cm.sendToReplica(m.Sender, Message{Sender: cm.Id, Type: VOTE_REPLY, Term: cm.Raft.CurrentTerm, Raft: &RaftMessage{Success: granted}})
+}
+
+func (cm *CentralManager) HandleVoteReply(m Message, now time.Time) {
+	if cm.Raft.Role != CANDIDATE || m.Term != cm.Raft.CurrentTerm || !m.Raft.Success {
+		return
+	}
+	cm.Raft.Votes[m.Sender] = true
+	if cm.hasMajority(len(cm.Raft.Votes)) {
+		cm.BecomeLeader(now)
+	}
+}
+
+func (cm *CentralManager) HandleAppendEntries(m Message, now time.Time) {
+	/**
+	1. Reject a leader from an old term
+	2. Reject if the entry before the new ones doesn't match, telling the leader where to retry from
+	3. Drop any entries that conflict with the new ones and append the rest
+	4. Persist what changed before acknowledging it, the leader counts this CM towards a majority once it has
+	5. Apply whatever the leader says is committed
+	*/
+	if m.Term < cm.Raft.CurrentTerm {
+		cm.sendAppendReply(m.Sender, false, cm.lastLogIndex())
+		return
+	}
+	if cm.Raft.Role != FOLLOWER {
+		cm.StepDown(m.Term, now)
+	}
+	cm.Raft.LeaderId = m.Sender
+	cm.ResetElectionDeadline(now)
+
+	prevIndex := m.Raft.PrevLogIndex
+	if prevIndex > cm.lastLogIndex() {
+		cm.sendAppendReply(m.Sender, false, cm.lastLogIndex())
+		return
+	}
+	if prevIndex >= cm.Raft.SnapshotIndex && cm.termAt(prevIndex) != m.Raft.PrevLogTerm {
+		cm.sendAppendReply(m.Sender, false, prevIndex-1)
+		return
+	}
+
+	entries := []LogEntry{}
+	if err := json.Unmarshal(m.State, &entries); err != nil {
+		cm.log(false, "Unmarshal Error: %v", err)
+		return
+	}
+	firstChanged := 0
+	for i, entry := range entries {
+		index := prevIndex + 1 + i
+		if index <= cm.Raft.SnapshotIndex {
+			// already folded into the base state
+			continue
+		}
+		if index <= cm.lastLogIndex() {
+			if cm.termAt(index) == entry.Term {
+				continue
+			}
+			cm.Raft.Log = cm.Raft.Log[:index-cm.Raft.SnapshotIndex-1]
+		}
+		cm.Raft.Log = append(cm.Raft.Log, entry)
+		if firstChanged == 0 {
+			firstChanged = index
+		}
+	}
+	if firstChanged != 0 && !cm.persistEntries(firstChanged) {
+		// the leader sends them again
+		return
+	}
+
+	lastNewIndex := prevIndex + len(entries)
+	if m.Raft.LeaderCommit > cm.Raft.CommitIndex {
+		cm.Raft.CommitIndex = m.Raft.LeaderCommit
+		if lastNewIndex < cm.Raft.CommitIndex {
+			cm.Raft.CommitIndex = lastNewIndex
+		}
+		cm.applyCommitted()
+	}
+	cm.sendAppendReply(m.Sender, true, lastNewIndex)
+}
+
+func (cm *CentralManager) HandleAppendReply(m Message) {
+	if cm.Raft.Role != LEADER || m.Term != cm.Raft.CurrentTerm {
+		return
+	}
+	if m.Raft.Success {
+		if m.Raft.MatchIndex > cm.Raft.MatchIndex[m.Sender] {
+			cm.Raft.MatchIndex[m.Sender] = m.Raft.MatchIndex
+		}
+		cm.Raft.NextIndex[m.Sender] = cm.Raft.MatchIndex[m.Sender] + 1
+		cm.AdvanceCommitIndex()
+		return
+	}
+
+	// back off and try again straight away
+	nextIndex := cm.Raft.NextIndex[m.Sender] - 1
+	if m.Raft.MatchIndex+1 < nextIndex {
+		nextIndex = m.Raft.MatchIndex + 1
+	}
+	if nextIndex < 1 {
+		nextIndex = 1
+	}
+	cm.Raft.NextIndex[m.Sender] = nextIndex
+	cm.SendAppendEntries(m.Sender)
+}
+
+func (cm *CentralManager) HandleForwardState(m Message, now time.Time) {
+	/**
+	The leader has already folded the entries this CM is missing into its base state, so it sent that instead.
+	Replace the base state and drop the log up to it, keeping any later entries that still match.
+	*/
+	if m.Term < cm.Raft.CurrentTerm {
+		cm.sendAppendReply(m.Sender, false, cm.lastLogIndex())
+		return
+	}
+	if cm.Raft.Role != FOLLOWER {
+		cm.StepDown(m.Term, now)
+	}
+	cm.Raft.LeaderId = m.Sender
+	cm.ResetElectionDeadline(now)
+
+	snapshotIndex := m.Raft.SnapshotIndex
+	if snapshotIndex <= cm.Raft.CommitIndex {
+		cm.sendAppendReply(m.Sender, true, snapshotIndex)
+		return
+	}
+
+	state := NewState()
+	if err := json.Unmarshal(m.State, &state); err != nil {
+		cm.log(false, "Unmarshal Error: %v", err)
+		return
+	}
+	if snapshotIndex < cm.lastLogIndex() && cm.termAt(snapshotIndex) == m.Raft.SnapshotTerm {
+		cm.Raft.Log = cm.Raft.Log[snapshotIndex-cm.Raft.SnapshotIndex:]
+	} else {
+		cm.Raft.Log = nil
+	}
+	cm.Raft.SnapshotIndex = snapshotIndex
+	cm.Raft.SnapshotTerm = m.Raft.SnapshotTerm
+	cm.Raft.BaseState = state
+	cm.Raft.CommitIndex = snapshotIndex
+	cm.RebuildState()
+	cm.log(true, "currentstate set to:  %v", cm.CurrentState)
+
+	// the whole state was replaced, so persist it as a snapshot rather than as entries
+	if !cm.persistSnapshot() {
+		return
+	}
+	cm.sendAppendReply(m.Sender, true, snapshotIndex)
+}
+
+func (cm *CentralManager) ForwardState() {
+	/**
+	Called after the leader handles a message:
+	1. The StateOps it made become one new log entry
+	2. The messages it wants to send to processors wait until that entry (or the last one, if it made no ops) commits
+	3. The entry is sent to the followers straight away rather than on the next heartbeat
+	*/
+	if cm.Raft.Role != LEADER {
+		return
+	}
+	if len(cm.PendingOps) > 0 {
+		cm.Raft.Log = append(cm.Raft.Log, LogEntry{Term: cm.Raft.CurrentTerm, Ops: cm.PendingOps})
+		cm.persistEntries(cm.lastLogIndex())
+		cm.PendingOps = nil
+		cm.BroadcastAppendEntries()
+	}
+	for _, outgoing := range cm.pendingOutbox {
+		outgoing.Index = cm.lastLogIndex()
+		cm.Raft.Outbox = append(cm.Raft.Outbox, outgoing)
+	}
+	cm.pendingOutbox = nil
+	cm.AdvanceCommitIndex()
+}
+
+func (cm *CentralManager) BroadcastAppendEntries() {
+	for _, i := range cm.RaftPeers() {
+		if i == cm.Id {
+			continue
+		}
+		cm.SendAppendEntries(i)
+	}
+}
+
+func (cm *CentralManager) SendAppendEntries(followerId int) {
+	/**
+	Sends the follower up to RAFT_MAX_ENTRIES_PER_APPEND entries starting at its NextIndex,
+	or the base state if those entries have been folded into it
+	*/
+	prevIndex := cm.Raft.NextIndex[followerId] - 1
+	if prevIndex < cm.Raft.SnapshotIndex {
+		stateBytes, err := json.Marshal(cm.Raft.BaseState)
+		if err != nil {
+			cm.log(false, "Serialize Error: %v", err)
+			return
+		}
+		cm.log(true, "sending base state at index %v to CM %v", cm.Raft.SnapshotIndex, followerId)
+		cm.Metrics.StateForwarded(cm.Id, FORWARD_STATE, len(stateBytes))
+		cm.sendToReplica(followerId, Message{Sender: cm.Id, Type: FORWARD_STATE, Term: cm.Raft.CurrentTerm, State: stateBytes,
+			Raft: &RaftMessage{SnapshotIndex: cm.Raft.SnapshotIndex, SnapshotTerm: cm.Raft.SnapshotTerm}})
+		return
+	}
+
+	lastIndex := cm.lastLogIndex()
+	if lastIndex > prevIndex+RAFT_MAX_ENTRIES_PER_APPEND {
+		lastIndex = prevIndex + RAFT_MAX_ENTRIES_PER_APPEND
+	}
+	// entries are sent encoded so followers never share slices with the leader's log
+	entriesBytes, err := json.Marshal(cm.Raft.Log[prevIndex-cm.Raft.SnapshotIndex : lastIndex-cm.Raft.SnapshotIndex])
+	if err != nil {
+		cm.log(false, "Serialize Error: %v", err)
+		return
+	}
+	cm.Metrics.StateForwarded(cm.Id, APPEND_ENTRIES, len(entriesBytes))
+	cm.sendToReplica(followerId, Message{Sender: cm.Id, Type: APPEND_ENTRIES, Term: cm.Raft.CurrentTerm, State: entriesBytes,
+		Raft: &RaftMessage{PrevLogIndex: prevIndex, PrevLogTerm: cm.termAt(prevIndex), LeaderCommit: cm.Raft.CommitIndex}})
+}
+
+func (cm *CentralManager) AdvanceCommitIndex() {
+	/**
+	An entry from the current term is committed once a majority of CMs have it,
+	which commits every entry before it too
+	*/
+	for index := cm.lastLogIndex(); index > cm.Raft.CommitIndex; index-- {
+		if cm.termAt(index) != cm.Raft.CurrentTerm {
+			break
+		}
+		replicas := 1
+		for i, matchIndex := range cm.Raft.MatchIndex {
+			if i != cm.Id && matchIndex >= index {
+				replicas++
+			}
+		}
+		if cm.hasMajority(replicas) {
+			cm.Raft.CommitIndex = index
+			break
+		}
+	}
+	cm.applyCommitted()
+}
+
+func (cm *CentralManager) applyCommitted() {
+	/**
+	1. Apply newly committed entries to CurrentState - except the leader's own entries, it applied those while making them
+	2. A new leader starts handling requests once its first entry commits
+	3. Send the messages that were waiting on them
+	4. Fold old entries into the base state
+	*/
+	for cm.Raft.LastApplied < cm.Raft.CommitIndex {
+		cm.Raft.LastApplied++
+		entry := cm.logEntry(cm.Raft.LastApplied)
+		if cm.Raft.Role != LEADER || entry.Term != cm.Raft.CurrentTerm {
+			applyOps(&cm.CurrentState, entry.Ops)
+			cm.recordQueueLengths()
+		}
+	}
+
+	if cm.Raft.Role != LEADER {
+		return
+	}
+	if !cm.Raft.Ready && cm.Raft.CommitIndex >= cm.Raft.ReadyIndex {
+		cm.Raft.Ready = true
+		cm.HandleElect()
+	}
+
+	sent := 0
+	for _, outgoing := range cm.Raft.Outbox {
+		if outgoing.Index > cm.Raft.CommitIndex {
+			break
+		}
+		cm.Transport.SendToProcessor(outgoing.ProcessorId, outgoing.Message)
+		sent++
+	}
+	cm.Raft.Outbox = cm.Raft.Outbox[sent:]
+
+	cm.CompactLog()
+}
+
+func (cm *CentralManager) CompactLog() {
+	/**
+	Fold the applied entries into the base state once there are too many of them, or the write-ahead log is due a snapshot.
+	The base state only ever holds committed ops, so it is also what the write-ahead log snapshots.
+	*/
+	applied := cm.Raft.LastApplied - cm.Raft.SnapshotIndex
+	if applied == 0 || (applied < RAFT_MAX_LOG_ENTRIES && (cm.WAL == nil || !cm.WAL.SnapshotDue())) {
+		return
+	}
+	for index := cm.Raft.SnapshotIndex + 1; index <= cm.Raft.LastApplied; index++ {
+		applyOps(&cm.Raft.BaseState, cm.logEntry(index).Ops)
+	}
+	cm.Raft.SnapshotTerm = cm.termAt(cm.Raft.LastApplied)
+	cm.Raft.Log = cm.Raft.Log[applied:]
+	cm.Raft.SnapshotIndex = cm.Raft.LastApplied
+	cm.persistSnapshot()
+}
+
+// ReplicaInSync is true if the follower has every entry in the leader's log
+func (cm *CentralManager) ReplicaInSync(replicaId int) bool {
+	return cm.Raft.MatchIndex[replicaId] == cm.lastLogIndex()
+}
+
+func (cm *CentralManager) sendToProcessor(processorId int, m Message) {
+	/**
+	Messages a leader sends to processors while handling a message are held back until
+	the state changes made alongside them have committed, see ForwardState
+	*/
+	// the term fences the message, processors ignore it once they've heard from a newer leader
+	m.Term = cm.Raft.CurrentTerm
+	cm.pendingOutbox = append(cm.pendingOutbox, outboxMessage{ProcessorId: processorId, Message: m})
+}
+
+func (cm *CentralManager) sendAppendReply(leaderId int, success bool, matchIndex int) {
+	cm.sendToReplica(leaderId, Message{Sender: cm.Id, Type: APPEND_REPLY, Term: cm.Raft.CurrentTerm,
+		Raft: &RaftMessage{Success: success, MatchIndex: matchIndex}})
+}
+
+func (cm *CentralManager) sendToReplica(replicaId int, m Message) {
+	/**
+	Every CM has its own outbox drained by one goroutine, so messages arrive in the order they were sent
+	without ever blocking on a slow or dead CM. If the outbox is full the message is dropped, Raft sends it again.
+	*/
+	if _, simulated := cm.Runtime.(*sim.Simulator); simulated {
+		// simulated sends never block, and have to stay on the simulator's one thread
+		cm.Transport.SendConfirmationToCM(replicaId, m)
+		return
+	}
+	if cm.replicaOutbox == nil {
+		cm.replicaOutbox = make([]chan Message, cm.Transport.NumOfCentralManagers())
+	}
+	if cm.replicaOutbox[replicaId] == nil {
+		outbox := make(chan Message, REPLICA_OUTBOX_SIZE)
+		cm.replicaOutbox[replicaId] = outbox
+		go func() {
+			for m := range outbox {
+				cm.Transport.SendConfirmationToCM(replicaId, m)
+			}
+		}()
+	}
+
+	select {
+	case cm.replicaOutbox[replicaId] <- m:
+	default:
+		cm.log(false, "outbox to CM %v full, dropping %v", replicaId, m.Type.toString())
+	}
+}
+
+func (cm *CentralManager) persistRaftState() {
+	if cm.WAL == nil {
+		return
+	}
+	if err := cm.WAL.SaveVote(cm.Raft.CurrentTerm, cm.Raft.VotedFor); err != nil {
+		cm.log(false, "WAL vote error: %v", err)
+	}
+}
+
+// persistEntries writes the entries from firstIndex on to the write-ahead log, false if they couldn't be written
+func (cm *CentralManager) persistEntries(firstIndex int) bool {
+	if cm.WAL == nil {
+		return true
+	}
+	if err := cm.WAL.Append(firstIndex, cm.Raft.Log[firstIndex-cm.Raft.SnapshotIndex-1:]); err != nil {
+		cm.log(false, "WAL append error: %v", err)
+		return false
+	}
+	return true
+}
+
+// persistSnapshot replaces the write-ahead log with the base state and the entries after it
+func (cm *CentralManager) persistSnapshot() bool {
+	if cm.WAL == nil {
+		return true
+	}
+	saved := SavedLog{State: cm.Raft.BaseState, SnapshotIndex: cm.Raft.SnapshotIndex, SnapshotTerm: cm.Raft.SnapshotTerm, Entries: cm.Raft.Log}
+	if err := cm.WAL.Snapshot(saved); err != nil {
+		cm.log(false, "WAL snapshot error: %v", err)
+		return false
+	}
+	return true
+}
+
+func (cm *CentralManager) hasMajority(count int) bool {
+	return count > len(cm.RaftPeers())/2
+}
+
+// RaftPeers returns the CMs in this CM's Raft group, itself included. Every CM is in one group unless pages are sharded
+func (cm *CentralManager) RaftPeers() []int {
+	if cm.Peers != nil {
+		return cm.Peers
+	}
+	peers := make([]int, cm.Transport.NumOfCentralManagers())
+	for i := range peers {
+		peers[i] = i
+	}
+	return peers
+}
+
+func (cm *CentralManager) lastLogIndex() int {
+	return cm.Raft.SnapshotIndex + len(cm.Raft.Log)
+}
+
+// termAt returns the term of the entry at index, or -1 if it has been folded into the base state
+func (cm *CentralManager) termAt(index int) int64 {
+	if index == cm.Raft.SnapshotIndex {
+		return cm.Raft.SnapshotTerm
+	}
+	if index < cm.Raft.SnapshotIndex {
+		return -1
+	}
+	return cm.Raft.Log[index-cm.Raft.SnapshotIndex-1].Term
+}
+
+func (cm *CentralManager) logEntry(index int) LogEntry {
+	return cm.Raft.Log[index-cm.Raft.SnapshotIndex-1]
+}
+
+// applyOps applies ops to s, skipping any it already has
+func applyOps(s *State, ops []StateOp) {
+	for _, op := range ops {
+		if op.Seq <= s.Seq {
+			continue
+		}
+		s.Apply(op)
+	}
+}
@@ -0,0 +1,141 @@
+package lib
+
+import (
+	"encoding/json"
+	"fmt"
+	"testing"
+	"time"
+)
+
+// leaderOf returns the one CM leading the highest term, failing the test if there isn't exactly one
+func leaderOf(t *testing.T, transport *SimTransport) *CentralManager {
+	t.Helper()
+	var leader *CentralManager
+	for _, cm := range transport.CentralManagers {
+		if !cm.IsAlive || cm.Raft.Role != LEADER {
+			continue
+		}
+		if leader == nil || cm.Raft.CurrentTerm > leader.Raft.CurrentTerm {
+			leader = cm
+		} else if cm.Raft.CurrentTerm == leader.Raft.CurrentTerm {
+			t.Fatalf("CMs %v and %v both lead term %v", leader.Id, cm.Id, cm.Raft.CurrentTerm)
+		}
+	}
+	if leader == nil {
+		t.Fatalf("no leader")
+	}
+	return leader
+}
+
+func TestRaftElectsOneLeader(t *testing.T) {
+	for seed := int64(1); seed <= 10; seed++ {
+		s, transport := newSimCluster(t, seed, 1, 3, "")
+		s.Run(10 * time.Second)
+
+		leader := leaderOf(t, transport)
+		if !leader.IsPrimary || !leader.Raft.Ready {
+			t.Errorf("seed %v: leader %v isn't serving requests", seed, leader.Id)
+		}
+		for _, cm := range transport.CentralManagers {
+			if cm.Raft.CurrentTerm != leader.Raft.CurrentTerm || cm.Raft.LeaderId != leader.Id {
+				t.Errorf("seed %v: CM %v is in term %v following %v, want term %v following %v",
+					seed, cm.Id, cm.Raft.CurrentTerm, cm.Raft.LeaderId, leader.Raft.CurrentTerm, leader.Id)
+			}
+		}
+	}
+}
+
+func TestRaftReplicatesWrites(t *testing.T) {
+	for seed := int64(1); seed <= 5; seed++ {
+		s, transport := newSimCluster(t, seed, 4, 3, "")
+		for i, p := range transport.Processors {
+			write(t, s, p, i, fmt.Sprint(i))
+		}
+		// a heartbeat tells the followers the last entries committed
+		s.Run(time.Second)
+
+		leader := leaderOf(t, transport)
+		for _, cm := range transport.CentralManagers {
+			if cm.lastLogIndex() != leader.lastLogIndex() || cm.Raft.CommitIndex != leader.Raft.CommitIndex {
+				t.Errorf("seed %v: CM %v has %v entries, %v committed, leader %v has %v, %v committed",
+					seed, cm.Id, cm.lastLogIndex(), cm.Raft.CommitIndex, leader.Id, leader.lastLogIndex(), leader.Raft.CommitIndex)
+			}
+			for pageId := range transport.Processors {
+				if owner := cm.CurrentState.Entries[pageId].Owner; owner != pageId {
+					t.Errorf("seed %v: CM %v has page %v owned by %v, want %v", seed, cm.Id, pageId, owner, pageId)
+				}
+			}
+		}
+	}
+}
+
+func TestRaftRestartKeepsLog(t *testing.T) {
+	/**
+	Every CM restarts from its write-ahead log at once, so nothing is left in memory: the entries every CM had
+	acknowledged have to come back from disk, and a new leader has to pick up the pages where the old one left off
+	*/
+	for seed := int64(1); seed <= 5; seed++ {
+		walDir := t.TempDir()
+		s, transport := newSimCluster(t, seed, 4, 3, walDir)
+		for round := 0; round < 3; round++ {
+			for i, p := range transport.Processors {
+				write(t, s, p, (i+round)%len(transport.Processors), fmt.Sprint(round))
+			}
+		}
+		s.Run(time.Second)
+
+		before := map[int]int{} // {[cmId]: last log index}
+		for _, cm := range transport.CentralManagers {
+			before[cm.Id] = cm.lastLogIndex()
+			cm.ReallyDie()
+		}
+		for i, old := range transport.CentralManagers {
+			cm := newSimCM(t, s, transport, i, walDir)
+			if cm.lastLogIndex() != before[i] || cm.termAt(before[i]) != old.termAt(before[i]) {
+				t.Errorf("seed %v: CM %v came back with %v entries, had %v", seed, i, cm.lastLogIndex(), before[i])
+			}
+			if cm.Raft.CurrentTerm != old.Raft.CurrentTerm || cm.Raft.VotedFor != old.Raft.VotedFor {
+				t.Errorf("seed %v: CM %v came back in term %v voted for %v, was term %v voted for %v",
+					seed, i, cm.Raft.CurrentTerm, cm.Raft.VotedFor, old.Raft.CurrentTerm, old.Raft.VotedFor)
+			}
+			transport.CentralManagers[i] = cm
+			cm.Init()
+		}
+
+		for i, p := range transport.Processors {
+			pageId := (i + 1) % len(transport.Processors)
+			if got := read(t, s, p, pageId); got != "2" {
+				t.Errorf("seed %v: processor %v read %q from page %v after the restart, want %q", seed, i, got, pageId, "2")
+			}
+		}
+		leader := leaderOf(t, transport)
+		for pageId := range transport.Processors {
+			want := (pageId + len(transport.Processors) - 2) % len(transport.Processors)
+			if owner := leader.CurrentState.Entries[pageId].Owner; owner != want {
+				t.Errorf("seed %v: leader %v has page %v owned by %v after the restart, want %v", seed, leader.Id, pageId, owner, want)
+			}
+		}
+	}
+}
+
+func TestForwardStateStepsCandidateDown(t *testing.T) {
+	s, transport := newSimCluster(t, 1, 1, 3, "")
+	cm := transport.CentralManagers[1]
+	cm.StartRaftElection(s.Now())
+	if cm.Raft.Role != CANDIDATE {
+		t.Fatalf("CM 1 is %v after starting an election", RAFT_ROLES[cm.Raft.Role])
+	}
+
+	stateBytes, err := json.Marshal(NewState())
+	if err != nil {
+		t.Fatal(err)
+	}
+	cm.HandleRaftMessage(Message{Sender: 0, Type: FORWARD_STATE, Term: cm.Raft.CurrentTerm, State: stateBytes,
+		Raft: &RaftMessage{SnapshotIndex: 5, SnapshotTerm: cm.Raft.CurrentTerm}}, s.Now())
+	if cm.Raft.Role != FOLLOWER || cm.Raft.LeaderId != 0 {
+		t.Errorf("CM 1 is %v following %v after the leader's state, want FOLLOWER following 0", RAFT_ROLES[cm.Raft.Role], cm.Raft.LeaderId)
+	}
+	if cm.Raft.SnapshotIndex != 5 {
+		t.Errorf("CM 1 has snapshot index %v, want 5", cm.Raft.SnapshotIndex)
+	}
+}
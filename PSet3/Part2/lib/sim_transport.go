@@ -0,0 +1,62 @@
+package lib
+
+import "distsys/common/sim"
+
+// SimTransport delivers messages through a Simulator: one at a time, after a latency picked by the simulator's seed.
+// There are no inboxes, a delivery calls the receiver's handler straight away, so the nodes are never Started.
+// Every processor inbox, CM request inbox and CM confirmation inbox has its own simulator address
+type SimTransport struct {
+	Sim             *sim.Simulator
+	Processors      []*Processor
+	CentralManagers []*CentralManager
+}
+
+func NewSimTransport(s *sim.Simulator, numOfProcessors int, numOfCentralManagers int) *SimTransport {
+	return &SimTransport{
+		Sim:             s,
+		Processors:      make([]*Processor, numOfProcessors),
+		CentralManagers: make([]*CentralManager, numOfCentralManagers),
+	}
+}
+
+func (t *SimTransport) SendToProcessor(processorId int, m Message) {
+	t.Sim.Deliver(processorId, func() {
+		t.Processors[processorId].HandleMessage(m)
+	})
+}
+
+func (t *SimTransport) SendToCM(cmId int, m Message) {
+	t.Sim.Deliver(t.NumOfProcessors()+cmId, func() {
+		cm := t.CentralManagers[cmId]
+		cm.HandleRequest(m)
+		cm.ServeQueues()
+	})
+}
+
+func (t *SimTransport) SendConfirmationToCM(cmId int, m Message) {
+	t.Sim.Deliver(t.NumOfProcessors()+t.NumOfCentralManagers()+cmId, func() {
+		cm := t.CentralManagers[cmId]
+		cm.HandleConfirmation(m)
+		cm.ServeQueues()
+	})
+}
+
+func (t *SimTransport) ProcessorInbox(processorId int) <-chan Message {
+	return nil
+}
+
+func (t *SimTransport) CMInbox(cmId int) <-chan Message {
+	return nil
+}
+
+func (t *SimTransport) CMConfirmationInbox(cmId int) <-chan Message {
+	return nil
+}
+
+func (t *SimTransport) NumOfProcessors() int {
+	return len(t.Processors)
+}
+
+func (t *SimTransport) NumOfCentralManagers() int {
+	return len(t.CentralManagers)
+}
@@ -0,0 +1,74 @@
+package lib
+
+// PageRequests is the value type of State.RequestMap
+type PageRequests struct {
+	Status RequestStatus
+	Queue  []Message
+}
+
+type StateOpType int
+
+const (
+	SET_ENTRY StateOpType = iota
+	SET_INVALIDATION_COUNTER
+	SET_REQUEST
+)
+
+// StateOp is a single change to a CM's State. Every change the CM makes goes through one,
+// so it can be written to the write-ahead log and replayed to get the same State back.
+type StateOp struct {
+	Seq     int64 // position of the op in the CM's history, State.Seq after applying it
+	Type    StateOpType
+	PageId  int
+	Entry   CMEntry      // SET_ENTRY
+	Counter int          // SET_INVALIDATION_COUNTER
+	Request PageRequests // SET_REQUEST
+}
+
+func NewState() State {
+	return State{
+		Entries:             map[int]CMEntry{},
+		InvalidationCounter: map[int]int{},
+		RequestMap: map[int]struct {
+			Status RequestStatus
+			Queue  []Message
+		}{},
+	}
+}
+
+func (s *State) Apply(op StateOp) {
+	switch op.Type {
+	case SET_ENTRY:
+		s.Entries[op.PageId] = op.Entry
+	case SET_INVALIDATION_COUNTER:
+		s.InvalidationCounter[op.PageId] = op.Counter
+	case SET_REQUEST:
+		s.RequestMap[op.PageId] = op.Request
+	}
+	s.Seq = op.Seq
+}
+
+func (cm *CentralManager) SetEntry(pageId int, entry CMEntry) {
+	cm.ApplyStateOp(StateOp{Type: SET_ENTRY, PageId: pageId, Entry: entry})
+}
+
+func (cm *CentralManager) SetInvalidationCounter(pageId int, counter int) {
+	cm.ApplyStateOp(StateOp{Type: SET_INVALIDATION_COUNTER, PageId: pageId, Counter: counter})
+}
+
+func (cm *CentralManager) SetRequest(pageId int, request PageRequests) {
+	cm.ApplyStateOp(StateOp{Type: SET_REQUEST, PageId: pageId, Request: request})
+}
+
+func (cm *CentralManager) ApplyStateOp(op StateOp) {
+	/**
+	1. Number the op and apply it to CurrentState straight away, the handler making it reads the state back
+	2. Queue it to become part of the next log entry, see ForwardState. It is persisted with that entry
+	*/
+	op.Seq = cm.CurrentState.Seq + 1
+	cm.CurrentState.Apply(op)
+	if op.Type == SET_REQUEST {
+		cm.Metrics.QueueLength(cm.Id, op.PageId, len(op.Request.Queue))
+	}
+	cm.PendingOps = append(cm.PendingOps, op)
+}
@@ -1,5 +1,7 @@
 package lib
 
+import "distsys/common/trace"
+
 type RequestStatus struct {
 	Timestamp int64
 	State     RequestState
@@ -18,8 +20,20 @@ type Message struct {
 	Sender  int
 	Type    MessageType
 	PageId  int
-	Content int
-	State   []byte
+	Content []byte       // page contents, only on PAGE_TO_WRITE and PAGE_COPY_FORWARD
+	State   []byte       // JSON encoded State for FORWARD_STATE, JSON encoded []LogEntry for APPEND_ENTRIES
+	Term    int64        // Raft term of the sending CM, on messages from a processor the newest term it has seen. 0 if unknown
+	Raft    *RaftMessage // only on messages between CMs
+	Trace   trace.Context
+}
+
+// TraceInfo names the message in a trace, Raft messages and ANNOUNCE_PRIMARY aren't about a page
+func (m Message) TraceInfo() trace.Info {
+	switch m.Type {
+	case FORWARD_STATE, ANNOUNCE_PRIMARY, REQUEST_VOTE, VOTE_REPLY, APPEND_ENTRIES, APPEND_REPLY:
+		return trace.Info{Type: m.Type.toString(), PageId: trace.NO_PAGE}
+	}
+	return trace.Info{Type: m.Type.toString(), PageId: m.PageId}
 }
 
 type MessageType int
@@ -37,12 +51,11 @@ const (
 	WRITE_CONFIRMATION
 	PAGE_NOT_FOUND
 	FORWARD_STATE
-	START_ELECTION
-	CM_CHOSEN
-	ELECT
-	CHECK_ALIVE
-	ACKNOWLEDGE
 	ANNOUNCE_PRIMARY
+	REQUEST_VOTE
+	VOTE_REPLY
+	APPEND_ENTRIES
+	APPEND_REPLY
 )
 
 func (m *MessageType) toString() string {
@@ -62,10 +75,9 @@ var MESSAGE_TYPES []string = []string{
 	"WRITE_CONFIRMATION",
 	"PAGE_NOT_FOUND",
 	"FORWARD_STATE",
-	"START_ELECTION",
-	"CM_CHOSEN",
-	"ELECT",
-	"CHECK_ALIVE",
-	"ACKNOWLEDGE",
 	"ANNOUNCE_PRIMARY",
+	"REQUEST_VOTE",
+	"VOTE_REPLY",
+	"APPEND_ENTRIES",
+	"APPEND_REPLY",
 }
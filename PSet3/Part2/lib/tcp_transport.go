@@ -0,0 +1,239 @@
+package lib
+
+import (
+	"encoding/gob"
+	"encoding/json"
+	"fmt"
+	"net"
+	"os"
+	"sync"
+	"time"
+)
+
+// ClusterConfig lists the address every Processor and CentralManager listens on.
+// The index in each array is the node's Id.
+type ClusterConfig struct {
+	Processors      []string `json:"processors"`
+	CentralManagers []string `json:"centralManagers"`
+}
+
+func LoadClusterConfig(path string) (ClusterConfig, error) {
+	cluster := ClusterConfig{}
+	configBytes, err := os.ReadFile(path)
+	if err != nil {
+		return cluster, err
+	}
+	if err = json.Unmarshal(configBytes, &cluster); err != nil {
+		return cluster, err
+	}
+	return cluster, nil
+}
+
+type NodeRole int
+
+const (
+	PROCESSOR NodeRole = iota
+	CENTRAL_MANAGER
+)
+
+type mailbox int
+
+const (
+	PROCESSOR_INBOX mailbox = iota
+	CM_INBOX
+	CM_CONFIRMATION_INBOX
+)
+
+// envelope is what actually goes over the wire, so the receiver knows which inbox the message is for
+type envelope struct {
+	Mailbox mailbox
+	Message Message
+}
+
+const TCP_DIAL_TIMEOUT = time.Second
+const TCP_WRITE_TIMEOUT = time.Second
+
+type tcpConnection struct {
+	conn      net.Conn
+	encoder   *gob.Encoder
+	writeLock sync.Mutex // one message at a time on the wire
+}
+
+// TCPTransport is the Transport for a single node (Processor or CentralManager) running as its own OS process.
+// Messages to a node that cannot be reached are dropped, the same way a real network would lose them,
+// and the timeouts in the protocol take care of the rest.
+type TCPTransport struct {
+	Cluster ClusterConfig
+	Role    NodeRole
+	Id      int
+
+	listener       net.Listener
+	inbox          chan Message // PROCESSOR_INBOX for processors, CM_INBOX for CMs
+	confirmation   chan Message // CM_CONFIRMATION_INBOX, only used by CMs
+	connectionLock sync.Mutex   // guards connections only, never held while dialing or writing
+	connections    map[string]*tcpConnection
+}
+
+func NewTCPTransport(cluster ClusterConfig, role NodeRole, id int, bufferSize int) (*TCPTransport, error) {
+	t := TCPTransport{
+		Cluster:      cluster,
+		Role:         role,
+		Id:           id,
+		inbox:        make(chan Message, bufferSize),
+		confirmation: make(chan Message, bufferSize),
+		connections:  map[string]*tcpConnection{},
+	}
+
+	address, err := t.address(role, id)
+	if err != nil {
+		return nil, err
+	}
+	if t.listener, err = net.Listen("tcp", address); err != nil {
+		return nil, err
+	}
+	go t.accept()
+	return &t, nil
+}
+
+func (t *TCPTransport) address(role NodeRole, id int) (string, error) {
+	addresses := t.Cluster.Processors
+	if role == CENTRAL_MANAGER {
+		addresses = t.Cluster.CentralManagers
+	}
+	if id < 0 || id >= len(addresses) {
+		return "", fmt.Errorf("no address configured for node %v with role %v", id, role)
+	}
+	return addresses[id], nil
+}
+
+func (t *TCPTransport) accept() {
+	for {
+		conn, err := t.listener.Accept()
+		if err != nil {
+			return
+		}
+		go t.receive(conn)
+	}
+}
+
+func (t *TCPTransport) receive(conn net.Conn) {
+	defer conn.Close()
+	decoder := gob.NewDecoder(conn)
+	for {
+		e := envelope{}
+		if err := decoder.Decode(&e); err != nil {
+			return
+		}
+		if e.Mailbox == CM_CONFIRMATION_INBOX {
+			t.confirmation <- e.Message
+			continue
+		}
+		t.inbox <- e.Message
+	}
+}
+
+func (t *TCPTransport) send(role NodeRole, id int, box mailbox, m Message) {
+	address, err := t.address(role, id)
+	if err != nil {
+		fmt.Printf("transport: %v\n", err)
+		return
+	}
+
+	connection := t.connection(address)
+	if connection == nil {
+		// peer is down, drop the message
+		return
+	}
+	connection.writeLock.Lock()
+	connection.conn.SetWriteDeadline(time.Now().Add(TCP_WRITE_TIMEOUT))
+	err = connection.encoder.Encode(envelope{Mailbox: box, Message: m})
+	connection.writeLock.Unlock()
+	if err != nil {
+		// connection broke, redial on the next send
+		connection.conn.Close()
+		t.connectionLock.Lock()
+		if t.connections[address] == connection {
+			delete(t.connections, address)
+		}
+		t.connectionLock.Unlock()
+	}
+}
+
+// connection returns the open connection to address, dialing it if there is none, or nil if the peer can't be
+// reached. The dial happens outside connectionLock, so an unreachable peer doesn't hold up sends to the others
+func (t *TCPTransport) connection(address string) *tcpConnection {
+	t.connectionLock.Lock()
+	connection, ok := t.connections[address]
+	t.connectionLock.Unlock()
+	if ok {
+		return connection
+	}
+
+	conn, err := net.DialTimeout("tcp", address, TCP_DIAL_TIMEOUT)
+	if err != nil {
+		return nil
+	}
+	t.connectionLock.Lock()
+	defer t.connectionLock.Unlock()
+	if existing, ok := t.connections[address]; ok {
+		// another send dialed the same peer meanwhile, keep theirs
+		conn.Close()
+		return existing
+	}
+	connection = &tcpConnection{conn: conn, encoder: gob.NewEncoder(conn)}
+	t.connections[address] = connection
+	return connection
+}
+
+func (t *TCPTransport) SendToProcessor(processorId int, m Message) {
+	t.send(PROCESSOR, processorId, PROCESSOR_INBOX, m)
+}
+
+func (t *TCPTransport) SendToCM(cmId int, m Message) {
+	t.send(CENTRAL_MANAGER, cmId, CM_INBOX, m)
+}
+
+func (t *TCPTransport) SendConfirmationToCM(cmId int, m Message) {
+	t.send(CENTRAL_MANAGER, cmId, CM_CONFIRMATION_INBOX, m)
+}
+
+// Inboxes of other nodes live in other processes, so only the local node's inbox is returned.
+// Asking for any other inbox gives a nil channel, which never receives.
+func (t *TCPTransport) ProcessorInbox(processorId int) <-chan Message {
+	if t.Role != PROCESSOR || processorId != t.Id {
+		return nil
+	}
+	return t.inbox
+}
+
+func (t *TCPTransport) CMInbox(cmId int) <-chan Message {
+	if t.Role != CENTRAL_MANAGER || cmId != t.Id {
+		return nil
+	}
+	return t.inbox
+}
+
+func (t *TCPTransport) CMConfirmationInbox(cmId int) <-chan Message {
+	if t.Role != CENTRAL_MANAGER || cmId != t.Id {
+		return nil
+	}
+	return t.confirmation
+}
+
+func (t *TCPTransport) NumOfProcessors() int {
+	return len(t.Cluster.Processors)
+}
+
+func (t *TCPTransport) NumOfCentralManagers() int {
+	return len(t.Cluster.CentralManagers)
+}
+
+func (t *TCPTransport) Close() error {
+	t.connectionLock.Lock()
+	defer t.connectionLock.Unlock()
+	for address, connection := range t.connections {
+		connection.conn.Close()
+		delete(t.connections, address)
+	}
+	return t.listener.Close()
+}
@@ -0,0 +1,33 @@
+package lib
+
+import "distsys/common/trace"
+
+// TracingTransport records every message one node sends in its trace and stamps the message with the trace context.
+// Nodes are numbered like FaultyTransport's
+type TracingTransport struct {
+	Transport
+	Tracer *trace.Tracer
+}
+
+// WithTracer returns the Transport the node with this tracer sends on, transport itself if tracer is nil
+func WithTracer(transport Transport, tracer *trace.Tracer) Transport {
+	if tracer == nil {
+		return transport
+	}
+	return &TracingTransport{Transport: transport, Tracer: tracer}
+}
+
+func (t *TracingTransport) SendToProcessor(processorId int, m Message) {
+	m.Trace = t.Tracer.Send(ProcessorNode(processorId), m.TraceInfo())
+	t.Transport.SendToProcessor(processorId, m)
+}
+
+func (t *TracingTransport) SendToCM(cmId int, m Message) {
+	m.Trace = t.Tracer.Send(CMNode(t.Transport, cmId), m.TraceInfo())
+	t.Transport.SendToCM(cmId, m)
+}
+
+func (t *TracingTransport) SendConfirmationToCM(cmId int, m Message) {
+	m.Trace = t.Tracer.Send(CMNode(t.Transport, cmId), m.TraceInfo())
+	t.Transport.SendConfirmationToCM(cmId, m)
+}
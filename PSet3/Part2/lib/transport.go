@@ -0,0 +1,70 @@
+package lib
+
+// Transport delivers messages between Processors and CentralManagers.
+// Processor and CentralManager only ever talk to a Transport, so the same HandleMessage
+// logic can run over in-memory channels, TCP or a simulated network.
+type Transport interface {
+	SendToProcessor(processorId int, m Message)
+	SendToCM(cmId int, m Message)             // READ_REQUEST and WRITE_REQUEST, queued by the CM
+	SendConfirmationToCM(cmId int, m Message) // every other message, handled by the CM straight away
+	ProcessorInbox(processorId int) <-chan Message
+	CMInbox(cmId int) <-chan Message
+	CMConfirmationInbox(cmId int) <-chan Message
+	NumOfProcessors() int
+	NumOfCentralManagers() int
+}
+
+// ChannelTransport is the in-memory Transport used when every node runs as a goroutine in one process
+type ChannelTransport struct {
+	PChannels                   []chan Message
+	CentralManagersIncoming     []chan Message
+	CentralManagersConfirmation []chan Message
+}
+
+func NewChannelTransport(numOfProcessors int, numOfCentralManagers int, bufferSize int) *ChannelTransport {
+	t := ChannelTransport{
+		PChannels:                   make([]chan Message, numOfProcessors),
+		CentralManagersIncoming:     make([]chan Message, numOfCentralManagers),
+		CentralManagersConfirmation: make([]chan Message, numOfCentralManagers),
+	}
+	for i := 0; i < numOfProcessors; i++ {
+		t.PChannels[i] = make(chan Message, bufferSize)
+	}
+	for i := 0; i < numOfCentralManagers; i++ {
+		t.CentralManagersIncoming[i] = make(chan Message, bufferSize)
+		t.CentralManagersConfirmation[i] = make(chan Message, bufferSize)
+	}
+	return &t
+}
+
+func (t *ChannelTransport) SendToProcessor(processorId int, m Message) {
+	t.PChannels[processorId] <- m
+}
+
+func (t *ChannelTransport) SendToCM(cmId int, m Message) {
+	t.CentralManagersIncoming[cmId] <- m
+}
+
+func (t *ChannelTransport) SendConfirmationToCM(cmId int, m Message) {
+	t.CentralManagersConfirmation[cmId] <- m
+}
+
+func (t *ChannelTransport) ProcessorInbox(processorId int) <-chan Message {
+	return t.PChannels[processorId]
+}
+
+func (t *ChannelTransport) CMInbox(cmId int) <-chan Message {
+	return t.CentralManagersIncoming[cmId]
+}
+
+func (t *ChannelTransport) CMConfirmationInbox(cmId int) <-chan Message {
+	return t.CentralManagersConfirmation[cmId]
+}
+
+func (t *ChannelTransport) NumOfProcessors() int {
+	return len(t.PChannels)
+}
+
+func (t *ChannelTransport) NumOfCentralManagers() int {
+	return len(t.CentralManagersIncoming)
+}
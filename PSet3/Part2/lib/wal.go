@@ -0,0 +1,230 @@
+package lib
+
+import (
+	"bufio"
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+)
+
+// WriteAheadLog keeps a CM's Raft log on disk: a snapshot of the State after every entry folded into the base
+// state, the log entries after it, and the CM's Raft term and vote. Entries are written before the CM acknowledges
+// them, so an entry a majority has acknowledged is still there after every CM restarts.
+// Files: <Dir>/cm-<id>.snapshot, <Dir>/cm-<id>.wal (one JSON encoded entry per line) and <Dir>/cm-<id>.vote
+type WriteAheadLog struct {
+	Dir              string
+	CMId             int
+	SnapshotInterval int // number of appended entries before the next snapshot, 0 never snapshots
+
+	file                 *os.File
+	entriesSinceSnapshot int
+}
+
+// SavedLog is what a CM recovers from its write-ahead log
+type SavedLog struct {
+	State         State // the state after every entry up to SnapshotIndex
+	SnapshotIndex int
+	SnapshotTerm  int64
+	Entries       []LogEntry // the entries after SnapshotIndex, committed or not
+}
+
+// savedEntry is one line of the log. A line for an index the log already has replaces that entry and every one after
+// it, the way a follower drops the entries that conflict with its leader's
+type savedEntry struct {
+	Index int
+	Entry LogEntry
+}
+
+// OpenWriteAheadLog recovers the log saved in dir for the CM and opens it for appending.
+// A CM that has never run before gets an empty State and no entries.
+func OpenWriteAheadLog(dir string, cmId int, snapshotInterval int) (*WriteAheadLog, SavedLog, error) {
+	w := WriteAheadLog{Dir: dir, CMId: cmId, SnapshotInterval: snapshotInterval}
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return nil, SavedLog{}, err
+	}
+
+	saved, err := w.recover()
+	if err != nil {
+		return nil, SavedLog{}, err
+	}
+
+	// rewrite everything recovered, which also drops a torn last line in the log
+	if err = w.Snapshot(saved); err != nil {
+		return nil, SavedLog{}, err
+	}
+	return &w, saved, nil
+}
+
+func (w *WriteAheadLog) snapshotPath() string {
+	return filepath.Join(w.Dir, fmt.Sprintf("cm-%v.snapshot", w.CMId))
+}
+
+func (w *WriteAheadLog) logPath() string {
+	return filepath.Join(w.Dir, fmt.Sprintf("cm-%v.wal", w.CMId))
+}
+
+func (w *WriteAheadLog) votePath() string {
+	return filepath.Join(w.Dir, fmt.Sprintf("cm-%v.vote", w.CMId))
+}
+
+type savedVote struct {
+	Term     int64
+	VotedFor int
+}
+
+// SaveVote persists the CM's Raft term and vote, a CM must never vote twice in the same term even across a crash
+func (w *WriteAheadLog) SaveVote(term int64, votedFor int) error {
+	voteBytes, err := json.Marshal(savedVote{Term: term, VotedFor: votedFor})
+	if err != nil {
+		return err
+	}
+	return writeFileSynced(w.votePath(), voteBytes)
+}
+
+// LoadVote returns the last saved Raft term and vote, term 0 and no vote if none was saved
+func (w *WriteAheadLog) LoadVote() (int64, int, error) {
+	voteBytes, err := os.ReadFile(w.votePath())
+	if os.IsNotExist(err) {
+		return 0, -1, nil
+	}
+	if err != nil {
+		return 0, -1, err
+	}
+	vote := savedVote{}
+	if err = json.Unmarshal(voteBytes, &vote); err != nil {
+		return 0, -1, fmt.Errorf("corrupt vote %v: %v", w.votePath(), err)
+	}
+	return vote.Term, vote.VotedFor, nil
+}
+
+func (w *WriteAheadLog) recover() (SavedLog, error) {
+	/**
+	1. Load the last snapshot, if any
+	2. Replay every entry in the log after the snapshot, a later line for the same index replaces what came before
+	3. Stop at the first line that can't be decoded or doesn't follow on - the CM died halfway through writing it
+	*/
+	saved := SavedLog{State: NewState()}
+	snapshotBytes, err := os.ReadFile(w.snapshotPath())
+	if err == nil {
+		if err = json.Unmarshal(snapshotBytes, &saved); err != nil {
+			return SavedLog{}, fmt.Errorf("corrupt snapshot %v: %v", w.snapshotPath(), err)
+		}
+	} else if !os.IsNotExist(err) {
+		return SavedLog{}, err
+	}
+
+	logFile, err := os.Open(w.logPath())
+	if os.IsNotExist(err) {
+		return saved, nil
+	}
+	if err != nil {
+		return SavedLog{}, err
+	}
+	defer logFile.Close()
+
+	scanner := bufio.NewScanner(logFile)
+	scanner.Buffer(make([]byte, 64*1024), 64*1024*1024)
+	for scanner.Scan() {
+		line := savedEntry{}
+		if err := json.Unmarshal(scanner.Bytes(), &line); err != nil {
+			break
+		}
+		if line.Index <= saved.SnapshotIndex {
+			continue
+		}
+		position := line.Index - saved.SnapshotIndex - 1
+		if position > len(saved.Entries) {
+			break
+		}
+		saved.Entries = append(saved.Entries[:position], line.Entry)
+	}
+	return saved, nil
+}
+
+// Append persists entries, the first of which has index firstIndex, replacing any saved entries from that index on
+func (w *WriteAheadLog) Append(firstIndex int, entries []LogEntry) error {
+	lines, err := encodeEntries(firstIndex, entries)
+	if err != nil {
+		return err
+	}
+	if _, err = w.file.Write(lines); err != nil {
+		return err
+	}
+	w.entriesSinceSnapshot += len(entries)
+	return w.file.Sync()
+}
+
+func encodeEntries(firstIndex int, entries []LogEntry) ([]byte, error) {
+	lines := []byte{}
+	for i, entry := range entries {
+		lineBytes, err := json.Marshal(savedEntry{Index: firstIndex + i, Entry: entry})
+		if err != nil {
+			return nil, err
+		}
+		lines = append(append(lines, lineBytes...), '\n')
+	}
+	return lines, nil
+}
+
+func (w *WriteAheadLog) SnapshotDue() bool {
+	return w.SnapshotInterval > 0 && w.entriesSinceSnapshot >= w.SnapshotInterval
+}
+
+// Snapshot replaces everything on disk but the vote with saved: the snapshot and a log holding only saved's entries.
+// The snapshot goes first, a crash before the log is replaced leaves the old log, whose entries after the new
+// snapshot are the same
+func (w *WriteAheadLog) Snapshot(saved SavedLog) error {
+	snapshotBytes, err := json.Marshal(SavedLog{State: saved.State, SnapshotIndex: saved.SnapshotIndex, SnapshotTerm: saved.SnapshotTerm})
+	if err != nil {
+		return err
+	}
+	lines, err := encodeEntries(saved.SnapshotIndex+1, saved.Entries)
+	if err != nil {
+		return err
+	}
+
+	if err = writeFileSynced(w.snapshotPath(), snapshotBytes); err != nil {
+		return err
+	}
+	if w.file != nil {
+		w.file.Close()
+	}
+	if err = writeFileSynced(w.logPath(), lines); err != nil {
+		return err
+	}
+	if w.file, err = os.OpenFile(w.logPath(), os.O_WRONLY|os.O_APPEND, 0644); err != nil {
+		return err
+	}
+	w.entriesSinceSnapshot = 0
+	return nil
+}
+
+// writeFileSynced replaces the file at path with data.
+// It writes to a temporary file first so a crash never leaves half a file behind
+func writeFileSynced(path string, data []byte) error {
+	tmpPath := path + ".tmp"
+	tmpFile, err := os.Create(tmpPath)
+	if err != nil {
+		return err
+	}
+	if _, err = tmpFile.Write(data); err != nil {
+		tmpFile.Close()
+		return err
+	}
+	if err = tmpFile.Sync(); err != nil {
+		tmpFile.Close()
+		return err
+	}
+	if err = tmpFile.Close(); err != nil {
+		return err
+	}
+	return os.Rename(tmpPath, path)
+}
+
+func (w *WriteAheadLog) Close() error {
+	if w.file == nil {
+		return nil
+	}
+	return w.file.Close()
+}
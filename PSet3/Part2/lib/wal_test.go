@@ -0,0 +1,60 @@
+package lib
+
+import (
+	"os"
+	"reflect"
+	"testing"
+)
+
+func TestWriteAheadLogRecoversEntries(t *testing.T) {
+	dir := t.TempDir()
+	wal, saved, err := OpenWriteAheadLog(dir, 0, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if saved.SnapshotIndex != 0 || len(saved.Entries) != 0 {
+		t.Fatalf("a new log recovered %+v", saved)
+	}
+
+	entries := []LogEntry{{Term: 1}, {Term: 1, Ops: []StateOp{{Seq: 1, Type: SET_ENTRY, PageId: 3}}}, {Term: 1}}
+	if err := wal.Append(1, entries); err != nil {
+		t.Fatal(err)
+	}
+	// a follower dropping entry 3 for a newer leader's
+	replacement := []LogEntry{{Term: 2}}
+	if err := wal.Append(3, replacement); err != nil {
+		t.Fatal(err)
+	}
+	wal.Close()
+
+	// the CM died halfway through writing the next line
+	logFile, err := os.OpenFile(wal.logPath(), os.O_WRONLY|os.O_APPEND, 0644)
+	if err != nil {
+		t.Fatal(err)
+	}
+	logFile.WriteString(`{"Index":4,"Entry":{"Te`)
+	logFile.Close()
+
+	wal, saved, err = OpenWriteAheadLog(dir, 0, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := append(entries[:2:2], replacement...)
+	if !reflect.DeepEqual(saved.Entries, want) {
+		t.Errorf("recovered %+v, want %+v", saved.Entries, want)
+	}
+
+	base := NewState()
+	base.Apply(StateOp{Seq: 1, Type: SET_ENTRY, PageId: 3})
+	if err := wal.Snapshot(SavedLog{State: base, SnapshotIndex: 2, SnapshotTerm: 1, Entries: replacement}); err != nil {
+		t.Fatal(err)
+	}
+	wal.Close()
+	_, saved, err = OpenWriteAheadLog(dir, 0, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if saved.SnapshotIndex != 2 || saved.SnapshotTerm != 1 || saved.State.Seq != 1 || !reflect.DeepEqual(saved.Entries, replacement) {
+		t.Errorf("recovered %+v after a snapshot at index 2", saved)
+	}
+}
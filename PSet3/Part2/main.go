@@ -1,80 +1,555 @@
 package main
 
 import (
+	"distsys/common/directory"
+	"distsys/common/faults"
+	"distsys/common/metrics"
+	"distsys/common/scenario"
+	"distsys/common/sim"
+	"distsys/common/trace"
+	"flag"
 	"fmt"
+	"math"
+	"net"
+	"os"
+	"os/exec"
+	"strconv"
+	"time"
 
 	lib "main/lib"
 )
 
 const NUM_OF_VARIABLES = 4
+const PAGE_SIZE = 64
 const NUM_OF_PROCESSORS = 10
-const NUM_OF_CENTRAL_MANAGERS = 2
+const NUM_OF_CENTRAL_MANAGERS = 3 // in every shard, a Raft majority survives one dead CM
 const TIMEOUT_DURATION = 5
+const COUNT_DOWN_TO_DEATH = 100
+const FINAL_COUNT_DOWN_TO_DEATH = 1000
+const SNAPSHOT_INTERVAL = 100
+const RING_POINTS_PER_SHARD = 100
 
 func main() {
-	processorChannels := make([]chan lib.Message, NUM_OF_PROCESSORS)
-	for i := 0; i < NUM_OF_PROCESSORS; i++ {
-		processorChannels[i] = make(chan lib.Message, 10*NUM_OF_PROCESSORS)
+	role := flag.String("role", "", "run a single node as its own process: processor | cm. Use spawn to start one process per node in -cluster")
+	id := flag.Int("id", 0, "id of the node when running with -role")
+	clusterPath := flag.String("cluster", "cluster.json", "addresses of every processor and central manager")
+	countDown := flag.Int("countdown", COUNT_DOWN_TO_DEATH, "messages the leader CM handles before it temporarily dies")
+	finalCountDown := flag.Int("finalcountdown", FINAL_COUNT_DOWN_TO_DEATH, "messages the leader CM handles before it dies for good")
+	pageSize := flag.Int("pagesize", PAGE_SIZE, "number of bytes in every page")
+	walDir := flag.String("waldir", "", "directory for the CMs' write-ahead logs, CM state is only kept in memory if empty")
+	shards := flag.Int("shards", 1, "number of Raft groups of CMs the pages are sharded across")
+	directoryType := flag.String("directory", "modulo", "how pages are assigned to shards: modulo | hash")
+	historyPath := flag.String("history", "", "record every read and write to this file for cmd/checkhistory, a processor run with -role adds .<id> to the name")
+	seed := flag.Int64("seed", 0, "run every node in one deterministic simulation with this seed, the same seed gives the same run")
+	faultsPath := flag.String("faults", "", "JSON file of faults to inject into the network, see common/faults. Processors are nodes 0 to 9, CMs follow")
+	simTime := flag.Duration("simtime", 60*time.Second, "virtual time a simulation runs for")
+	tracePath := flag.String("trace", "", "record a structured trace of every message to this file for common/cmd/traceexport, a node run with -role adds .<role>.<id> to the name")
+	scenarioPath := flag.String("scenario", "", "simulate the scenario in this file, see common/scenario. -seed overrides its seed")
+	metricsAddr := flag.String("metrics", "", "serve Prometheus metrics on http://<address>/metrics, e.g. :9100. With -role spawn, node n listens on the port plus n (CMs follow the processors)")
+	flag.Parse()
+
+	if *pageSize < 1 {
+		fmt.Printf("-pagesize must be at least 1, not %v\n", *pageSize)
+		os.Exit(1)
 	}
+	config := nodeConfig{PageSize: *pageSize, CountDown: *countDown, FinalCountDown: *finalCountDown, WALDir: *walDir,
+		Shards: *shards, DirectoryType: *directoryType, HistoryPath: *historyPath, FaultsPath: *faultsPath, TracePath: *tracePath, Clock: sim.WallClock{},
+		MetricsAddr: *metricsAddr, Processors: NUM_OF_PROCESSORS, Managers: NUM_OF_CENTRAL_MANAGERS, Pages: NUM_OF_VARIABLES, Timeout: TIMEOUT_DURATION}
 
-	cmArray := startCentralManagers(NUM_OF_CENTRAL_MANAGERS, processorChannels)
+	var sc *scenario.Scenario
+	if *scenarioPath != "" {
+		var err error
+		if sc, err = scenario.Load(*scenarioPath); err != nil {
+			fmt.Printf("could not load scenario: %v\n", err)
+			os.Exit(1)
+		}
+		if *seed != 0 {
+			sc.Seed = *seed
+		}
+		applyScenario(&config, sc)
+	} else if *seed != 0 {
+		sc = &scenario.Scenario{Seed: *seed, Duration: faults.Duration{Duration: *simTime}}
+		if *faultsPath != "" {
+			faultsConfig, err := faults.LoadConfig(*faultsPath)
+			if err != nil {
+				fmt.Printf("could not load faults: %v\n", err)
+				os.Exit(1)
+			}
+			sc.Faults = &faultsConfig
+		}
+	}
 
-	for i := 0; i < NUM_OF_PROCESSORS; i++ {
-		p := lib.Processor{
-			Id:              i,
-			CentralManagers: cmArray,
-			PrimaryCM:       0,
-			Channels:        processorChannels,
-			NumOfVariables:  NUM_OF_VARIABLES,
-			RequestMap:      map[int]lib.RequestStatus{},
-			Cache:           map[int]lib.PageCache{},
-			Debug:           false,
-			TimeoutDur:      TIMEOUT_DURATION,
+	if config.Shards < 1 {
+		fmt.Printf("-shards must be at least 1, not %v\n", config.Shards)
+		os.Exit(1)
+	}
+	switch config.DirectoryType {
+	case "modulo":
+		config.Directory = directory.Modulo{Managers: config.Shards}
+	case "hash":
+		config.Directory = directory.NewConsistentHash(config.Shards, RING_POINTS_PER_SHARD)
+	default:
+		fmt.Printf("unknown directory %q\n", *directoryType)
+		os.Exit(1)
+	}
+
+	if sc != nil {
+		report, err := runSimulation(config, sc)
+		if err != nil {
+			fmt.Printf("could not run simulation: %v\n", err)
+			os.Exit(1)
+		}
+		if *scenarioPath != "" {
+			fmt.Println(report)
+		}
+		if !report.Passed() {
+			os.Exit(1)
+		}
+		return
+	}
+	// partitions are timed from here, every process in a cluster times them from its own start
+	injector, err := faults.Open(*faultsPath, sim.WallClock{}, nil)
+	if err != nil {
+		fmt.Printf("could not load faults: %v\n", err)
+		os.Exit(1)
+	}
+	config.Faults = injector
+
+	if *role == "" {
+		runInProcess(config)
+		return
+	}
+
+	cluster, err := lib.LoadClusterConfig(*clusterPath)
+	if err != nil {
+		fmt.Printf("could not load cluster config: %v\n", err)
+		os.Exit(1)
+	}
+	if len(cluster.CentralManagers)%*shards != 0 {
+		fmt.Printf("%v CMs can't be split evenly into %v shards\n", len(cluster.CentralManagers), *shards)
+		os.Exit(1)
+	}
+
+	switch *role {
+	case "spawn":
+		spawnCluster(cluster, *clusterPath, config)
+	case "processor":
+		transport, err := lib.NewTCPTransport(cluster, lib.PROCESSOR, *id, 10*len(cluster.Processors))
+		if err != nil {
+			fmt.Printf("could not start processor %v: %v\n", *id, err)
+			os.Exit(1)
+		}
+		if err := openHistory(&config, *historyPath+"."+strconv.Itoa(*id)); err != nil {
+			fmt.Printf("could not open history: %v\n", err)
+			os.Exit(1)
+		}
+		if err := openTrace(&config, *tracePath+".processor."+strconv.Itoa(*id)); err != nil {
+			fmt.Printf("could not open trace: %v\n", err)
+			os.Exit(1)
 		}
+		if err := openMetrics(&config); err != nil {
+			fmt.Printf("could not serve metrics: %v\n", err)
+			os.Exit(1)
+		}
+		p := newProcessor(*id, transport, config)
+		p.Start()
+	case "cm":
+		transport, err := lib.NewTCPTransport(cluster, lib.CENTRAL_MANAGER, *id, 10*len(cluster.Processors))
+		if err != nil {
+			fmt.Printf("could not start CM %v: %v\n", *id, err)
+			os.Exit(1)
+		}
+		if err := openTrace(&config, *tracePath+".cm."+strconv.Itoa(*id)); err != nil {
+			fmt.Printf("could not open trace: %v\n", err)
+			os.Exit(1)
+		}
+		if err := openMetrics(&config); err != nil {
+			fmt.Printf("could not serve metrics: %v\n", err)
+			os.Exit(1)
+		}
+		cm, err := newCentralManager(*id, transport, config)
+		if err != nil {
+			fmt.Printf("could not start CM %v: %v\n", *id, err)
+			os.Exit(1)
+		}
+		cm.Start() // returns once the CM really dies, which ends the process
+	default:
+		fmt.Printf("unknown role %q\n", *role)
+		os.Exit(1)
+	}
+}
+
+// nodeConfig holds the command line settings every node is started with
+type nodeConfig struct {
+	PageSize       int
+	CountDown      int
+	FinalCountDown int
+	WALDir         string
+	Shards         int
+	DirectoryType  string
+	Directory      directory.Directory
+	HistoryPath    string
+	History        *lib.History // shared by every processor in this process
+	FaultsPath     string
+	Faults         *faults.Injector // nil if no faults are injected
+	TracePath      string
+	Trace          *trace.Recorder // shared by every node in this process, nil if nothing is traced
+	Clock          trace.Clock     // what trace events are timed with
+	MetricsAddr    string
+	Metrics        *lib.Metrics // shared by every node in this process, nil if nothing is served
+
+	// a simulation can change these, the other modes use the constants and the cluster config
+	Processors      int
+	Managers        int // in every shard
+	Pages           int
+	Timeout         int
+	ReadPercent     *int          // nil flips a coin
+	RequestInterval time.Duration // 0 is the processors' default
+}
+
+// applyScenario overrides the flags with whatever the scenario sets. CMs only die when the scenario crashes them
+func applyScenario(config *nodeConfig, sc *scenario.Scenario) {
+	if sc.Nodes != 0 {
+		config.Processors = sc.Nodes
+	}
+	if sc.Managers != 0 {
+		config.Managers = sc.Managers
+	}
+	if sc.Shards != 0 {
+		config.Shards = sc.Shards
+	}
+	if sc.Pages != 0 {
+		config.Pages = sc.Pages
+	}
+	if sc.Timeout.Duration != 0 {
+		config.Timeout = int(sc.Timeout.Duration / time.Second)
+	}
+	config.ReadPercent = sc.Workload.ReadPercent
+	config.RequestInterval = sc.Workload.Interval.Duration
+	config.CountDown = math.MaxInt32
+	config.FinalCountDown = math.MaxInt32
+}
+
+func runInProcess(config nodeConfig) {
+	numOfCentralManagers := NUM_OF_CENTRAL_MANAGERS * config.Shards
+	transport := lib.NewChannelTransport(NUM_OF_PROCESSORS, numOfCentralManagers, 10*NUM_OF_PROCESSORS)
+
+	if err := openHistory(&config, config.HistoryPath); err != nil {
+		fmt.Printf("could not open history: %v\n", err)
+		os.Exit(1)
+	}
+	defer config.History.Close()
+	if err := openTrace(&config, config.TracePath); err != nil {
+		fmt.Printf("could not open trace: %v\n", err)
+		os.Exit(1)
+	}
+	defer config.Trace.Close()
+	if err := openMetrics(&config); err != nil {
+		fmt.Printf("could not serve metrics: %v\n", err)
+		os.Exit(1)
+	}
+
+	if _, err := startCentralManagers(numOfCentralManagers, transport, config); err != nil {
+		fmt.Printf("could not start CMs: %v\n", err)
+		os.Exit(1)
+	}
+
+	for i := 0; i < NUM_OF_PROCESSORS; i++ {
+		p := newProcessor(i, transport, config)
 		go p.Start()
 	}
 	fmt.Scanln()
 }
 
-func startCentralManagers(numOfCentralMangers int, processorChannels []chan lib.Message) []*(lib.CentralManager) {
-	cmArray := make([]*(lib.CentralManager), numOfCentralMangers)
+func runSimulation(config nodeConfig, sc *scenario.Scenario) (*scenario.Report, error) {
+	/**
+	The same nodes as runInProcess, but nothing runs in its own goroutine: a simulator delivers every message
+	and fires every timer one at a time on a virtual clock, in an order picked by the seed
+	1. Make the nodes, with a history in a temporary file if the scenario checks it and -history wasn't given
+	2. Schedule the scenario's CM crashes and recoveries
+	3. Run, then check what the scenario expects
+	*/
+	s := sim.New(sc.Seed)
+	numOfCentralManagers := config.Managers * config.Shards
+	transport := lib.NewSimTransport(s, config.Processors, numOfCentralManagers)
+	injector := sc.Injector(s, s.Rand)
+	config.Faults = injector
+	config.Clock = s
 
-	// make channels
-	cmIncomingChanArray := make([]chan lib.Message, numOfCentralMangers)
-	cmConfirmationChanArray := make([]chan lib.Message, numOfCentralMangers)
-	for i := 0; i < numOfCentralMangers; i++ {
-		cmIncomingChanArray[i] = make(chan lib.Message, len(processorChannels)*10)
-		cmConfirmationChanArray[i] = make(chan lib.Message, len(processorChannels)*10)
+	checksHistory := sc.Expect.SequentiallyConsistent || sc.Expect.MinOperations > 0
+	if checksHistory && config.HistoryPath == "" {
+		file, err := os.CreateTemp("", "ivy-history-*.jsonl")
+		if err != nil {
+			return nil, err
+		}
+		file.Close()
+		defer os.Remove(file.Name())
+		config.HistoryPath = file.Name()
+	}
+	if err := openHistory(&config, config.HistoryPath); err != nil {
+		return nil, err
+	}
+	defer config.History.Close()
+	if err := openTrace(&config, config.TracePath); err != nil {
+		return nil, err
+	}
+	defer config.Trace.Close()
+
+	for i := 0; i < numOfCentralManagers; i++ {
+		cm, err := newCentralManager(i, transport, config)
+		if err != nil {
+			return nil, err
+		}
+		cm.Runtime = s
+		transport.CentralManagers[i] = cm
+	}
+	for i := 0; i < config.Processors; i++ {
+		p := newProcessor(i, transport, config)
+		p.Runtime = s
+		transport.Processors[i] = p
+	}
+	for _, cm := range transport.CentralManagers {
+		cm.Init()
 	}
+	for _, p := range transport.Processors {
+		p.Init()
+	}
+
+	for _, crash := range sc.Crashes {
+		cmId := crash.Node - config.Processors
+		if cmId < 0 || cmId >= numOfCentralManagers {
+			return nil, fmt.Errorf("node %v is not a CM, only CMs can crash", crash.Node)
+		}
+		cm := transport.CentralManagers[cmId]
+		if crash.For.Duration == 0 {
+			s.After(crash.At.Duration, cm.ReallyDie)
+			continue
+		}
+		downFor := crash.For.Duration
+		s.After(crash.At.Duration, func() { cm.DieFor(downFor) })
+	}
+
+	simTime := sc.Duration.Duration
+	if simTime == 0 {
+		simTime = 60 * time.Second
+	}
+	s.Run(simTime)
+	leaders := map[int]bool{} // {[shard]: has a live leader}
+	for _, cm := range transport.CentralManagers {
+		if cm.IsAlive && cm.IsPrimary {
+			fmt.Printf("CM %v leads in term %v\n", cm.Id, cm.Raft.CurrentTerm)
+			leaders[cm.Id/config.Managers] = true
+		}
+	}
+	fmt.Printf("seed %v: simulation ended after %v of virtual time, %v events\n", sc.Seed, s.Elapsed(), s.Steps())
+	if injector != nil {
+		fmt.Println(injector)
+	}
+
+	report := scenario.NewReport(sc)
+	if sc.Expect.LeaderElected {
+		for shard := 0; shard < config.Shards; shard++ {
+			report.Expect(leaders[shard], "shard %v has no live leader", shard)
+		}
+	}
+	if checksHistory {
+		config.History.Close()
+		events, err := lib.LoadHistory(config.HistoryPath)
+		if err != nil {
+			return nil, err
+		}
+		if sc.Expect.SequentiallyConsistent {
+			for _, violation := range lib.CheckHistory(events) {
+				report.Expect(false, "%v", violation)
+			}
+		}
+		completed := 0
+		for _, ops := range lib.PairOperations(events) {
+			for _, o := range ops {
+				if !o.Pending {
+					completed++
+				}
+			}
+		}
+		report.Expect(completed >= sc.Expect.MinOperations, "%v reads and writes completed, expected at least %v", completed, sc.Expect.MinOperations)
+	}
+	return report, nil
+}
+
+func newProcessor(id int, transport lib.Transport, config nodeConfig) *lib.Processor {
+	tracer := config.Trace.Tracer(lib.ProcessorNode(id), fmt.Sprintf("processor %v", id), config.Clock)
+	return &lib.Processor{
+		Id:              id,
+		Transport:       lib.WithTracer(lib.WithFaults(transport, lib.ProcessorNode(id), config.Faults), tracer),
+		Tracer:          tracer,
+		Directory:       config.Directory,
+		NumOfVariables:  config.Pages,
+		PageSize:        config.PageSize,
+		RequestMap:      map[int]lib.RequestStatus{},
+		Cache:           map[int]lib.PageCache{},
+		Debug:           false,
+		TimeoutDur:      config.Timeout,
+		RandomRequests:  true,
+		ReadPercent:     config.ReadPercent,
+		RequestInterval: config.RequestInterval,
+		History:         config.History,
+		Metrics:         config.Metrics,
+	}
+}
+
+func openTrace(config *nodeConfig, path string) error {
+	if config.TracePath == "" {
+		return nil
+	}
+	recorder, err := trace.NewRecorder(path)
+	config.Trace = recorder
+	return err
+}
+
+// openMetrics starts serving the metrics of every node in this process, if an address was given
+func openMetrics(config *nodeConfig) error {
+	if config.MetricsAddr == "" {
+		return nil
+	}
+	registry := metrics.NewRegistry()
+	config.Metrics = lib.NewMetrics(registry)
+	fmt.Printf("serving metrics on http://%v/metrics\n", config.MetricsAddr)
+	return metrics.Serve(config.MetricsAddr, registry)
+}
+
+// offsetPort is addr with offset added to its port, so every spawned node gets a metrics address of its own
+func offsetPort(addr string, offset int) (string, error) {
+	host, port, err := net.SplitHostPort(addr)
+	if err != nil {
+		return "", err
+	}
+	portNumber, err := strconv.Atoi(port)
+	if err != nil {
+		return "", err
+	}
+	return net.JoinHostPort(host, strconv.Itoa(portNumber+offset)), nil
+}
+
+func openHistory(config *nodeConfig, path string) error {
+	if config.HistoryPath == "" {
+		return nil
+	}
+	history, err := lib.NewHistory(path)
+	config.History = history
+	return err
+}
+
+func newCentralManager(id int, transport lib.Transport, config nodeConfig) (*lib.CentralManager, error) {
+	// shard i is the Raft group of CMs i*shardSize to (i+1)*shardSize-1
+	shardSize := transport.NumOfCentralManagers() / config.Shards
+	firstPeer := id / shardSize * shardSize
+	peers := []int{}
+	for i := firstPeer; i < firstPeer+shardSize; i++ {
+		peers = append(peers, i)
+	}
+
+	tracer := config.Trace.Tracer(lib.CMNode(transport, id), fmt.Sprintf("CM %v", id), config.Clock)
+	cm := lib.CentralManager{
+		Id:                    id,
+		Peers:                 peers,
+		Transport:             lib.WithTracer(lib.WithFaults(transport, lib.CMNode(transport, id), config.Faults), tracer),
+		Tracer:                tracer,
+		CurrentState:          lib.NewState(),
+		Debug:                 false,
+		CountDownToDeath:      config.CountDown,
+		FinalCountDownToDeath: config.FinalCountDown,
+		IsAlive:               true,
+		Metrics:               config.Metrics,
+	}
+	if config.WALDir == "" {
+		return &cm, nil
+	}
+
+	// recover whatever this CM had before it died
+	wal, saved, err := lib.OpenWriteAheadLog(config.WALDir, id, SNAPSHOT_INTERVAL)
+	if err != nil {
+		return nil, err
+	}
+	cm.WAL = wal
+	cm.RestoreRaft(saved)
+	if cm.Raft.CurrentTerm, cm.Raft.VotedFor, err = wal.LoadVote(); err != nil {
+		return nil, err
+	}
+	fmt.Printf("CM %v: recovered %v pages and %v log entries in term %v from %v\n", id, len(saved.State.Entries), len(saved.Entries), cm.Raft.CurrentTerm, config.WALDir)
+	return &cm, nil
+}
+
+func startCentralManagers(numOfCentralMangers int, transport lib.Transport, config nodeConfig) ([]*(lib.CentralManager), error) {
+	cmArray := make([]*(lib.CentralManager), numOfCentralMangers)
 
 	// make Central Mangers
 	for i := 0; i < numOfCentralMangers; i++ {
-		cm := lib.CentralManager{
-			Id:                          i,
-			Incoming:                    cmIncomingChanArray[i],
-			ConfirmationChan:            cmConfirmationChanArray[i],
-			CentralManagersIncoming:     cmIncomingChanArray,
-			CentralManagersConfirmation: cmConfirmationChanArray,
-			CurrentState: lib.State{
-				InvalidationCounter: map[int]int{},
-				Entries:             map[int]lib.CMEntry{},
-				RequestMap: map[int]struct {
-					Status lib.RequestStatus
-					Queue  []lib.Message
-				}{},
-			},
-			PChannels:             processorChannels,
-			IsPrimary:             i == 0,
-			Debug:                 false,
-			CountDownToDeath:      100,
-			FinalCountDownToDeath: 1000,
-			IsAlive:               true,
-		}
-		cmArray[i] = &cm
-
-		go cm.Start()
+		cm, err := newCentralManager(i, transport, config)
+		if err != nil {
+			return nil, err
+		}
+		cmArray[i] = cm
+		go cmArray[i].Start()
 	}
 
 	//return array containing addresses
-	return cmArray
+	return cmArray, nil
+}
+
+func spawnCluster(cluster lib.ClusterConfig, clusterPath string, config nodeConfig) {
+	/**
+	Starts every node in the cluster config as a separate OS process running this same binary.
+	Kill any of the printed PIDs to simulate a real crash.
+	*/
+	executable, err := os.Executable()
+	if err != nil {
+		fmt.Printf("could not find executable: %v\n", err)
+		os.Exit(1)
+	}
+
+	processes := []*exec.Cmd{}
+	start := func(role string, id int, node int) {
+		metricsAddr := ""
+		if config.MetricsAddr != "" {
+			if metricsAddr, err = offsetPort(config.MetricsAddr, node); err != nil {
+				fmt.Printf("bad metrics address %q: %v\n", config.MetricsAddr, err)
+				return
+			}
+		}
+		cmd := exec.Command(executable,
+			"-role", role,
+			"-id", strconv.Itoa(id),
+			"-cluster", clusterPath,
+			"-pagesize", strconv.Itoa(config.PageSize),
+			"-countdown", strconv.Itoa(config.CountDown),
+			"-finalcountdown", strconv.Itoa(config.FinalCountDown),
+			"-waldir", config.WALDir,
+			"-shards", strconv.Itoa(config.Shards),
+			"-directory", config.DirectoryType,
+			"-history", config.HistoryPath,
+			"-faults", config.FaultsPath,
+			"-trace", config.TracePath,
+			"-metrics", metricsAddr)
+		cmd.Stdout = os.Stdout
+		cmd.Stderr = os.Stderr
+		if err := cmd.Start(); err != nil {
+			fmt.Printf("could not start %v %v: %v\n", role, id, err)
+			return
+		}
+		fmt.Printf("started %v %v with pid %v\n", role, id, cmd.Process.Pid)
+		processes = append(processes, cmd)
+	}
+
+	// CMs first so the processors' first requests have somewhere to go
+	for i := range cluster.CentralManagers {
+		start("cm", i, len(cluster.Processors)+i)
+	}
+	for i := range cluster.Processors {
+		start("processor", i, i)
+	}
+
+	fmt.Scanln()
+	for _, cmd := range processes {
+		cmd.Process.Kill()
+	}
 }
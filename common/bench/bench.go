@@ -0,0 +1,214 @@
+// Package bench measures how long requests wait to be served, e.g. for a distributed lock, and writes the results
+// as CSV or JSON for plotting. A Trial only measures the requests made after its warm-up, so whatever the system
+// does while it starts up doesn't count, and repeated trials of the same setup are pooled with Combine. A trial whose
+// requests stop being served is counted as deadlocked instead of passing for a slow measurement.
+package bench
+
+import (
+	"encoding/csv"
+	"encoding/json"
+	"fmt"
+	"io"
+	"math"
+	"sort"
+	"strconv"
+	"sync"
+	"time"
+)
+
+const CSV = "csv"
+const JSON = "json"
+
+// DEADLOCK_FRACTION is how much of the end of its measurement a trial can go with requests waiting and none of them
+// served before it counts as deadlocked
+const DEADLOCK_FRACTION = 0.5
+
+// Trial collects one run's requests and messages. It is safe to use from every node's goroutine
+type Trial struct {
+	mu          sync.Mutex
+	measureFrom time.Time // end of the warm-up
+	measureTo   time.Time
+	requests    int
+	latencies   []time.Duration
+	messages    int64
+	waiting     int       // requests made at any time and not served yet
+	progress    time.Time // the last request served, or the first one made since every request was served
+}
+
+// NewTrial starts a trial at start that warms up for warmup and then measures for duration
+func NewTrial(start time.Time, warmup time.Duration, duration time.Duration) *Trial {
+	measureFrom := start.Add(warmup)
+	return &Trial{measureFrom: measureFrom, measureTo: measureFrom.Add(duration)}
+}
+
+func (t *Trial) measured(at time.Time) bool {
+	return !at.Before(t.measureFrom) && at.Before(t.measureTo)
+}
+
+// Requested counts a request made at the time given, if it was made after the warm-up
+func (t *Trial) Requested(at time.Time) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	if t.measured(at) {
+		t.requests++
+	}
+	if t.waiting == 0 {
+		t.progress = at
+	}
+	t.waiting++
+}
+
+// Served records how long a request made at requested waited until now, if it was made after the warm-up
+func (t *Trial) Served(requested time.Time, now time.Time) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	if t.measured(requested) {
+		t.latencies = append(t.latencies, now.Sub(requested))
+	}
+	t.waiting--
+	t.progress = now
+}
+
+// Sent counts a message sent at the time given, if it was sent after the warm-up
+func (t *Trial) Sent(at time.Time) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	if t.measured(at) {
+		t.messages++
+	}
+}
+
+// Result is what a trial, or every trial of a setup put together, measured. Latencies are in milliseconds
+type Result struct {
+	Algorithm          string  `json:"algorithm"`
+	Nodes              int     `json:"nodes"`
+	Rate               float64 `json:"rate"`  // requests per second per node, 0 if every node asks again as soon as it is served
+	Trial              int     `json:"trial"` // from 1, 0 for every trial combined
+	Seed               int64   `json:"seed"`  // 0 when run for real
+	Requests           int     `json:"requests"`
+	Completed          int     `json:"completed"`
+	Unfinished         int     `json:"unfinished"` // requested after the warm-up but never served
+	Messages           int64   `json:"messages"`
+	MessagesPerRequest float64 `json:"messages_per_request"`
+	Throughput         float64 `json:"throughput"` // requests served per second
+	Mean               float64 `json:"mean_ms"`
+	P50                float64 `json:"p50_ms"`
+	P90                float64 `json:"p90_ms"`
+	P99                float64 `json:"p99_ms"`
+	Max                float64 `json:"max_ms"`
+	Deadlocked         int     `json:"deadlocked"` // trials that ended with requests waiting and none served for a while, see DEADLOCK_FRACTION
+
+	latencies []time.Duration
+	duration  time.Duration
+}
+
+// Result summarises the trial. The caller fills in the setup: Algorithm, Nodes, Rate, Trial and Seed
+func (t *Trial) Result() Result {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	r := Result{Requests: t.requests, Messages: t.messages, latencies: append([]time.Duration{}, t.latencies...), duration: t.measureTo.Sub(t.measureFrom)}
+	if t.deadlocked() {
+		r.Deadlocked = 1
+	}
+	r.summarise()
+	return r
+}
+
+// deadlocked is true if requests were waiting at the end of the measurement and none had been served for the last
+// DEADLOCK_FRACTION of it, e.g. no request made after the warm-up was ever served. A system that is only slow
+// keeps serving the requests it is behind on
+func (t *Trial) deadlocked() bool {
+	stalled := t.measureTo.Sub(t.progress)
+	return t.waiting > 0 && float64(stalled) > DEADLOCK_FRACTION*float64(t.measureTo.Sub(t.measureFrom))
+}
+
+// Combine pools the requests and messages of every trial of one setup, as trial 0
+func Combine(trials []Result) Result {
+	if len(trials) == 0 {
+		return Result{}
+	}
+	combined := trials[0]
+	combined.Trial = 0
+	combined.Seed = 0
+	combined.Requests, combined.Messages, combined.latencies, combined.duration, combined.Deadlocked = 0, 0, nil, 0, 0
+	for _, trial := range trials {
+		combined.Requests += trial.Requests
+		combined.Deadlocked += trial.Deadlocked
+		combined.Messages += trial.Messages
+		combined.latencies = append(combined.latencies, trial.latencies...)
+		combined.duration += trial.duration
+	}
+	combined.summarise()
+	return combined
+}
+
+func (r *Result) summarise() {
+	sort.Slice(r.latencies, func(i, j int) bool { return r.latencies[i] < r.latencies[j] })
+	r.Completed = len(r.latencies)
+	r.Unfinished = r.Requests - r.Completed
+	r.MessagesPerRequest, r.Throughput = 0, 0
+	if r.Completed > 0 {
+		r.MessagesPerRequest = float64(r.Messages) / float64(r.Completed)
+	}
+	if r.duration > 0 {
+		r.Throughput = float64(r.Completed) / r.duration.Seconds()
+	}
+	total := time.Duration(0)
+	for _, latency := range r.latencies {
+		total += latency
+	}
+	r.Mean, r.P50, r.P90, r.P99, r.Max = 0, 0, 0, 0, 0
+	if r.Completed > 0 {
+		r.Mean = milliseconds(total / time.Duration(r.Completed))
+		r.P50 = milliseconds(Percentile(r.latencies, 50))
+		r.P90 = milliseconds(Percentile(r.latencies, 90))
+		r.P99 = milliseconds(Percentile(r.latencies, 99))
+		r.Max = milliseconds(r.latencies[r.Completed-1])
+	}
+}
+
+// Percentile is the nearest-rank percentile p (0 to 100) of latencies, which must be sorted
+func Percentile(latencies []time.Duration, p float64) time.Duration {
+	if len(latencies) == 0 {
+		return 0
+	}
+	rank := int(math.Ceil(p / 100 * float64(len(latencies))))
+	if rank < 1 {
+		rank = 1
+	}
+	return latencies[rank-1]
+}
+
+func milliseconds(d time.Duration) float64 {
+	return float64(d) / float64(time.Millisecond)
+}
+
+var CSV_HEADER = []string{"algorithm", "nodes", "rate", "trial", "seed", "requests", "completed", "unfinished",
+	"messages", "messages_per_request", "throughput", "mean_ms", "p50_ms", "p90_ms", "p99_ms", "max_ms", "deadlocked"}
+
+// Write writes the results as CSV (one row per result, after a header) or as a JSON array
+func Write(w io.Writer, format string, results []Result) error {
+	switch format {
+	case CSV:
+		writer := csv.NewWriter(w)
+		writer.Write(CSV_HEADER)
+		for _, r := range results {
+			writer.Write([]string{r.Algorithm, strconv.Itoa(r.Nodes), formatFloat(r.Rate), strconv.Itoa(r.Trial),
+				strconv.FormatInt(r.Seed, 10), strconv.Itoa(r.Requests), strconv.Itoa(r.Completed), strconv.Itoa(r.Unfinished),
+				strconv.FormatInt(r.Messages, 10), formatFloat(r.MessagesPerRequest), formatFloat(r.Throughput),
+				formatFloat(r.Mean), formatFloat(r.P50), formatFloat(r.P90), formatFloat(r.P99), formatFloat(r.Max), strconv.Itoa(r.Deadlocked)})
+		}
+		writer.Flush()
+		return writer.Error()
+	case JSON:
+		encoder := json.NewEncoder(w)
+		encoder.SetIndent("", "  ")
+		return encoder.Encode(results)
+	}
+	return fmt.Errorf("unknown format %q", format)
+}
+
+// formatFloat rounds to 3 decimals, microseconds for the latencies
+func formatFloat(v float64) string {
+	return strconv.FormatFloat(math.Round(v*1000)/1000, 'f', -1, 64)
+}
@@ -0,0 +1,57 @@
+package bench
+
+import (
+	"testing"
+	"time"
+)
+
+func TestDeadlocked(t *testing.T) {
+	start := time.Unix(0, 0)
+	at := func(d time.Duration) time.Time { return start.Add(d) }
+	tests := []struct {
+		name string
+		run  func(trial *Trial)
+		want int
+	}{
+		{"every request served", func(trial *Trial) {
+			for s := time.Duration(0); s < 11*time.Second; s += time.Second {
+				trial.Requested(at(s))
+				trial.Served(at(s), at(s+time.Millisecond))
+			}
+		}, 0},
+		{"nothing asked", func(trial *Trial) {}, 0},
+		{"served until the warm-up ends", func(trial *Trial) {
+			trial.Requested(at(0))
+			trial.Served(at(0), at(500*time.Millisecond))
+			trial.Requested(at(600 * time.Millisecond))
+		}, 1},
+		{"stops serving halfway", func(trial *Trial) {
+			for s := time.Duration(0); s < 11*time.Second; s += time.Second {
+				trial.Requested(at(s))
+			}
+			trial.Served(at(0), at(5*time.Second))
+		}, 1},
+		// behind on its requests but still serving them
+		{"slow", func(trial *Trial) {
+			for s := time.Duration(0); s < 11*time.Second; s += 100 * time.Millisecond {
+				trial.Requested(at(s))
+			}
+			for s := time.Duration(0); s < 11*time.Second; s += time.Second {
+				trial.Served(at(s/10), at(s))
+			}
+		}, 0},
+	}
+	trials := []Result{}
+	for _, test := range tests {
+		trial := NewTrial(start, time.Second, 10*time.Second)
+		test.run(trial)
+		result := trial.Result()
+		if result.Deadlocked != test.want {
+			t.Errorf("%v: Deadlocked = %v, want %v", test.name, result.Deadlocked, test.want)
+		}
+		trials = append(trials, result)
+	}
+	if combined := Combine(trials); combined.Deadlocked != 2 {
+		t.Errorf("combined trials have Deadlocked = %v, want 2", combined.Deadlocked)
+	}
+}
@@ -0,0 +1,130 @@
+// Command scenario runs scenario files (see common/scenario) against the programs they name
+// and exits with status 1 if any of them fails.
+//
+//	go run ./common/cmd/scenario scenarios/*.json
+//	go run ./common/cmd/scenario -runs 20 scenarios/ivy_leader_failover.json
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"distsys/common/scenario"
+	"flag"
+	"fmt"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strconv"
+	"strings"
+)
+
+func main() {
+	root := flag.String("root", ".", "root of the repository, where the programs are found")
+	seed := flag.Int64("seed", 0, "run with this seed instead of the scenario's own")
+	runs := flag.Int("runs", 1, "run every scenario with this many seeds, starting at its own and counting up")
+	verbose := flag.Bool("v", false, "print everything the programs print, not just the reports")
+	flag.Parse()
+
+	if flag.NArg() == 0 {
+		fmt.Println("usage: scenario [-root dir] [-seed n] [-runs n] [-v] scenario.json...")
+		os.Exit(2)
+	}
+
+	tempDir, err := os.MkdirTemp("", "scenario")
+	if err != nil {
+		fmt.Printf("could not make a directory for the programs: %v\n", err)
+		os.Exit(1)
+	}
+	defer os.RemoveAll(tempDir)
+
+	binaries := map[string]string{} // {[program]: path of the built program}
+	passed, failed := 0, 0
+	for _, path := range flag.Args() {
+		sc, err := scenario.Load(path)
+		if err != nil {
+			fmt.Printf("could not load scenario: %v\n", err)
+			failed++
+			continue
+		}
+		binary, ok := binaries[sc.Program]
+		if !ok {
+			if binary, err = build(*root, sc.Program, tempDir); err != nil {
+				fmt.Printf("could not build %v: %v\n", sc.Program, err)
+				os.Exit(1)
+			}
+			binaries[sc.Program] = binary
+		}
+
+		absolute, err := filepath.Abs(path)
+		if err != nil {
+			fmt.Printf("could not find %v: %v\n", path, err)
+			failed++
+			continue
+		}
+		first := sc.Seed
+		if *seed != 0 {
+			first = *seed
+		}
+		for run := 0; run < *runs; run++ {
+			if runScenario(binary, filepath.Join(*root, scenario.PROGRAMS[sc.Program]), absolute, first+int64(run), *verbose) {
+				passed++
+			} else {
+				failed++
+			}
+		}
+	}
+
+	fmt.Printf("%v passed, %v failed\n", passed, failed)
+	if failed > 0 {
+		os.Exit(1)
+	}
+}
+
+// build compiles the program once so every run of it starts straight away
+func build(root string, program string, tempDir string) (string, error) {
+	binary := filepath.Join(tempDir, program)
+	cmd := exec.Command("go", "build", "-o", binary, ".")
+	cmd.Dir = filepath.Join(root, scenario.PROGRAMS[program])
+	cmd.Stdout = os.Stdout
+	cmd.Stderr = os.Stderr
+	return binary, cmd.Run()
+}
+
+// runScenario runs one seed of the scenario in the program's directory and prints its report
+func runScenario(binary string, dir string, path string, seed int64, verbose bool) bool {
+	cmd := exec.Command(binary, "-scenario", path, "-seed", strconv.FormatInt(seed, 10))
+	cmd.Dir = dir
+	output := bytes.Buffer{}
+	cmd.Stdout = &output
+	cmd.Stderr = &output
+	err := cmd.Run()
+
+	reported := false
+	scanner := bufio.NewScanner(&output)
+	scanner.Buffer(make([]byte, 64*1024), 64*1024*1024)
+	for scanner.Scan() {
+		line := scanner.Text()
+		if strings.HasPrefix(line, "scenario") {
+			reported = true
+		} else if !verbose {
+			continue
+		}
+		fmt.Println(line)
+	}
+	if err != nil && !reported {
+		// the program never got as far as checking anything, show why
+		fmt.Printf("%v: seed %v: %v\n", path, seed, err)
+		if !verbose {
+			fmt.Print(tail(output.String(), 20))
+		}
+	}
+	return err == nil
+}
+
+func tail(text string, lines int) string {
+	all := strings.SplitAfter(text, "\n")
+	if len(all) > lines {
+		all = all[len(all)-lines:]
+	}
+	return strings.Join(all, "")
+}
@@ -0,0 +1,70 @@
+// Command spacetime draws trace files written with -trace (see common/trace) as a Lamport space-time diagram,
+// an SVG, or an HTML page where hovering over an event highlights everything in its trace.
+//
+//	go run ./common/cmd/spacetime -o bully.html /tmp/bully.jsonl
+//	go run ./common/cmd/spacetime -trace 0x500000007 -o write.svg /tmp/ivy.jsonl
+package main
+
+import (
+	"distsys/common/trace"
+	"flag"
+	"fmt"
+	"os"
+	"strings"
+)
+
+func main() {
+	format := flag.String("format", "", "svg | html, picked from the extension of -o if empty, html for standard output")
+	outputPath := flag.String("o", "", "file to write, standard output if empty")
+	axis := flag.String("axis", trace.LAMPORT_AXIS, "lamport: a column per Lamport time | time: to scale with the time of every event")
+	traceId := flag.Int64("trace", 0, "only draw this trace, the Trace of its events (0x for hex, like the Jaeger export's ids)")
+	from := flag.Int64("from", 0, "only draw events from this Lamport time")
+	to := flag.Int64("to", 0, "only draw events up to this Lamport time, 0 for no limit")
+	title := flag.String("title", "", "title written above the diagram")
+	flag.Parse()
+
+	if flag.NArg() == 0 {
+		fmt.Println("usage: spacetime [-format svg|html] [-o file] [-axis lamport|time] [-trace id] [-from n] [-to n] [-title text] trace.jsonl...")
+		os.Exit(2)
+	}
+	if *axis != trace.LAMPORT_AXIS && *axis != trace.TIME_AXIS {
+		fmt.Printf("unknown axis %q\n", *axis)
+		os.Exit(2)
+	}
+	if *format == "" {
+		*format = "html"
+		if strings.HasSuffix(*outputPath, ".svg") {
+			*format = "svg"
+		}
+	}
+
+	events, err := trace.Load(flag.Args()...)
+	if err != nil {
+		fmt.Printf("could not load traces: %v\n", err)
+		os.Exit(1)
+	}
+
+	output := os.Stdout
+	if *outputPath != "" {
+		if output, err = os.Create(*outputPath); err != nil {
+			fmt.Printf("could not create %v: %v\n", *outputPath, err)
+			os.Exit(1)
+		}
+		defer output.Close()
+	}
+
+	diagram := trace.SpaceTime{Axis: *axis, Trace: *traceId, From: *from, To: *to, Title: *title}
+	switch *format {
+	case "svg":
+		err = diagram.WriteSVG(output, events)
+	case "html":
+		err = diagram.WriteHTML(output, events)
+	default:
+		fmt.Printf("unknown format %q\n", *format)
+		os.Exit(1)
+	}
+	if err != nil {
+		fmt.Printf("could not write diagram: %v\n", err)
+		os.Exit(1)
+	}
+}
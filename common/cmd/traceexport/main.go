@@ -0,0 +1,53 @@
+// Command traceexport turns trace files written with -trace into a Chrome trace (open it in chrome://tracing or
+// ui.perfetto.dev) or Jaeger traces (open it in the Jaeger UI's search page with "JSON File").
+// Several files, like the ones every process of a cluster writes, are merged into one timeline.
+//
+//	go run ./common/cmd/traceexport -format chrome -o ivy.json /tmp/ivy.trace.jsonl
+package main
+
+import (
+	"distsys/common/trace"
+	"flag"
+	"fmt"
+	"os"
+)
+
+func main() {
+	format := flag.String("format", "chrome", "chrome | jaeger")
+	outputPath := flag.String("o", "", "file to write, standard output if empty")
+	flag.Parse()
+
+	if flag.NArg() == 0 {
+		fmt.Println("usage: traceexport [-format chrome|jaeger] [-o file] trace.jsonl...")
+		os.Exit(2)
+	}
+
+	events, err := trace.Load(flag.Args()...)
+	if err != nil {
+		fmt.Printf("could not load traces: %v\n", err)
+		os.Exit(1)
+	}
+
+	output := os.Stdout
+	if *outputPath != "" {
+		if output, err = os.Create(*outputPath); err != nil {
+			fmt.Printf("could not create %v: %v\n", *outputPath, err)
+			os.Exit(1)
+		}
+		defer output.Close()
+	}
+
+	switch *format {
+	case "chrome":
+		err = trace.WriteChrome(output, events)
+	case "jaeger":
+		err = trace.WriteJaeger(output, events)
+	default:
+		fmt.Printf("unknown format %q\n", *format)
+		os.Exit(1)
+	}
+	if err != nil {
+		fmt.Printf("could not write trace: %v\n", err)
+		os.Exit(1)
+	}
+}
@@ -0,0 +1,87 @@
+// Package directory decides which manager is responsible for each page of the Ivy distributed shared memory, for
+// PSet3's sharded central managers: a manager of Part1, or a shard of Raft replicated CMs in Part2
+package directory
+
+import (
+	"hash/fnv"
+	"sort"
+	"strconv"
+)
+
+// Directory decides which manager is responsible for a page
+type Directory interface {
+	ManagerOf(pageId int) int
+	NumOfManagers() int
+}
+
+// Modulo gives page pageId to manager pageId mod NumOfManagers
+type Modulo struct {
+	Managers int
+}
+
+func (d Modulo) ManagerOf(pageId int) int {
+	manager := pageId % d.Managers
+	if manager < 0 {
+		manager += d.Managers
+	}
+	return manager
+}
+
+func (d Modulo) NumOfManagers() int {
+	return d.Managers
+}
+
+// ConsistentHash places every manager on a hash ring several times,
+// a page belongs to the first manager after the page's hash going round the ring
+type ConsistentHash struct {
+	Managers int
+	ring     []ringPoint // sorted by hash
+}
+
+type ringPoint struct {
+	Hash    uint32
+	Manager int
+}
+
+func NewConsistentHash(managers int, pointsPerManager int) *ConsistentHash {
+	d := ConsistentHash{Managers: managers}
+	for manager := 0; manager < managers; manager++ {
+		for i := 0; i < pointsPerManager; i++ {
+			d.ring = append(d.ring, ringPoint{Hash: hashKey("cm-" + strconv.Itoa(manager) + "-" + strconv.Itoa(i)), Manager: manager})
+		}
+	}
+	sort.Slice(d.ring, func(i, j int) bool {
+		return d.ring[i].Hash < d.ring[j].Hash
+	})
+	return &d
+}
+
+func (d *ConsistentHash) ManagerOf(pageId int) int {
+	hash := hashKey("page-" + strconv.Itoa(pageId))
+	i := sort.Search(len(d.ring), func(i int) bool {
+		return d.ring[i].Hash >= hash
+	})
+	if i == len(d.ring) {
+		// wrap round the ring
+		i = 0
+	}
+	return d.ring[i].Manager
+}
+
+func (d *ConsistentHash) NumOfManagers() int {
+	return d.Managers
+}
+
+func hashKey(key string) uint32 {
+	h := fnv.New32a()
+	h.Write([]byte(key))
+	// FNV barely changes the high bits for keys that only differ in their last character,
+	// mix them so consecutive page ids land all over the ring
+	hash := h.Sum32()
+	hash ^= hash >> 16
+	hash *= 0x85ebca6b
+	hash ^= hash >> 13
+	hash *= 0xc2b2ae35
+	hash ^= hash >> 16
+	return hash
+}
@@ -0,0 +1,221 @@
+// Package faults sits between senders and receivers and drops, delays, duplicates and reorders messages,
+// and cuts the network into partitions on a schedule. It works the same for real runs and simulations.
+package faults
+
+import (
+	"encoding/json"
+	"fmt"
+	"math/rand"
+	"os"
+	"sync"
+	"time"
+)
+
+// Config says how often each fault happens. Probabilities are per message, between 0 and 1
+type Config struct {
+	Drop          float64 // the message is never delivered
+	Duplicate     float64 // the message is delivered twice
+	Delay         float64 // the message is held back for MinDelay to MaxDelay
+	MinDelay      Duration
+	MaxDelay      Duration
+	Reorder       float64 // the message is held back for up to ReorderWindow, so messages sent after it can overtake it
+	ReorderWindow Duration
+	Partitions    []Partition // in force between their Start and End
+	Seed          int64       // real runs only: seeds the injector's choices, 0 picks one from the time
+}
+
+// Partition splits the nodes into Groups that can't reach each other, every node not in a group is in one more group.
+// A single group of one node takes that node off the network, like a crash or a node that hasn't joined yet
+type Partition struct {
+	Start  Duration // since the injector was made
+	End    Duration // 0 never heals
+	Groups [][]int
+}
+
+// Duration is a time.Duration written as "1.5s" in JSON
+type Duration struct {
+	time.Duration
+}
+
+func (d Duration) MarshalJSON() ([]byte, error) {
+	return json.Marshal(d.String())
+}
+
+func (d *Duration) UnmarshalJSON(data []byte) error {
+	var text string
+	if err := json.Unmarshal(data, &text); err != nil {
+		return err
+	}
+	parsed, err := time.ParseDuration(text)
+	if err != nil {
+		return err
+	}
+	d.Duration = parsed
+	return nil
+}
+
+// Clock is what the injector schedules delayed messages with, sim.WallClock or a *sim.Simulator
+type Clock interface {
+	Now() time.Time
+	After(d time.Duration, f func())
+}
+
+// Injector decides the fate of every message. It is safe to use from many goroutines
+type Injector struct {
+	Config Config
+
+	clock Clock
+	start time.Time
+	mu    sync.Mutex
+	rand  *rand.Rand
+	stats Stats
+}
+
+// Stats counts the faults injected so far
+type Stats struct {
+	Sent, Dropped, Partitioned, Duplicated, Delayed, Reordered int64
+}
+
+// NewInjector makes an injector whose choices come from r, a new generator seeded with Config.Seed if r is nil
+func NewInjector(config Config, clock Clock, r *rand.Rand) *Injector {
+	if r == nil {
+		seed := config.Seed
+		if seed == 0 {
+			seed = time.Now().UnixNano()
+		}
+		r = rand.New(rand.NewSource(seed))
+	}
+	return &Injector{Config: config, clock: clock, start: clock.Now(), rand: r}
+}
+
+func LoadConfig(path string) (Config, error) {
+	config := Config{}
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return config, err
+	}
+	if err := json.Unmarshal(data, &config); err != nil {
+		return config, fmt.Errorf("%v: %v", path, err)
+	}
+	return config, nil
+}
+
+// Open loads the config at path and makes an injector for it, or returns nil if path is empty
+func Open(path string, clock Clock, r *rand.Rand) (*Injector, error) {
+	if path == "" {
+		return nil, nil
+	}
+	config, err := LoadConfig(path)
+	if err != nil {
+		return nil, err
+	}
+	return NewInjector(config, clock, r), nil
+}
+
+// Send passes the message from -> to through the faults: deliver is called zero, one or two times, now or later.
+// A node's messages to itself never fail. Safe to call on a nil Injector, which delivers straight away
+func (in *Injector) Send(from int, to int, deliver func()) {
+	if in == nil || from == to {
+		deliver()
+		return
+	}
+
+	in.mu.Lock()
+	in.stats.Sent++
+	if !in.connected(from, to) {
+		in.stats.Partitioned++
+		in.mu.Unlock()
+		return
+	}
+	if in.chance(in.Config.Drop) {
+		in.stats.Dropped++
+		in.mu.Unlock()
+		return
+	}
+	copies := 1
+	if in.chance(in.Config.Duplicate) {
+		in.stats.Duplicated++
+		copies = 2
+	}
+	delays := make([]time.Duration, copies)
+	for i := range delays {
+		if in.chance(in.Config.Delay) {
+			in.stats.Delayed++
+			delays[i] += in.between(in.Config.MinDelay.Duration, in.Config.MaxDelay.Duration)
+		}
+		if in.chance(in.Config.Reorder) {
+			in.stats.Reordered++
+			delays[i] += in.between(0, in.Config.ReorderWindow.Duration)
+		}
+	}
+	in.mu.Unlock()
+
+	for _, delay := range delays {
+		if delay == 0 {
+			deliver()
+			continue
+		}
+		in.clock.After(delay, deliver)
+	}
+}
+
+// Connected is false while a partition keeps from and to apart
+func (in *Injector) Connected(from int, to int) bool {
+	if in == nil {
+		return true
+	}
+	in.mu.Lock()
+	defer in.mu.Unlock()
+	return in.connected(from, to)
+}
+
+func (in *Injector) Stats() Stats {
+	if in == nil {
+		return Stats{}
+	}
+	in.mu.Lock()
+	defer in.mu.Unlock()
+	return in.stats
+}
+
+func (in *Injector) String() string {
+	stats := in.Stats()
+	return fmt.Sprintf("faults: %v sent, %v dropped, %v cut off by partitions, %v duplicated, %v delayed, %v reordered",
+		stats.Sent, stats.Dropped, stats.Partitioned, stats.Duplicated, stats.Delayed, stats.Reordered)
+}
+
+func (in *Injector) connected(from int, to int) bool {
+	elapsed := in.clock.Now().Sub(in.start)
+	for _, partition := range in.Config.Partitions {
+		if elapsed < partition.Start.Duration || (partition.End.Duration != 0 && elapsed >= partition.End.Duration) {
+			continue
+		}
+		if partition.groupOf(from) != partition.groupOf(to) {
+			return false
+		}
+	}
+	return true
+}
+
+// groupOf returns the index of node's group, len(Groups) for the nodes in no group
+func (p Partition) groupOf(node int) int {
+	for i, group := range p.Groups {
+		for _, member := range group {
+			if member == node {
+				return i
+			}
+		}
+	}
+	return len(p.Groups)
+}
+
+func (in *Injector) chance(probability float64) bool {
+	return probability > 0 && in.rand.Float64() < probability
+}
+
+func (in *Injector) between(min time.Duration, max time.Duration) time.Duration {
+	if max <= min {
+		return min
+	}
+	return min + time.Duration(in.rand.Int63n(int64(max-min)))
+}
@@ -0,0 +1,28 @@
+package faults
+
+import "distsys/common/sim"
+
+// Network is one node's view of a sim.Network with every message it sends going through an Injector
+type Network[M any] struct {
+	Inner    sim.Network[M]
+	From     int
+	Injector *Injector
+}
+
+// Wrap returns the network node from sends on, inner itself if there is no injector
+func Wrap[M any](inner sim.Network[M], from int, injector *Injector) sim.Network[M] {
+	if injector == nil {
+		return inner
+	}
+	return &Network[M]{Inner: inner, From: from, Injector: injector}
+}
+
+func (n *Network[M]) Send(to int, m M) {
+	n.Injector.Send(n.From, to, func() {
+		n.Inner.Send(to, m)
+	})
+}
+
+func (n *Network[M]) Size() int {
+	return n.Inner.Size()
+}
@@ -0,0 +1,243 @@
+// Package metrics keeps counters, gauges and histograms and serves them in Prometheus' text format on /metrics,
+// so a running program can be scraped by Prometheus or looked at with curl. Every metric can have labels;
+// a value is kept for every combination of label values it has been given.
+//
+//	registry := metrics.NewRegistry()
+//	requests := registry.Counter("requests_total", "Requests handled", "node")
+//	requests.Inc("3")
+//	metrics.Serve(":9100", registry)
+package metrics
+
+import (
+	"fmt"
+	"io"
+	"math"
+	"net"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+)
+
+const COUNTER = "counter"
+const GAUGE = "gauge"
+const HISTOGRAM = "histogram"
+
+// LATENCY_BUCKETS are upper bounds in seconds for histograms of how long something took
+var LATENCY_BUCKETS = []float64{0.001, 0.0025, 0.005, 0.01, 0.025, 0.05, 0.1, 0.25, 0.5, 1, 2.5, 5, 10, 30}
+
+// Registry holds every metric a program serves
+type Registry struct {
+	mu      sync.Mutex
+	metrics []*metric
+}
+
+func NewRegistry() *Registry {
+	return &Registry{}
+}
+
+// metric is one named metric and its value for every combination of label values
+type metric struct {
+	name    string
+	help    string
+	kind    string // COUNTER, GAUGE or HISTOGRAM
+	labels  []string
+	buckets []float64          // HISTOGRAM only
+	series  map[string]*series // {[label values joined by labelSeparator]: series}
+}
+
+type series struct {
+	labelValues []string
+	value       float64  // the counter or gauge, the sum of the observations for a histogram
+	counts      []uint64 // HISTOGRAM only: observations in each bucket, not cumulative, the last one is +Inf
+	count       uint64   // HISTOGRAM only
+}
+
+const labelSeparator = "\xff"
+
+func (r *Registry) add(name string, help string, kind string, buckets []float64, labels []string) *metric {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	m := &metric{name: name, help: help, kind: kind, labels: labels, buckets: buckets, series: map[string]*series{}}
+	r.metrics = append(r.metrics, m)
+	return m
+}
+
+// get returns the series for the label values, making it if it's new. The registry must be locked
+func (m *metric) get(labelValues []string) *series {
+	if len(labelValues) != len(m.labels) {
+		panic(fmt.Sprintf("metric %v has labels %v, got values %v", m.name, m.labels, labelValues))
+	}
+	key := strings.Join(labelValues, labelSeparator)
+	s, ok := m.series[key]
+	if !ok {
+		s = &series{labelValues: append([]string{}, labelValues...)}
+		if m.kind == HISTOGRAM {
+			s.counts = make([]uint64, len(m.buckets)+1)
+		}
+		m.series[key] = s
+	}
+	return s
+}
+
+// Counter only goes up. Every method is safe to call on a nil Counter, which counts nothing
+type Counter struct {
+	registry *Registry
+	metric   *metric
+}
+
+func (r *Registry) Counter(name string, help string, labels ...string) *Counter {
+	return &Counter{registry: r, metric: r.add(name, help, COUNTER, nil, labels)}
+}
+
+func (c *Counter) Inc(labelValues ...string) {
+	c.Add(1, labelValues...)
+}
+
+func (c *Counter) Add(v float64, labelValues ...string) {
+	if c == nil {
+		return
+	}
+	c.registry.mu.Lock()
+	defer c.registry.mu.Unlock()
+	c.metric.get(labelValues).value += v
+}
+
+// Gauge goes up and down. Every method is safe to call on a nil Gauge
+type Gauge struct {
+	registry *Registry
+	metric   *metric
+}
+
+func (r *Registry) Gauge(name string, help string, labels ...string) *Gauge {
+	return &Gauge{registry: r, metric: r.add(name, help, GAUGE, nil, labels)}
+}
+
+func (g *Gauge) Set(v float64, labelValues ...string) {
+	if g == nil {
+		return
+	}
+	g.registry.mu.Lock()
+	defer g.registry.mu.Unlock()
+	g.metric.get(labelValues).value = v
+}
+
+func (g *Gauge) Add(v float64, labelValues ...string) {
+	if g == nil {
+		return
+	}
+	g.registry.mu.Lock()
+	defer g.registry.mu.Unlock()
+	g.metric.get(labelValues).value += v
+}
+
+// Histogram counts observations into buckets. Every method is safe to call on a nil Histogram
+type Histogram struct {
+	registry *Registry
+	metric   *metric
+}
+
+// Histogram makes a histogram with these upper bounds, in increasing order. A +Inf bucket is always added
+func (r *Registry) Histogram(name string, help string, buckets []float64, labels ...string) *Histogram {
+	return &Histogram{registry: r, metric: r.add(name, help, HISTOGRAM, buckets, labels)}
+}
+
+func (h *Histogram) Observe(v float64, labelValues ...string) {
+	if h == nil {
+		return
+	}
+	h.registry.mu.Lock()
+	defer h.registry.mu.Unlock()
+	s := h.metric.get(labelValues)
+	bucket := sort.SearchFloat64s(h.metric.buckets, v) // the first bound >= v, len(buckets) for +Inf
+	s.counts[bucket]++
+	s.count++
+	s.value += v
+}
+
+// Write writes every metric in Prometheus' text format, series sorted by their label values
+func (r *Registry) Write(w io.Writer) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	b := &strings.Builder{}
+	for _, m := range r.metrics {
+		fmt.Fprintf(b, "# HELP %v %v\n", m.name, escapeHelp(m.help))
+		fmt.Fprintf(b, "# TYPE %v %v\n", m.name, m.kind)
+		keys := []string{}
+		for key := range m.series {
+			keys = append(keys, key)
+		}
+		sort.Strings(keys)
+		for _, key := range keys {
+			s := m.series[key]
+			if m.kind != HISTOGRAM {
+				fmt.Fprintf(b, "%v%v %v\n", m.name, labelText(m.labels, s.labelValues, "", ""), formatFloat(s.value))
+				continue
+			}
+			cumulative := uint64(0)
+			for i, count := range s.counts {
+				cumulative += count
+				bound := math.Inf(1)
+				if i < len(m.buckets) {
+					bound = m.buckets[i]
+				}
+				fmt.Fprintf(b, "%v_bucket%v %v\n", m.name, labelText(m.labels, s.labelValues, "le", formatFloat(bound)), cumulative)
+			}
+			fmt.Fprintf(b, "%v_sum%v %v\n", m.name, labelText(m.labels, s.labelValues, "", ""), formatFloat(s.value))
+			fmt.Fprintf(b, "%v_count%v %v\n", m.name, labelText(m.labels, s.labelValues, "", ""), s.count)
+		}
+	}
+	_, err := io.WriteString(w, b.String())
+	return err
+}
+
+func (r *Registry) ServeHTTP(w http.ResponseWriter, req *http.Request) {
+	w.Header().Set("Content-Type", "text/plain; version=0.0.4; charset=utf-8")
+	r.Write(w)
+}
+
+// Serve serves the registry on http://addr/metrics in the background. It only returns an error if it can't listen
+func Serve(addr string, r *Registry) error {
+	listener, err := net.Listen("tcp", addr)
+	if err != nil {
+		return err
+	}
+	mux := http.NewServeMux()
+	mux.Handle("/metrics", r)
+	go http.Serve(listener, mux)
+	return nil
+}
+
+// labelText is {name="value",...}, with one more label if extraName isn't empty, or nothing without any labels
+func labelText(names []string, values []string, extraName string, extraValue string) string {
+	pairs := []string{}
+	for i, name := range names {
+		pairs = append(pairs, fmt.Sprintf("%v=\"%v\"", name, escapeLabel(values[i])))
+	}
+	if extraName != "" {
+		pairs = append(pairs, fmt.Sprintf("%v=\"%v\"", extraName, extraValue))
+	}
+	if len(pairs) == 0 {
+		return ""
+	}
+	return "{" + strings.Join(pairs, ",") + "}"
+}
+
+func escapeLabel(value string) string {
+	return strings.NewReplacer(`\`, `\\`, `"`, `\"`, "\n", `\n`).Replace(value)
+}
+
+func escapeHelp(help string) string {
+	return strings.NewReplacer(`\`, `\\`, "\n", `\n`).Replace(help)
+}
+
+func formatFloat(v float64) string {
+	switch {
+	case math.IsInf(v, 1):
+		return "+Inf"
+	case math.IsInf(v, -1):
+		return "-Inf"
+	}
+	return strconv.FormatFloat(v, 'g', -1, 64)
+}
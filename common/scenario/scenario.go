@@ -0,0 +1,132 @@
+// Package scenario loads scenario files: JSON descriptions of a whole run of one of the programs, with how many nodes
+// it has, what they do, when they crash and recover, what the network does to their messages and what must still
+// hold at the end. Run them with common/cmd/scenario, or pass one to a program with -scenario.
+package scenario
+
+import (
+	"distsys/common/faults"
+	"encoding/json"
+	"fmt"
+	"math/rand"
+	"os"
+	"strings"
+)
+
+const BULLY = "bully"
+const IVY = "ivy"
+
+// PROGRAMS is where each program that takes -scenario lives, relative to the repository root
+var PROGRAMS = map[string]string{
+	BULLY: "PSet1/BullyAlgorithm/P2_1",
+	IVY:   "PSet3/Part2",
+}
+
+// Scenario is one scenario file. Fields left out keep the program's defaults
+type Scenario struct {
+	Name     string
+	Program  string          // BULLY or IVY
+	Seed     int64           // scenarios always run in the simulator so a failure can be replayed, 0 is seed 1
+	Duration faults.Duration // virtual time the run lasts
+	Nodes    int             // bully: machines, ivy: processors
+	Managers int             // ivy: CMs in every shard
+	Shards   int             // ivy: Raft groups of CMs the pages are sharded across
+	Pages    int             // ivy: number of pages the random requests pick from
+	Timeout  faults.Duration // bully: ping and election timeout, ivy: request timeout. Whole seconds
+	Workload Workload
+	Crashes  []Crash
+	Faults   *faults.Config // nil injects no faults
+	Expect   Expect
+}
+
+type Workload struct {
+	Senders     []int           // bully: machines that ping the coordinator, the default is the one before the last
+	ReadPercent *int            // ivy: percent of random requests that are reads, a coin flip if left out
+	Interval    faults.Duration // ivy: random requests come at most this far apart
+}
+
+// Crash takes a node down At a time for For, or for good if For is 0.
+// Node ids are the ones faults uses: bully machines are their ids, ivy processors are 0 to Nodes-1 and CMs follow
+type Crash struct {
+	Node int
+	At   faults.Duration
+	For  faults.Duration
+}
+
+// Expect lists the invariants checked at the end of the run, only the ones that are set are checked
+type Expect struct {
+	Leader                 *int // bully: every machine that is up ends with this coordinator
+	LeaderElected          bool // ivy: every shard ends with a live leader
+	SequentiallyConsistent bool // ivy: the read and write history passes checkhistory
+	MinOperations          int  // ivy: at least this many reads and writes completed
+}
+
+func Load(path string) (*Scenario, error) {
+	file, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+	scenario := &Scenario{}
+	decoder := json.NewDecoder(file)
+	decoder.DisallowUnknownFields() // a misspelt field would otherwise quietly test nothing
+	if err := decoder.Decode(scenario); err != nil {
+		return nil, fmt.Errorf("%v: %v", path, err)
+	}
+	if _, ok := PROGRAMS[scenario.Program]; !ok {
+		return nil, fmt.Errorf("%v: unknown program %q", path, scenario.Program)
+	}
+	if scenario.Name == "" {
+		scenario.Name = path
+	}
+	if scenario.Seed == 0 {
+		scenario.Seed = 1
+	}
+	for _, crash := range scenario.Crashes {
+		if crash.Node < 0 || scenario.Nodes != 0 && scenario.Program == BULLY && crash.Node >= scenario.Nodes {
+			return nil, fmt.Errorf("%v: no node %v to crash", path, crash.Node)
+		}
+	}
+	return scenario, nil
+}
+
+// Injector makes the injector for the scenario's faults, nil if it has none
+func (s *Scenario) Injector(clock faults.Clock, r *rand.Rand) *faults.Injector {
+	if s.Faults == nil {
+		return nil
+	}
+	return faults.NewInjector(*s.Faults, clock, r)
+}
+
+// Report collects the invariants that didn't hold
+type Report struct {
+	Name     string
+	Seed     int64
+	Failures []string
+}
+
+func NewReport(s *Scenario) *Report {
+	return &Report{Name: s.Name, Seed: s.Seed}
+}
+
+// Expect records a failure if ok is false
+func (r *Report) Expect(ok bool, format string, args ...interface{}) {
+	if !ok {
+		r.Failures = append(r.Failures, fmt.Sprintf(format, args...))
+	}
+}
+
+func (r *Report) Passed() bool {
+	return len(r.Failures) == 0
+}
+
+// String is the last thing a program prints, every line starts with "scenario" so the runner can pick them out
+func (r *Report) String() string {
+	if r.Passed() {
+		return fmt.Sprintf("scenario %q seed %v: passed", r.Name, r.Seed)
+	}
+	lines := []string{fmt.Sprintf("scenario %q seed %v: FAILED", r.Name, r.Seed)}
+	for _, failure := range r.Failures {
+		lines = append(lines, "scenario   "+failure)
+	}
+	return strings.Join(lines, "\n")
+}
@@ -0,0 +1,60 @@
+package sim
+
+// Network sends messages of type M to the nodes 0 to Size()-1
+type Network[M any] interface {
+	Send(to int, m M)
+	Size() int
+}
+
+// ChannelNetwork gives every node a buffered channel, each node's loop receives from its own Inbox
+type ChannelNetwork[M any] struct {
+	Channels []chan M
+}
+
+func NewChannelNetwork[M any](numOfNodes int, bufferSize int) *ChannelNetwork[M] {
+	network := ChannelNetwork[M]{Channels: make([]chan M, numOfNodes)}
+	for i := range network.Channels {
+		network.Channels[i] = make(chan M, bufferSize)
+	}
+	return &network
+}
+
+func (n *ChannelNetwork[M]) Send(to int, m M) {
+	n.Channels[to] <- m
+}
+
+func (n *ChannelNetwork[M]) Size() int {
+	return len(n.Channels)
+}
+
+func (n *ChannelNetwork[M]) Inbox(id int) <-chan M {
+	return n.Channels[id]
+}
+
+// SimNetwork delivers every message by calling the receiver's handler from the Simulator
+type SimNetwork[M any] struct {
+	Sim      *Simulator
+	Handlers []func(M)
+	Base     int // the simulator address of node 0, so several networks can share a Simulator
+}
+
+func NewSimNetwork[M any](s *Simulator, numOfNodes int) *SimNetwork[M] {
+	return &SimNetwork[M]{Sim: s, Handlers: make([]func(M), numOfNodes)}
+}
+
+// Handle sets the function node id's messages are delivered to
+func (n *SimNetwork[M]) Handle(id int, handler func(M)) {
+	n.Handlers[id] = handler
+}
+
+func (n *SimNetwork[M]) Send(to int, m M) {
+	n.Sim.Deliver(n.Base+to, func() {
+		if handler := n.Handlers[to]; handler != nil {
+			handler(m)
+		}
+	})
+}
+
+func (n *SimNetwork[M]) Size() int {
+	return len(n.Handlers)
+}
@@ -0,0 +1,62 @@
+package sim
+
+import (
+	"math/rand"
+	"time"
+)
+
+// Runtime is everything a node needs from the world besides its network: time, timers and randomness.
+// Nodes use a RealRuntime when they run for real and a *Simulator when they run in a simulation
+type Runtime interface {
+	Now() time.Time
+	After(d time.Duration, f func()) // runs f after d, never at the same time as the node's message handling
+	Intn(n int) int
+	Int63n(n int64) int64
+}
+
+// RealRuntime runs timers on the wall clock. A timer that fires puts its function on Events,
+// the node's own loop has to receive from Events and run what it gets
+type RealRuntime struct {
+	Events chan func()
+}
+
+const REAL_RUNTIME_EVENTS_SIZE = 100
+
+func NewRealRuntime() *RealRuntime {
+	return &RealRuntime{Events: make(chan func(), REAL_RUNTIME_EVENTS_SIZE)}
+}
+
+func (r *RealRuntime) Now() time.Time {
+	return time.Now()
+}
+
+func (r *RealRuntime) After(d time.Duration, f func()) {
+	time.AfterFunc(d, func() { r.Events <- f })
+}
+
+func (r *RealRuntime) Intn(n int) int {
+	return rand.Intn(n)
+}
+
+func (r *RealRuntime) Int63n(n int64) int64 {
+	return rand.Int63n(n)
+}
+
+// EventsOf returns the channel a node's loop receives its timers from, nil (never ready) in a simulation
+func EventsOf(r Runtime) <-chan func() {
+	if real, ok := r.(*RealRuntime); ok {
+		return real.Events
+	}
+	return nil
+}
+
+// WallClock runs timers on their own goroutines, for callbacks that are safe to run from any goroutine such as a channel send
+type WallClock struct{}
+
+func (WallClock) Now() time.Time {
+	return time.Now()
+}
+
+func (WallClock) After(d time.Duration, f func()) {
+	time.AfterFunc(d, f)
+}
@@ -0,0 +1,155 @@
+// Package sim runs distributed protocols deterministically: one thread, a virtual clock, a seeded random number
+// generator and a network whose delivery order is picked by that generator. A run is replayed exactly by running
+// it again with the same seed.
+package sim
+
+import (
+	"container/heap"
+	"math/rand"
+	"time"
+)
+
+const DEFAULT_MIN_LATENCY = 1 * time.Millisecond
+const DEFAULT_MAX_LATENCY = 10 * time.Millisecond
+
+// Simulator is a discrete event simulation. It implements Runtime, so a node given a Simulator instead of a
+// RealRuntime has its timers run on the virtual clock
+type Simulator struct {
+	Seed       int64
+	Rand       *rand.Rand
+	MinLatency time.Duration // every message takes between MinLatency and MaxLatency to arrive
+	MaxLatency time.Duration
+	FIFO       bool // messages to the same address arrive in the order they were sent, like a Go channel
+
+	now          time.Time
+	events       eventQueue
+	nextSeq      int64
+	lastDelivery map[int]time.Time // {[address]: time the last message sent there arrives}
+	steps        int64
+}
+
+type event struct {
+	At  time.Time
+	Seq int64 // events at the same time run in the order they were scheduled
+	Run func()
+}
+
+func New(seed int64) *Simulator {
+	return &Simulator{
+		Seed:         seed,
+		Rand:         rand.New(rand.NewSource(seed)),
+		MinLatency:   DEFAULT_MIN_LATENCY,
+		MaxLatency:   DEFAULT_MAX_LATENCY,
+		FIFO:         true,
+		now:          time.Unix(0, 0).UTC(),
+		lastDelivery: map[int]time.Time{},
+	}
+}
+
+func (s *Simulator) Now() time.Time {
+	return s.now
+}
+
+// Elapsed is the virtual time since the simulation started
+func (s *Simulator) Elapsed() time.Duration {
+	return s.now.Sub(time.Unix(0, 0))
+}
+
+// Steps is the number of events run so far
+func (s *Simulator) Steps() int64 {
+	return s.steps
+}
+
+func (s *Simulator) Intn(n int) int {
+	return s.Rand.Intn(n)
+}
+
+func (s *Simulator) Int63n(n int64) int64 {
+	return s.Rand.Int63n(n)
+}
+
+// After runs f once the virtual clock has moved on by d
+func (s *Simulator) After(d time.Duration, f func()) {
+	if d < 0 {
+		d = 0
+	}
+	s.schedule(s.now.Add(d), f)
+}
+
+// Deliver runs f, the arrival of a message at address, after a random latency.
+// Addresses are simulator wide, every node's inbox needs its own
+func (s *Simulator) Deliver(address int, f func()) {
+	at := s.now.Add(s.Latency())
+	if s.FIFO {
+		if last, ok := s.lastDelivery[address]; ok && at.Before(last) {
+			at = last
+		}
+		s.lastDelivery[address] = at
+	}
+	s.schedule(at, f)
+}
+
+// Latency draws a message delay between MinLatency and MaxLatency
+func (s *Simulator) Latency() time.Duration {
+	if s.MaxLatency <= s.MinLatency {
+		return s.MinLatency
+	}
+	return s.MinLatency + time.Duration(s.Rand.Int63n(int64(s.MaxLatency-s.MinLatency)))
+}
+
+// Step runs the next event, false if there are none left
+func (s *Simulator) Step() bool {
+	if s.events.Len() == 0 {
+		return false
+	}
+	e := heap.Pop(&s.events).(event)
+	s.now = e.At
+	s.steps++
+	e.Run()
+	return true
+}
+
+// Run runs events until the virtual clock passes d from now or there is nothing left to run
+func (s *Simulator) Run(d time.Duration) {
+	s.RunUntil(func() bool { return false }, d)
+}
+
+// RunUntil runs events until done is true, the virtual clock passes d from now or there is nothing left to run.
+// It returns whether done became true
+func (s *Simulator) RunUntil(done func() bool, d time.Duration) bool {
+	deadline := s.now.Add(d)
+	for !done() {
+		if s.events.Len() == 0 || s.events[0].At.After(deadline) {
+			if s.now.Before(deadline) {
+				s.now = deadline
+			}
+			return false
+		}
+		s.Step()
+	}
+	return true
+}
+
+func (s *Simulator) schedule(at time.Time, f func()) {
+	s.nextSeq++
+	heap.Push(&s.events, event{At: at, Seq: s.nextSeq, Run: f})
+}
+
+// eventQueue is a min heap of events by time, then by the order they were scheduled
+type eventQueue []event
+
+func (q eventQueue) Len() int { return len(q) }
+func (q eventQueue) Less(i, j int) bool {
+	if q[i].At.Equal(q[j].At) {
+		return q[i].Seq < q[j].Seq
+	}
+	return q[i].At.Before(q[j].At)
+}
+func (q eventQueue) Swap(i, j int)       { q[i], q[j] = q[j], q[i] }
+func (q *eventQueue) Push(x interface{}) { *q = append(*q, x.(event)) }
+func (q *eventQueue) Pop() interface{} {
+	old := *q
+	e := old[len(old)-1]
+	*q = old[:len(old)-1]
+	return e
+}
@@ -0,0 +1,187 @@
+package trace
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"sort"
+)
+
+// chromeEvent is one entry of the Trace Event Format read by chrome://tracing and ui.perfetto.dev
+type chromeEvent struct {
+	Name      string                 `json:"name"`
+	Category  string                 `json:"cat,omitempty"`
+	Phase     string                 `json:"ph"`
+	Timestamp float64                `json:"ts"` // microseconds
+	Duration  float64                `json:"dur,omitempty"`
+	Pid       int                    `json:"pid"`
+	Tid       int                    `json:"tid"`
+	Id        string                 `json:"id,omitempty"`
+	BindPoint string                 `json:"bp,omitempty"`
+	Args      map[string]interface{} `json:"args,omitempty"`
+}
+
+// WriteChrome writes the events in Chrome's Trace Event Format: every node is a process with its events on one row,
+// and an arrow joins every send to its receive
+func WriteChrome(w io.Writer, events []Event) error {
+	/**
+	1. Name every node's row
+	2. Every event is a 1 microsecond slice, flow arrows have to start and end on slices
+	3. Start an arrow at every send that has a receive, end it at the receive
+	*/
+	start := startTime(events)
+	chrome := []chromeEvent{}
+	for _, node := range nodes(events) {
+		chrome = append(chrome, chromeEvent{Name: "process_name", Phase: "M", Pid: node.Node, Args: map[string]interface{}{"name": node.NodeName}},
+			chromeEvent{Name: "process_sort_index", Phase: "M", Pid: node.Node, Args: map[string]interface{}{"sort_index": node.Node}})
+	}
+
+	received := map[int64]bool{}
+	for _, event := range events {
+		if event.Kind == RECEIVE && event.Parent != 0 {
+			received[event.Parent] = true
+		}
+	}
+	for _, event := range events {
+		ts := float64(event.Time-start) / 1000
+		args := map[string]interface{}{"lamport": event.Lamport, "id": event.Id, "trace": event.Trace}
+		if event.PageId != NO_PAGE {
+			args["page"] = event.PageId
+		}
+		if event.Peer != NO_PEER {
+			args["peer"] = event.Peer
+		}
+		chrome = append(chrome, chromeEvent{Name: event.Type, Category: event.Kind, Phase: "X", Timestamp: ts, Duration: 1, Pid: event.Node, Args: args})
+
+		switch {
+		case event.Kind == SEND && received[event.Id]:
+			chrome = append(chrome, chromeEvent{Name: event.Type, Category: "message", Phase: "s", Timestamp: ts, Pid: event.Node, Id: fmt.Sprint(event.Id)})
+		case event.Kind == RECEIVE && event.Parent != 0:
+			chrome = append(chrome, chromeEvent{Name: event.Type, Category: "message", Phase: "f", BindPoint: "e", Timestamp: ts, Pid: event.Node, Id: fmt.Sprint(event.Parent)})
+		}
+	}
+	return json.NewEncoder(w).Encode(map[string]interface{}{"traceEvents": chrome, "displayTimeUnit": "ms"})
+}
+
+type jaegerTrace struct {
+	TraceId   string                   `json:"traceID"`
+	Spans     []jaegerSpan             `json:"spans"`
+	Processes map[string]jaegerProcess `json:"processes"`
+}
+
+type jaegerSpan struct {
+	TraceId       string            `json:"traceID"`
+	SpanId        string            `json:"spanID"`
+	OperationName string            `json:"operationName"`
+	References    []jaegerReference `json:"references"`
+	StartTime     int64             `json:"startTime"` // microseconds since the epoch
+	Duration      int64             `json:"duration"`  // microseconds
+	Tags          []jaegerTag       `json:"tags"`
+	ProcessId     string            `json:"processID"`
+}
+
+type jaegerReference struct {
+	RefType string `json:"refType"`
+	TraceId string `json:"traceID"`
+	SpanId  string `json:"spanID"`
+}
+
+type jaegerTag struct {
+	Key   string      `json:"key"`
+	Type  string      `json:"type"`
+	Value interface{} `json:"value"`
+}
+
+type jaegerProcess struct {
+	ServiceName string      `json:"serviceName"`
+	Tags        []jaegerTag `json:"tags"`
+}
+
+// WriteJaeger writes the events as Jaeger traces, in the JSON the Jaeger UI opens from a file.
+// Every event is a span and every trace started by Tracer.Begin is a Jaeger trace. A receive's span lasts from
+// its send to when it arrived, so the time a message spent on the network shows up as the span's length
+func WriteJaeger(w io.Writer, events []Event) error {
+	sendTimes := map[int64]int64{}
+	for _, event := range events {
+		if event.Kind == SEND {
+			sendTimes[event.Id] = event.Time
+		}
+	}
+	traceOf := map[int64]int64{}
+	for _, event := range events {
+		traceOf[event.Id] = event.Trace
+	}
+
+	traces := map[int64]*jaegerTrace{}
+	order := []int64{}
+	for _, event := range events {
+		t, ok := traces[event.Trace]
+		if !ok {
+			t = &jaegerTrace{TraceId: spanId(event.Trace), Processes: map[string]jaegerProcess{}}
+			traces[event.Trace] = t
+			order = append(order, event.Trace)
+		}
+		processId := fmt.Sprintf("p%v", event.Node)
+		t.Processes[processId] = jaegerProcess{ServiceName: event.NodeName, Tags: []jaegerTag{}}
+
+		start := event.Time
+		if sent, ok := sendTimes[event.Parent]; ok && event.Kind == RECEIVE {
+			start = sent
+		}
+		references := []jaegerReference{}
+		if event.Parent != 0 && traceOf[event.Parent] == event.Trace {
+			// a parent in another trace or in a file that wasn't loaded would only confuse the UI
+			references = append(references, jaegerReference{RefType: "CHILD_OF", TraceId: t.TraceId, SpanId: spanId(event.Parent)})
+		}
+		tags := []jaegerTag{{Key: "kind", Type: "string", Value: event.Kind}, {Key: "lamport", Type: "int64", Value: event.Lamport}}
+		if event.PageId != NO_PAGE {
+			tags = append(tags, jaegerTag{Key: "page", Type: "int64", Value: event.PageId})
+		}
+		if event.Peer != NO_PEER {
+			tags = append(tags, jaegerTag{Key: "peer", Type: "int64", Value: event.Peer})
+		}
+		t.Spans = append(t.Spans, jaegerSpan{TraceId: t.TraceId, SpanId: spanId(event.Id), OperationName: event.Kind + " " + event.Type,
+			References: references, StartTime: start / 1000, Duration: (event.Time-start)/1000 + 1, Tags: tags, ProcessId: processId})
+	}
+
+	data := []*jaegerTrace{}
+	for _, id := range order {
+		data = append(data, traces[id])
+	}
+	return json.NewEncoder(w).Encode(map[string]interface{}{"data": data})
+}
+
+func spanId(id int64) string {
+	return fmt.Sprintf("%016x", id)
+}
+
+func startTime(events []Event) int64 {
+	if len(events) == 0 {
+		return 0
+	}
+	start := events[0].Time
+	for _, event := range events {
+		if event.Time < start {
+			start = event.Time
+		}
+	}
+	return start
+}
+
+// nodes returns the first event of every node, sorted by node
+func nodes(events []Event) []Event {
+	first := map[int]Event{}
+	for _, event := range events {
+		if _, ok := first[event.Node]; !ok {
+			first[event.Node] = event
+		}
+	}
+	result := []Event{}
+	for _, event := range first {
+		result = append(result, event)
+	}
+	sort.Slice(result, func(i, j int) bool {
+		return result[i].Node < result[j].Node
+	})
+	return result
+}
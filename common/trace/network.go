@@ -0,0 +1,32 @@
+package trace
+
+import "distsys/common/sim"
+
+// Traceable is a message that can describe itself and carry a Context
+type Traceable[M any] interface {
+	TraceInfo() Info
+	WithTrace(ctx Context) M
+}
+
+// Network records every message one node sends on the inner network and stamps it with the node's Context.
+// The receiver still has to call Tracer.Receive when it handles the message
+type Network[M Traceable[M]] struct {
+	Inner  sim.Network[M]
+	Tracer *Tracer
+}
+
+// Wrap returns the network the node with this tracer sends on, inner itself if tracer is nil
+func Wrap[M Traceable[M]](inner sim.Network[M], tracer *Tracer) sim.Network[M] {
+	if tracer == nil {
+		return inner
+	}
+	return &Network[M]{Inner: inner, Tracer: tracer}
+}
+
+func (n *Network[M]) Send(to int, m M) {
+	n.Inner.Send(to, m.WithTrace(n.Tracer.Send(to, m.TraceInfo())))
+}
+
+func (n *Network[M]) Size() int {
+	return n.Inner.Size()
+}
@@ -0,0 +1,281 @@
+package trace
+
+import (
+	"fmt"
+	"html"
+	"io"
+	"sort"
+	"strings"
+	"time"
+)
+
+const LAMPORT_AXIS = "lamport"
+const TIME_AXIS = "time"
+
+// SpaceTime picks what a space-time diagram shows
+type SpaceTime struct {
+	Axis  string // LAMPORT_AXIS (default): one column per Lamport time, TIME_AXIS: to scale with the events' Time
+	Trace int64  // only the events of this trace, 0 for every trace
+	From  int64  // only events from this Lamport time
+	To    int64  // up to this Lamport time, 0 for no limit
+	Title string
+}
+
+const (
+	COLUMN_WIDTH = 28  // pixels between two Lamport times
+	ROW_HEIGHT   = 70  // pixels between two nodes
+	LEFT_MARGIN  = 130 // room for the node names
+	TOP_MARGIN   = 50
+	DOT_RADIUS   = 4
+)
+
+// colours of the message types, in the order of the legend
+var PALETTE = []string{"#1f77b4", "#ff7f0e", "#2ca02c", "#d62728", "#9467bd", "#8c564b", "#e377c2", "#17becf", "#bcbd22", "#7f7f7f"}
+
+// point is where an event is drawn
+type point struct {
+	x, y  float64
+	event Event
+}
+
+// layout places every event of the diagram, a node per row and time going right
+type layout struct {
+	points  map[int64]point // {[event id]: point}
+	rows    []Event         // the first event of every node shown, in row order
+	rowY    map[int]float64 // {[node]: y of its line}
+	width   float64
+	height  float64
+	shown   []Event
+	dropped map[int64]bool // sends that never arrived anywhere in the whole trace, not just what is shown
+}
+
+func (s SpaceTime) layout(events []Event) layout {
+	/**
+	1. Keep the events the diagram is about. Whether a send arrived is decided on every event, so a message
+	   received outside the window isn't drawn as lost
+	2. Give every node a row
+	3. Give every event a column: its rank among the Lamport times shown, or its Time to scale
+	*/
+	received := map[int64]bool{}
+	for _, event := range events {
+		if event.Kind == RECEIVE && event.Parent != 0 {
+			received[event.Parent] = true
+		}
+	}
+	l := layout{points: map[int64]point{}, rowY: map[int]float64{}, dropped: map[int64]bool{}}
+	for _, event := range events {
+		if s.Trace != 0 && event.Trace != s.Trace || event.Lamport < s.From || s.To != 0 && event.Lamport > s.To {
+			continue
+		}
+		l.shown = append(l.shown, event)
+		if event.Kind == SEND && !received[event.Id] {
+			l.dropped[event.Id] = true
+		}
+	}
+
+	l.rows = nodes(l.shown)
+	for i, node := range l.rows {
+		l.rowY[node.Node] = TOP_MARGIN + float64(i)*ROW_HEIGHT
+	}
+
+	lamports := []int64{}
+	seen := map[int64]bool{}
+	for _, event := range l.shown {
+		if !seen[event.Lamport] {
+			seen[event.Lamport] = true
+			lamports = append(lamports, event.Lamport)
+		}
+	}
+	sort.Slice(lamports, func(i, j int) bool { return lamports[i] < lamports[j] })
+	columnOf := map[int64]int{}
+	for i, lamport := range lamports {
+		columnOf[lamport] = i
+	}
+	l.width = LEFT_MARGIN + float64(len(lamports)+1)*COLUMN_WIDTH
+
+	start, end := startTime(l.shown), int64(0)
+	for _, event := range l.shown {
+		if event.Time > end {
+			end = event.Time
+		}
+	}
+	for _, event := range l.shown {
+		x := LEFT_MARGIN + float64(columnOf[event.Lamport]+1)*COLUMN_WIDTH
+		if s.Axis == TIME_AXIS && end > start {
+			x = LEFT_MARGIN + COLUMN_WIDTH + float64(event.Time-start)/float64(end-start)*float64(len(lamports)-1)*COLUMN_WIDTH
+		}
+		l.points[event.Id] = point{x: x, y: l.rowY[event.Node], event: event}
+	}
+	l.height = TOP_MARGIN + float64(len(l.rows))*ROW_HEIGHT
+	return l
+}
+
+// WriteSVG draws the events as a space-time diagram: a line per node, a dot per event, an arrow from every send
+// to its receive and a cross where a message was lost. Hovering over anything shows what it is
+func (s SpaceTime) WriteSVG(w io.Writer, events []Event) error {
+	l := s.layout(events)
+	types := messageTypes(l.shown)
+	legendHeight := float64(len(types)/4+2) * 20
+
+	b := &strings.Builder{}
+	fmt.Fprintf(b, `<svg xmlns="http://www.w3.org/2000/svg" width="%.0f" height="%.0f" font-family="sans-serif" font-size="11">`+"\n",
+		l.width+COLUMN_WIDTH, l.height+legendHeight)
+	b.WriteString(`<rect width="100%" height="100%" fill="white"/>` + "\n")
+	colours := map[string]string{}
+	for i, messageType := range types {
+		colours[messageType] = PALETTE[i%len(PALETTE)]
+		fmt.Fprintf(b, `<marker id="arrow%v" viewBox="0 0 10 10" refX="10" refY="5" markerWidth="7" markerHeight="7" orient="auto">`+
+			`<path d="M0,0 L10,5 L0,10 z" fill="%v"/></marker>`+"\n", i, colours[messageType])
+	}
+	if s.Title != "" {
+		fmt.Fprintf(b, `<text x="10" y="20" font-size="14" font-weight="bold">%v</text>`+"\n", html.EscapeString(s.Title))
+	}
+
+	for _, node := range l.rows {
+		y := l.rowY[node.Node]
+		fmt.Fprintf(b, `<text x="10" y="%.0f" dominant-baseline="middle">%v</text>`+"\n", y, html.EscapeString(node.NodeName))
+		fmt.Fprintf(b, `<line x1="%v" y1="%.0f" x2="%.0f" y2="%.0f" stroke="#999"/>`+"\n", LEFT_MARGIN, y, l.width, y)
+	}
+
+	typeIndex := map[string]int{}
+	for i, messageType := range types {
+		typeIndex[messageType] = i
+	}
+	for _, event := range l.shown {
+		p := l.points[event.Id]
+		switch event.Kind {
+		case RECEIVE:
+			send, ok := l.points[event.Parent]
+			if !ok {
+				break
+			}
+			if send.y == p.y {
+				// a message to itself arcs over the node's line
+				fmt.Fprintf(b, `<path class="message" data-trace="%v" d="M%.1f,%.1f Q%.1f,%.1f %.1f,%.1f" fill="none" stroke="%v" marker-end="url(#arrow%v)"><title>%v</title></path>`+"\n",
+					event.Trace, send.x, send.y, (send.x+p.x)/2, p.y-ROW_HEIGHT/3, p.x, p.y, colours[event.Type], typeIndex[event.Type], html.EscapeString(describeMessage(send.event, event)))
+				break
+			}
+			fmt.Fprintf(b, `<line class="message" data-trace="%v" x1="%.1f" y1="%.1f" x2="%.1f" y2="%.1f" stroke="%v" marker-end="url(#arrow%v)"><title>%v</title></line>`+"\n",
+				event.Trace, send.x, send.y, p.x, p.y, colours[event.Type], typeIndex[event.Type], html.EscapeString(describeMessage(send.event, event)))
+		case SEND:
+			if !l.dropped[event.Id] {
+				break
+			}
+			// a lost message heads for its receiver's row and stops halfway there
+			toY := p.y + ROW_HEIGHT/2
+			if y, ok := l.rowY[event.Peer]; ok {
+				toY = p.y + (y-p.y)/2
+			}
+			toX := p.x + COLUMN_WIDTH
+			fmt.Fprintf(b, `<g class="message" data-trace="%v"><title>%v lost</title><line x1="%.1f" y1="%.1f" x2="%.1f" y2="%.1f" stroke="%v" stroke-dasharray="3,3"/>`+
+				`<text x="%.1f" y="%.1f" fill="%v" text-anchor="middle" dominant-baseline="middle" font-size="14">×</text></g>`+"\n",
+				event.Trace, html.EscapeString(describeMessage(event, Event{Node: event.Peer})), p.x, p.y, toX, toY, colours[event.Type], toX, toY, colours[event.Type])
+		}
+	}
+
+	for _, event := range l.shown {
+		p := l.points[event.Id]
+		fill := "#444"
+		switch event.Kind {
+		case SEND, RECEIVE:
+			fill = colours[event.Type]
+		}
+		fmt.Fprintf(b, `<circle class="event" data-trace="%v" cx="%.1f" cy="%.1f" r="%v" fill="%v"><title>%v</title></circle>`+"\n",
+			event.Trace, p.x, p.y, DOT_RADIUS, fill, html.EscapeString(describe(event)))
+		if event.Kind == LOCAL {
+			fmt.Fprintf(b, `<text x="%.1f" y="%.1f" text-anchor="start" transform="rotate(-30 %.1f %.1f)">%v</text>`+"\n",
+				p.x, p.y-8, p.x, p.y-8, html.EscapeString(event.Type))
+		}
+	}
+
+	for i, messageType := range types {
+		x := 10 + float64(i%4)*200
+		y := l.height + float64(i/4)*20
+		fmt.Fprintf(b, `<line x1="%.0f" y1="%.0f" x2="%.0f" y2="%.0f" stroke="%v" stroke-width="3"/><text x="%.0f" y="%.0f" dominant-baseline="middle">%v</text>`+"\n",
+			x, y, x+20, y, colours[messageType], x+26, y, html.EscapeString(messageType))
+	}
+	b.WriteString("</svg>\n")
+	_, err := io.WriteString(w, b.String())
+	return err
+}
+
+// WriteHTML writes the SVG diagram in a page of its own. Hovering over an event or a message highlights every
+// event and message of its trace, e.g. everything one Ivy write caused
+func (s SpaceTime) WriteHTML(w io.Writer, events []Event) error {
+	title := s.Title
+	if title == "" {
+		title = "Space-time diagram"
+	}
+	fmt.Fprintf(w, `<!DOCTYPE html>
+<html>
+<head>
+<meta charset="utf-8">
+<title>%v</title>
+<style>
+body { font-family: sans-serif; margin: 0; }
+.diagram { overflow-x: auto; }
+.dim .event, .dim .message { opacity: 0.15; }
+.dim .highlight { opacity: 1; }
+</style>
+</head>
+<body>
+<div class="diagram">
+`, html.EscapeString(title))
+	if err := s.WriteSVG(w, events); err != nil {
+		return err
+	}
+	_, err := io.WriteString(w, `</div>
+<script>
+const svg = document.querySelector("svg");
+svg.addEventListener("mouseover", e => {
+	const trace = e.target.closest("[data-trace]");
+	if (!trace) return;
+	svg.classList.add("dim");
+	svg.querySelectorAll("[data-trace='" + trace.dataset.trace + "']").forEach(el => el.classList.add("highlight"));
+});
+svg.addEventListener("mouseout", () => {
+	svg.classList.remove("dim");
+	svg.querySelectorAll(".highlight").forEach(el => el.classList.remove("highlight"));
+});
+</script>
+</body>
+</html>
+`)
+	return err
+}
+
+// messageTypes returns the types of the messages shown, sorted
+func messageTypes(events []Event) []string {
+	seen := map[string]bool{}
+	types := []string{}
+	for _, event := range events {
+		if event.Kind != LOCAL && !seen[event.Type] {
+			seen[event.Type] = true
+			types = append(types, event.Type)
+		}
+	}
+	sort.Strings(types)
+	return types
+}
+
+func describe(event Event) string {
+	text := fmt.Sprintf("%v: %v %v, Lamport %v, %v", event.NodeName, event.Kind, event.Type, event.Lamport, time.Unix(0, event.Time).UTC().Format("15:04:05.000000"))
+	if event.PageId != NO_PAGE {
+		text += fmt.Sprintf(", page %v", event.PageId)
+	}
+	return text
+}
+
+func describeMessage(send Event, receive Event) string {
+	text := fmt.Sprintf("%v from %v to %v", send.Type, send.NodeName, receive.NodeName)
+	if receive.NodeName == "" {
+		text = fmt.Sprintf("%v from %v to node %v", send.Type, send.NodeName, send.Peer)
+	}
+	if send.PageId != NO_PAGE {
+		text += fmt.Sprintf(", page %v", send.PageId)
+	}
+	if receive.Time > send.Time {
+		text += fmt.Sprintf(", %v on the network", time.Duration(receive.Time-send.Time))
+	}
+	return text
+}
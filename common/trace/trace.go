@@ -0,0 +1,256 @@
+// Package trace records what every node does as a stream of structured events: which node, its Lamport time,
+// the message or action, the page it is about and the event that caused it. Events are written as JSON lines and can be
+// exported as a Chrome trace or for Jaeger (see export.go and common/cmd/traceexport) to see a run as a timeline.
+package trace
+
+import (
+	"bufio"
+	"encoding/json"
+	"os"
+	"sort"
+	"sync"
+	"time"
+)
+
+const SEND = "send"
+const RECEIVE = "receive"
+const LOCAL = "local"
+
+// NO_PAGE is the PageId of an event that isn't about a page
+const NO_PAGE = -1
+const NO_PEER = -1
+
+// Event is one line of a trace file
+type Event struct {
+	Id       int64  // unique across every node and file, see Tracer
+	Parent   int64  `json:",omitempty"` // a receive's send, otherwise the node's previous event. 0 for the first event of a trace
+	Trace    int64  // Id of the event the causal chain started from, see Tracer.Begin
+	Node     int    // same ids as common/faults
+	NodeName string // e.g. "CM 1", for the exported timelines
+	Kind     string // SEND, RECEIVE or LOCAL
+	Type     string // message type, or what happened for LOCAL
+	PageId   int    // NO_PAGE if it isn't about a page
+	Peer     int    // send: the receiver, receive: the sender, NO_PEER for LOCAL
+	Lamport  int64
+	Time     int64 // UnixNano, virtual in a simulation
+}
+
+// Context is what a message carries so its receive can be tied to its send
+type Context struct {
+	Id      int64 // the send event, 0 if the message wasn't traced
+	Trace   int64
+	Node    int
+	Lamport int64
+}
+
+// Info describes a message for its send and receive events
+type Info struct {
+	Type   string
+	PageId int
+}
+
+// Clock gives events their Time, sim.WallClock, a sim.Runtime or a *sim.Simulator
+type Clock interface {
+	Now() time.Time
+}
+
+// Recorder writes the events of every Tracer made from it to one file.
+// Events go straight to the file, so a node that is killed mid run leaves a usable trace behind
+type Recorder struct {
+	mu      sync.Mutex
+	file    *os.File
+	encoder *json.Encoder
+	tracers map[int]*Tracer
+}
+
+func NewRecorder(path string) (*Recorder, error) {
+	file, err := os.Create(path)
+	if err != nil {
+		return nil, err
+	}
+	return &Recorder{file: file, encoder: json.NewEncoder(file), tracers: map[int]*Tracer{}}, nil
+}
+
+// Open makes a Recorder writing to path, or returns nil if path is empty
+func Open(path string) (*Recorder, error) {
+	if path == "" {
+		return nil, nil
+	}
+	return NewRecorder(path)
+}
+
+// Tracer returns the tracer of one node. Asking again for the same node, e.g. for the next round of a program that
+// remakes its nodes, gives the same tracer so event ids stay unique, with its events timed by the new clock.
+// Safe to call on a nil Recorder, which returns a nil Tracer
+func (r *Recorder) Tracer(node int, name string, clock Clock) *Tracer {
+	if r == nil {
+		return nil
+	}
+	r.mu.Lock()
+	tracer, ok := r.tracers[node]
+	if !ok {
+		tracer = &Tracer{Node: node, recorder: r}
+		r.tracers[node] = tracer
+	}
+	// a tracer takes r.mu under its own lock to write an event, so r.mu can't be held here
+	r.mu.Unlock()
+	tracer.mu.Lock()
+	defer tracer.mu.Unlock()
+	tracer.Name = name
+	tracer.clock = clock
+	return tracer
+}
+
+func (r *Recorder) write(event Event) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.encoder.Encode(event)
+}
+
+// Close closes the file. Safe to call on a nil Recorder
+func (r *Recorder) Close() error {
+	if r == nil {
+		return nil
+	}
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	return r.file.Close()
+}
+
+// Tracer records the events of one node and keeps its Lamport clock.
+// Every method is safe to call on a nil Tracer, which records nothing, and from any goroutine
+type Tracer struct {
+	Node int
+	Name string
+
+	recorder *Recorder
+	clock    Clock
+	mu       sync.Mutex
+	lamport  int64
+	seq      int64
+	last     int64 // the node's previous event
+	trace    int64 // the trace the node's previous event is in
+}
+
+// Begin records the node starting something on its own, like a read or an election, as the first event of a new trace
+func (t *Tracer) Begin(what string, pageId int) {
+	if t == nil {
+		return
+	}
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	t.lamport++
+	event := t.event(LOCAL, what, pageId, NO_PEER)
+	event.Parent = 0
+	event.Trace = event.Id
+	t.record(event)
+}
+
+// Local records something that happened on the node, in the trace of whatever it did last
+func (t *Tracer) Local(what string, pageId int) {
+	if t == nil {
+		return
+	}
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	t.lamport++
+	t.record(t.event(LOCAL, what, pageId, NO_PEER))
+}
+
+// Resume records the node going back to work it was asked to do earlier by a message it kept, in that message's trace
+func (t *Tracer) Resume(what string, pageId int, ctx Context) {
+	if t == nil {
+		return
+	}
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	t.lamport++
+	event := t.event(LOCAL, what, pageId, NO_PEER)
+	if ctx.Id != 0 {
+		event.Parent = ctx.Id
+		event.Trace = ctx.Trace
+	}
+	t.record(event)
+}
+
+// Send records a message to node to and returns the Context the message has to carry
+func (t *Tracer) Send(to int, info Info) Context {
+	if t == nil {
+		return Context{}
+	}
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	t.lamport++
+	event := t.event(SEND, info.Type, info.PageId, to)
+	t.record(event)
+	return Context{Id: event.Id, Trace: event.Trace, Node: t.Node, Lamport: t.lamport}
+}
+
+// Receive records a message arriving. The node's clock moves past the sender's and it carries on in the sender's trace
+func (t *Tracer) Receive(ctx Context, info Info) {
+	if t == nil {
+		return
+	}
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	if ctx.Lamport > t.lamport {
+		t.lamport = ctx.Lamport
+	}
+	t.lamport++
+	event := t.event(RECEIVE, info.Type, info.PageId, ctx.Node)
+	if ctx.Id != 0 {
+		event.Parent = ctx.Id
+		event.Trace = ctx.Trace
+	}
+	t.record(event)
+}
+
+// event fills in an event after the node's previous one. Ids are the node (plus one) in the top half and a count
+// in the bottom half, so processes tracing to separate files never make the same id
+func (t *Tracer) event(kind string, what string, pageId int, peer int) Event {
+	t.seq++
+	id := int64(t.Node+1)<<32 | t.seq
+	trace := t.trace
+	if trace == 0 {
+		trace = id
+	}
+	return Event{Id: id, Parent: t.last, Trace: trace, Node: t.Node, NodeName: t.Name, Kind: kind, Type: what,
+		PageId: pageId, Peer: peer, Lamport: t.lamport, Time: t.clock.Now().UnixNano()}
+}
+
+func (t *Tracer) record(event Event) {
+	t.last = event.Id
+	t.trace = event.Trace
+	t.recorder.write(event)
+}
+
+// Load reads and merges trace files, stopping at a torn last line of each, sorted by Time then Lamport time
+func Load(paths ...string) ([]Event, error) {
+	events := []Event{}
+	for _, path := range paths {
+		file, err := os.Open(path)
+		if err != nil {
+			return nil, err
+		}
+		scanner := bufio.NewScanner(file)
+		scanner.Buffer(make([]byte, 64*1024), 64*1024*1024)
+		for scanner.Scan() {
+			event := Event{}
+			if err := json.Unmarshal(scanner.Bytes(), &event); err != nil {
+				break
+			}
+			events = append(events, event)
+		}
+		file.Close()
+		if err := scanner.Err(); err != nil {
+			return nil, err
+		}
+	}
+	sort.SliceStable(events, func(i, j int) bool {
+		if events[i].Time != events[j].Time {
+			return events[i].Time < events[j].Time
+		}
+		return events[i].Lamport < events[j].Lamport
+	})
+	return events, nil
+}
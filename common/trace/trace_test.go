@@ -0,0 +1,56 @@
+package trace
+
+import (
+	"distsys/common/sim"
+	"path/filepath"
+	"sync"
+	"testing"
+	"time"
+)
+
+func TestTracerWhileRecording(t *testing.T) {
+	/**
+	A program remaking its nodes asks for a node's tracer again while the old node can still be recording events,
+	which used to take the recorder's and the tracer's locks in opposite orders
+	*/
+	path := filepath.Join(t.TempDir(), "trace.jsonl")
+	r, err := NewRecorder(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	tracer := r.Tracer(0, "node", sim.WallClock{})
+
+	var wg sync.WaitGroup
+	wg.Add(2)
+	go func() {
+		defer wg.Done()
+		for i := 0; i < 10000; i++ {
+			tracer.Local("work", 0)
+		}
+	}()
+	go func() {
+		defer wg.Done()
+		for i := 0; i < 10000; i++ {
+			r.Tracer(0, "node", sim.WallClock{})
+		}
+	}()
+	done := make(chan bool)
+	go func() {
+		wg.Wait()
+		close(done)
+	}()
+	select {
+	case <-done:
+	case <-time.After(10 * time.Second):
+		t.Fatal("recording deadlocked with asking for the tracer")
+	}
+	r.Close()
+
+	events, err := Load(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(events) != 10000 {
+		t.Errorf("loaded %v events, want 10000", len(events))
+	}
+}